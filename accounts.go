@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// queryAccount is a ServerQuery login the bot can use.
+type queryAccount struct {
+	user     string
+	password secret
+}
+
+// accountOps are the queries every account the bot works with may run. Lacking a permission for one of
+// them means the account's permissions were revoked, while e.g. a failed move may just concern one client.
+var accountOps = map[string]bool{"serverlist": true, "use": true, "channellist": true, "clientlist": true, "clientinfo": true}
+
+// accountIndex is the index of the account the bot logs in with, in the order of queryAccounts.
+var accountIndex int
+
+// announcedAccount is the index of the account the admins were last told the bot uses.
+var announcedAccount int
+
+// accountSwitchReason says why the bot stopped using the previous account, for the alert to the admins.
+var accountSwitchReason string
+
+// accountSwitchPending asks the main loop to connect again with the next account after the current one lost a permission.
+var accountSwitchPending bool
+
+// revokedAccounts holds the indexes of the accounts that lost a permission, they are not switched to again until a restart.
+var revokedAccounts = make(map[int]bool)
+
+// parseAccounts reads TS3_FALLBACK_ACCOUNTS, user:password entries as JSON array or comma-separated list.
+// Errors name the entry by position, so they never contain a password.
+func parseAccounts(value string) ([]queryAccount, error) {
+	list, err := parseStringList(value)
+	if err != nil {
+		return nil, errors.New("not a valid list of user:password entries")
+	}
+	accounts := make([]queryAccount, 0, len(list))
+	for i, entry := range list {
+		user, password, found := strings.Cut(entry, ":")
+		if !found || strings.TrimSpace(user) == "" {
+			return nil, fmt.Errorf("entry %d is not a user:password pair", i+1)
+		}
+		accounts = append(accounts, queryAccount{user: strings.TrimSpace(user), password: secret(password)})
+	}
+	return accounts, nil
+}
+
+// queryAccounts returns TS3_USER followed by the TS3_FALLBACK_ACCOUNTS, in the order the bot switches through them.
+func queryAccounts(config Config) []queryAccount {
+	// validateConfig rejected a list that does not parse.
+	fallbacks, _ := parseAccounts(config.FallbackAccounts.value())
+	return append([]queryAccount{{user: config.UserName, password: config.Password}}, fallbacks...)
+}
+
+// currentAccount returns the account the bot logs in with.
+func currentAccount(config Config) queryAccount {
+	accounts := queryAccounts(config)
+	return accounts[accountIndex%len(accounts)]
+}
+
+// nextAccount switches to the next account that did not lose a permission and reports whether there is one.
+func nextAccount(config Config, reason string) bool {
+	accounts := queryAccounts(config)
+	for i := 1; i < len(accounts); i++ {
+		next := (accountIndex + i) % len(accounts)
+		if !revokedAccounts[next] {
+			zap.S().Warnf("Switching from query account %s to %s: %s", accounts[accountIndex].user, accounts[next].user, reason)
+			accountIndex, accountSwitchReason = next, reason
+			return true
+		}
+	}
+	return false
+}
+
+// login logs client in with the current account. If the server rejects it, e.g. after its password was
+// changed, the other accounts are tried in turn and the first one that works becomes the current one.
+func login(client *ts3.Client, config Config) error {
+	var errs []error
+	for attempt := 0; attempt < len(queryAccounts(config)); attempt++ {
+		account := currentAccount(config)
+		err := client.Login(account.user, account.password.value())
+		if err == nil {
+			return nil
+		}
+		if classifyExit(err) != exitAuth {
+			return err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", account.user, err))
+		if !nextAccount(config, fmt.Sprintf("the server rejected the login of %s", account.user)) {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// accountPermissionLost switches to the next account after the current one lacked a permission for op,
+// which every account needs. The main loop connects again with it before the next sweep.
+func accountPermissionLost(config Config, op string) {
+	if !accountOps[op] || accountSwitchPending {
+		return
+	}
+	revokedAccounts[accountIndex] = true
+	if nextAccount(config, fmt.Sprintf("%s lacks a permission needed for %s", currentAccount(config).user, op)) {
+		accountSwitchPending = true
+	}
+}
+
+// announceAccount tells the admins which account the bot uses after it switched accounts:
+// in the log, as an error event for the webhooks and in a private message to the online admins.
+func announceAccount(client *ts3.Client, config Config) {
+	if accountIndex == announcedAccount {
+		return
+	}
+	announcedAccount = accountIndex
+	accounts := queryAccounts(config)
+	msg := fmt.Sprintf("Now using query account %s (%d of %d) because %s", accounts[accountIndex].user, accountIndex+1, len(accounts), accountSwitchReason)
+	zap.S().Warn(msg)
+	events.publish(botEvent{Type: "error", Message: msg})
+	if !config.AllServers {
+		notifyAdmins(client, config, "AFK bot: "+msg)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "go.uber.org/zap"
+
+// weightedChannel is an entry of TS3_AFK_CHANNELS.
+type weightedChannel struct {
+	Name   string
+	Weight int
+}
+
+// afkChannel is an AFK channel found in the channel list of a sweep.
+type afkChannel struct {
+	id         int
+	name       string
+	weight     int
+	maxClients int
+	// clients counts the clients in the channel, including those moved there during the sweep.
+	clients int
+}
+
+// resolveAfkChannels looks up the AFK channel and the additional ones of TS3_AFK_CHANNELS in the
+// channel list. The AFK channel comes first, with weight 1 unless it is listed with another weight.
+func (s *sweep) resolveAfkChannels() {
+	s.afkChannels = nil
+	primary := &afkChannel{id: s.afkChannelId, name: s.config.AfkChannelName, weight: 1, maxClients: -1}
+	if channel, ok := s.tree.byID[s.afkChannelId]; ok {
+		primary.maxClients = channel.MaxClients
+	}
+	s.afkChannels = append(s.afkChannels, primary)
+	for _, entry := range s.config.AfkChannels {
+		if entry.Name == s.config.AfkChannelName {
+			primary.weight = entry.Weight
+			continue
+		}
+		channel := s.tree.byName(entry.Name)
+		if channel == nil {
+			zap.S().Warnf("AFK channel %q not found, not moving users there", entry.Name)
+			continue
+		}
+		s.afkChannels = append(s.afkChannels, &afkChannel{id: channel.ID, name: entry.Name, weight: entry.Weight, maxClients: channel.MaxClients})
+	}
+	for _, c := range s.clients {
+		if channel := s.afkChannelByID(c.ChannelID); channel != nil {
+			channel.clients++
+		}
+	}
+}
+
+// afkChannelByID returns the AFK channel with the given ID, or nil if it is no AFK channel.
+func (s *sweep) afkChannelByID(id int) *afkChannel {
+	for _, channel := range s.afkChannels {
+		if channel.id == id {
+			return channel
+		}
+	}
+	return nil
+}
+
+// isAfkChannel reports whether id is one of the AFK channels.
+func (s *sweep) isAfkChannel(id int) bool {
+	return id == s.afkChannelId || s.afkChannelByID(id) != nil
+}
+
+// pickAfkChannel returns the AFK channel with the fewest clients relative to its weight that is not full
+// and that allowed accepts. If all of them are full the AFK channel is used and the server decides.
+// It returns 0 if allowed accepts none of them.
+func (s *sweep) pickAfkChannel(allowed func(id int) bool) int {
+	var best *afkChannel
+	for _, channel := range s.afkChannels {
+		if !allowed(channel.id) || (channel.maxClients >= 0 && channel.clients >= channel.maxClients) {
+			continue
+		}
+		// Compare clients/weight without dividing.
+		if best == nil || channel.clients*best.weight < best.clients*channel.weight {
+			best = channel
+		}
+	}
+	if best == nil {
+		if allowed(s.afkChannelId) {
+			return s.afkChannelId
+		}
+		return 0
+	}
+	return best.id
+}
+
+// countAfkMove counts a client moved into channel id, if it is an AFK channel.
+func (s *sweep) countAfkMove(id int) {
+	if channel := s.afkChannelByID(id); channel != nil {
+		channel.clients++
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// Where the bot announces itself when it comes online, set with TS3_ANNOUNCE.
+const (
+	announceOff     = "off"
+	announceServer  = "server"
+	announceChannel = "channel"
+)
+
+// chatCommand describes a chat command for !help.
+type chatCommand struct {
+	usage       string
+	description string
+	admin       bool
+}
+
+// chatCommands lists all chat commands in the order !help shows them.
+var chatCommands = []chatCommand{
+	{"!help", "List the commands you can use", false},
+	{"!notify [poke|msg|none]", "Choose how you are warned before being moved", false},
+	{"!whymoved [count]", "Show when and why you were last moved", false},
+	{"!language [code|auto]", "Choose the language of my messages", false},
+	{"!pause [minutes] [reason]", "Pause moves", true},
+	{"!resume", "Resume moves", true},
+	{"!sweep [minutes]", "Move everybody idle for longer than minutes right away", true},
+	{"!confirm", "Move the users of a sweep held back for confirmation", true},
+	{"!undo [minutes]", "Move the users of the last sweep, or of the last minutes, back", true},
+	{"!trace on|off", "Switch the query trace on or off", true},
+	{"!profile [name|auto]", "Show or switch the policy profile", true},
+	{"!settings", "List the settings that can be changed at runtime", true},
+	{"!set <name> <value>", "Change a setting", true},
+	{"!reset <name>", "Use the configured value of a setting again", true},
+}
+
+// findChatCommand returns the chat command called name, or nil if there is none.
+func findChatCommand(name string) *chatCommand {
+	for i := range chatCommands {
+		if strings.Fields(chatCommands[i].usage)[0] == name {
+			return &chatCommands[i]
+		}
+	}
+	return nil
+}
+
+// helpText lists the chat commands available to a client, the admin commands are always described in English.
+func helpText(config Config, admin bool, language string) string {
+	lines := []string{translate(language, "help.intro", shortDuration(time.Duration(config.MaxIdleTimeMs)*time.Millisecond), config.AfkChannelName)}
+	for _, command := range chatCommands {
+		if command.admin && !admin {
+			continue
+		}
+		description := command.description
+		if !command.admin {
+			description = translate(language, "help."+strings.TrimPrefix(strings.Fields(command.usage)[0], "!"))
+		}
+		lines = append(lines, fmt.Sprintf("%s - %s", command.usage, description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// shortDuration formats d like time.Duration.String without trailing zero units, e.g. 15m instead of 15m0s.
+func shortDuration(d time.Duration) string {
+	s := d.Round(time.Second).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// announce tells the clients that the bot is online, in the server chat or in the channel the bot is in.
+func announce(client *ts3.Client, config Config) {
+	if config.Announce == announceOff {
+		return
+	}
+	msg := fmt.Sprintf("AFK mover active, threshold %s, type !help in a private message to me.",
+		shortDuration(time.Duration(config.MaxIdleTimeMs)*time.Millisecond))
+	send := func() {
+		var err error
+		if config.Announce == announceChannel {
+			err = sendChannelMessage(client, msg)
+		} else {
+			err = sendServerMessage(client, serverID, msg)
+		}
+		if err != nil {
+			zap.S().Errorf("Failed to announce the bot on virtual server %d: %v", serverID, err)
+		}
+	}
+	if !config.AllServers {
+		send()
+		return
+	}
+	forEachServer(client, config, send)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// anomalyMinSamples is the number of clients or channels a sweep must compare against before the
+// lists or idle times can look wrong; on a nearly empty server every change would.
+const anomalyMinSamples = 5
+
+// anomalyAcceptSweeps is the number of sweeps in a row with the same list anomaly after which it is
+// taken as the new state of the server, e.g. after a restart that disconnected everybody.
+const anomalyAcceptSweeps = 3
+
+// anomalyBaseline is what the last sweep without anomalies saw, per virtual server with TS3_ALL_SERVERS.
+type anomalyBaseline struct {
+	clients  int
+	channels int
+	// listStreak and idleStreak count the sweeps in a row with anomalous lists and idle times,
+	// the admins are alerted on the first one.
+	listStreak int
+	idleStreak int
+}
+
+var anomalies anomalyBaseline
+
+// userCount returns the number of clients that are not ServerQuery clients, such as the bot itself.
+func userCount(clients []*clientInfo) int {
+	users := 0
+	for _, c := range clients {
+		if c.Type != 1 {
+			users++
+		}
+	}
+	return users
+}
+
+// listAnomaly reports whether the client or channel list of the sweep looks corrupted compared to
+// the previous sweep: no users after many, or far fewer channels. The sweep is then skipped.
+func (s *sweep) listAnomaly(channels []*channelInfo) bool {
+	if !s.config.AnomalyDetection {
+		return false
+	}
+	users := userCount(s.clients)
+	var problems []string
+	if users == 0 && anomalies.clients >= anomalyMinSamples {
+		problems = append(problems, fmt.Sprintf("the client list is empty, the previous sweep saw %d users", anomalies.clients))
+	}
+	if anomalies.channels >= anomalyMinSamples && (anomalies.channels-len(channels))*100 >= anomalies.channels*s.config.AnomalyChannelDrop {
+		problems = append(problems, fmt.Sprintf("the channel list shrank from %d to %d channels", anomalies.channels, len(channels)))
+	}
+	if len(problems) > 0 && anomalies.listStreak+1 < anomalyAcceptSweeps {
+		anomalies.listStreak++
+		s.reportAnomaly("list", anomalies.listStreak, strings.Join(problems, " and ")+", skipping the sweep")
+		return true
+	}
+	anomalies.listStreak = 0
+	if len(problems) > 0 {
+		zap.S().Warnf("Taking the lists as they are after %d sweeps: %s", anomalyAcceptSweeps, strings.Join(problems, " and "))
+	}
+	anomalies.clients, anomalies.channels = users, len(channels)
+	return false
+}
+
+// holdForAnomaly holds back the planned moves and returns of a dry run if every client whose details were queried
+// reported an idle time of 0, which a server does after a hiccup rather than when everybody is active.
+func (s *sweep) holdForAnomaly(statuses []clientStatus) bool {
+	if !s.config.AnomalyDetection || s.idleSamples < anomalyMinSamples || s.zeroIdleSamples < s.idleSamples {
+		anomalies.idleStreak = 0
+		return false
+	}
+	for i := range statuses {
+		if statuses[i].Status == statusWouldMove || statuses[i].Status == statusWouldReturn {
+			statuses[i].Status = statusAnomaly
+		}
+	}
+	anomalies.idleStreak++
+	s.reportAnomaly("idle", anomalies.idleStreak, fmt.Sprintf("all %d clients queried report an idle time of 0, holding back moves", s.idleSamples))
+	return true
+}
+
+// reportAnomaly logs an anomaly of the given kind, the streak-th in a row, and reports it in the
+// health state. The first one in a row is also published and sent to the admins.
+func (s *sweep) reportAnomaly(kind string, streak int, msg string) {
+	metrics.count("sweeps.anomalies", 1, "kind:"+kind)
+	reportSweepProblem(healthAnomaly, msg)
+	if streak > 1 {
+		zap.S().Debugf("Anomaly persists: %s", msg)
+		return
+	}
+	msg = "Anomaly: " + msg
+	zap.S().Warn(msg)
+	events.publish(botEvent{Type: "anomaly", Message: msg})
+	notifyAdmins(s.client, s.config, "AFK bot: "+msg)
+}
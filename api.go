@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// startAPIServer serves the HTTP API on addr in the background.
+func startAPIServer(addr string, adminToken string) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           apiHandler(adminToken),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		zap.S().Infof("Serving API on %s", addr)
+		if err := server.ListenAndServe(); err != nil {
+			zap.S().Errorf("API server stopped: %v", err)
+		}
+	}()
+}
+
+// apiHandler routes the HTTP API. Admin endpoints require adminToken as bearer token.
+func apiHandler(adminToken string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.Handle("/overrides", requireToken(adminToken, http.HandlerFunc(handleOverrideList)))
+	mux.Handle("/overrides/", requireToken(adminToken, http.HandlerFunc(handleOverride)))
+	mux.Handle("/trace", requireToken(adminToken, http.HandlerFunc(handleTrace)))
+	mux.Handle("/state", requireToken(adminToken, http.HandlerFunc(handleState)))
+	mux.Handle("/exemptions", requireToken(adminToken, http.HandlerFunc(handleExemptions)))
+	mux.Handle("/occupancy", requireToken(adminToken, http.HandlerFunc(handleOccupancy)))
+	mux.Handle("/stats/occupancy", requireToken(adminToken, http.HandlerFunc(handleOccupancyStats)))
+	mux.Handle("/stats/totals", requireToken(adminToken, http.HandlerFunc(handleTotals)))
+	mux.Handle("/events", requireStreamToken(adminToken, http.HandlerFunc(handleEvents)))
+	mux.Handle("/pause", requireToken(adminToken, http.HandlerFunc(handlePause)))
+	mux.Handle("/resume", requireToken(adminToken, http.HandlerFunc(handleResume)))
+	mux.Handle("/settings", requireToken(adminToken, http.HandlerFunc(handleSettingList)))
+	mux.Handle("/settings/", requireToken(adminToken, http.HandlerFunc(handleSetting)))
+	mux.Handle("/profile", requireToken(adminToken, http.HandlerFunc(handleProfile)))
+	mux.Handle("/config/diff", requireToken(adminToken, http.HandlerFunc(handleConfigDiff)))
+	mux.Handle("/config/reload", requireToken(adminToken, http.HandlerFunc(handleConfigReload)))
+	mux.Handle("/sweep", requireToken(adminToken, http.HandlerFunc(handleSweep)))
+	mux.Handle("/sweep/confirm", requireToken(adminToken, http.HandlerFunc(handleSweepConfirm)))
+	return mux
+}
+
+// requireToken only lets requests through that carry token as bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireStreamToken is requireToken for the event stream. Browsers cannot set headers on WebSocket
+// requests, so there the token may also be passed as token query parameter. It is removed from the
+// request before it is handled, so it cannot end up in logs.
+func requireStreamToken(token string, next http.Handler) http.Handler {
+	checked := requireToken(token, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if query := r.URL.Query(); query.Has("token") {
+			given := query.Get("token")
+			query.Del("token")
+			r = r.Clone(r.Context())
+			r.URL.RawQuery = query.Encode()
+			r.RequestURI = r.URL.RequestURI()
+			if r.Header.Get("Authorization") == "" {
+				r.Header.Set("Authorization", "Bearer "+given)
+			}
+		}
+		checked.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.S().Warnf("Failed to write API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// healthResponse is the body of GET /healthz.
+type healthResponse struct {
+	Status    string     `json:"status"`
+	LastSweep *time.Time `json:"last_sweep,omitempty"`
+	healthStatus
+	// Subsystems lists the optional subsystems that are failing.
+	Subsystems []subsystemStatus `json:"subsystems"`
+	buildInfo
+}
+
+// handleHealth serves GET /healthz, which needs no token so load balancers and monitoring can use it.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	res := healthResponse{Status: "ok", healthStatus: health.status(), Subsystems: subsystems.list(), buildInfo: currentBuild()}
+	if last := latestSweep().Time; !last.IsZero() {
+		res.LastSweep = &last
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleOverrideList serves GET /overrides.
+func handleOverrideList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, clientOverrides.all())
+}
+
+// handleOverride serves GET, PUT and DELETE /overrides/{uid}.
+// Unique identifiers are base64 and may contain slashes, so they have to be path-escaped by the caller.
+func handleOverride(w http.ResponseWriter, r *http.Request) {
+	uid, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/overrides/"))
+	if err != nil || uid == "" {
+		writeError(w, http.StatusBadRequest, "invalid client unique identifier")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		override, ok := clientOverrides.get(uid)
+		if !ok {
+			writeError(w, http.StatusNotFound, "no override for this client")
+			return
+		}
+		writeJSON(w, http.StatusOK, override)
+	case http.MethodPut:
+		var override ClientOverride
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&override); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid override: %v", err))
+			return
+		}
+		if override.MaxIdleTimeSec < 0 {
+			writeError(w, http.StatusBadRequest, "max_idle_time_sec must not be negative")
+			return
+		}
+		if err = clientOverrides.set(uid, override); err != nil {
+			zap.S().Errorf("Failed to save override for %s: %v", privacy.uid(uid), err)
+			writeError(w, http.StatusInternalServerError, "override applied but could not be saved")
+			return
+		}
+		zap.S().Infof("Override for %s set via API: %+v", privacy.uid(uid), override)
+		writeJSON(w, http.StatusOK, override)
+	case http.MethodDelete:
+		if err = clientOverrides.delete(uid); err != nil {
+			zap.S().Errorf("Failed to save overrides after deleting %s: %v", privacy.uid(uid), err)
+			writeError(w, http.StatusInternalServerError, "override removed but could not be saved")
+			return
+		}
+		zap.S().Infof("Override for %s removed via API", privacy.uid(uid))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// pauseRequest is the body of POST /pause.
+type pauseRequest struct {
+	// DurationMin of 0 pauses until resumed, nil uses TS3_PAUSE_DEFAULT_MIN.
+	DurationMin *int   `json:"duration_min"`
+	Reason      string `json:"reason"`
+}
+
+// exemptionList is the response of GET /exemptions.
+type exemptionList struct {
+	UIDs        []string `json:"uids"`
+	DatabaseIDs []int    `json:"database_ids"`
+}
+
+// handleExemptions serves GET /exemptions, the exempt clients by unique identifier and by database ID.
+func handleExemptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, currentExemptions())
+}
+
+// currentExemptions lists the exempt clients by unique identifier and by database ID.
+func currentExemptions() exemptionList {
+	list := exemptionList{UIDs: []string{}, DatabaseIDs: exemptDatabaseIDs.all()}
+	for uid, override := range clientOverrides.all() {
+		if override.Exempt {
+			list.UIDs = append(list.UIDs, uid)
+		}
+	}
+	sort.Strings(list.UIDs)
+	return list
+}
+
+// handlePause serves GET and POST /pause.
+func handlePause(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, pause.status(clock.Now()))
+	case http.MethodPost:
+		var req pauseRequest
+		if r.ContentLength != 0 {
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid pause request: %v", err))
+				return
+			}
+		}
+		duration := pause.defaultDuration
+		if req.DurationMin != nil {
+			if *req.DurationMin < 0 {
+				writeError(w, http.StatusBadRequest, "duration_min must not be negative")
+				return
+			}
+			duration = time.Duration(*req.DurationMin) * time.Minute
+		}
+		writeJSON(w, http.StatusOK, pauseBot(duration, req.Reason, "API"))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleResume serves POST /resume.
+func handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	resumeBot("API")
+	writeJSON(w, http.StatusOK, pause.status(clock.Now()))
+}
+
+// sweepRequest is the body of POST /sweep.
+type sweepRequest struct {
+	// ThresholdMin defaults to TS3_MAX_IDLE_TIME_SEC.
+	ThresholdMin int  `json:"threshold_min"`
+	Confirm      bool `json:"confirm"`
+}
+
+// handleSweep serves POST /sweep, which moves all clients idle beyond the threshold right away.
+// If more than TS3_SWEEP_CONFIRM_LIMIT clients are affected it answers 409 until repeated with confirm set.
+func handleSweep(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var body sweepRequest
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid sweep request: %v", err))
+			return
+		}
+	}
+	if body.ThresholdMin < 0 {
+		writeError(w, http.StatusBadRequest, "threshold_min must not be negative")
+		return
+	}
+
+	req := massSweepRequest{thresholdMs: body.ThresholdMin * 60 * 1000, confirmed: body.Confirm, source: "API", result: make(chan massSweepResult, 1)}
+	select {
+	case massSweepRequests <- req:
+	case <-r.Context().Done():
+		return
+	}
+	result := <-req.result
+	switch {
+	case result.Error != "":
+		writeJSON(w, http.StatusBadGateway, result)
+	case result.NeedsConfirmation:
+		writeJSON(w, http.StatusConflict, result)
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// handleSettingList serves GET /settings, listing the settings changed at runtime.
+func handleSettingList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, runtimeSettings.all())
+}
+
+// settingValue is the body of PUT /settings/{name}.
+type settingValue struct {
+	Value string `json:"value"`
+}
+
+// handleSetting serves PUT and DELETE /settings/{name}.
+func handleSetting(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/settings/")
+	if _, ok := runtimeSettingDefinitions[name]; !ok {
+		writeError(w, http.StatusNotFound, "unknown setting")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var body settingValue
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid setting: %v", err))
+			return
+		}
+		canonical, err := runtimeSettings.set(name, body.Value)
+		if canonical == "" {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			zap.S().Errorf("Failed to save setting %s: %v", name, err)
+			writeError(w, http.StatusInternalServerError, "setting applied but could not be saved")
+			return
+		}
+		writeJSON(w, http.StatusOK, settingValue{Value: canonical})
+	case http.MethodDelete:
+		if err := runtimeSettings.validate(name, ""); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := runtimeSettings.reset(name); err != nil {
+			zap.S().Errorf("Failed to delete setting %s: %v", name, err)
+			writeError(w, http.StatusInternalServerError, "setting reset but could not be saved")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleSweepConfirm serves POST /sweep/confirm, which lets a sweep held back by TS3_LARGE_SWEEP_LIMIT move its clients.
+func handleSweepConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !approveLargeSweep("API") {
+		writeError(w, http.StatusConflict, "no sweep is waiting for confirmation")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// traceStatus is the body of GET and PUT /trace.
+type traceStatus struct {
+	Enabled bool `json:"enabled"`
+}
+
+// handleTrace serves GET and PUT /trace, which switches the raw query trace on and off.
+func handleTrace(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req traceStatus
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+			return
+		}
+		setQueryTrace(req.Enabled, "API")
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, traceStatus{Enabled: queryTrace.Load()})
+}
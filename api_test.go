@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireTokenIgnoresQueryParameter(t *testing.T) {
+	handler := requireToken("token-5r9c", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/overrides?token=token-5r9c", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("token in query: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/overrides", nil)
+	req.Header.Set("Authorization", "Bearer token-5r9c")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("token in header: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRequireStreamTokenRemovesQueryParameter(t *testing.T) {
+	var seen *http.Request
+	handler := requireStreamToken("token-5r9c", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r }))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?token=token-5r9c&types=move", nil))
+	if rec.Code != http.StatusOK || seen == nil {
+		t.Fatalf("token in query: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if seen.RequestURI != "/events?types=move" || seen.URL.String() != "/events?types=move" {
+		t.Errorf("handler saw %s (%s), want the token removed", seen.RequestURI, seen.URL)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?token=wrong", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token in query: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
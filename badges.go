@@ -0,0 +1,46 @@
+package main
+
+import "strings"
+
+// badgeOverwolf names the Overwolf badge in TS3_EXEMPT_BADGES, which client_badges reports as a flag of its own.
+const badgeOverwolf = "overwolf"
+
+// clientBadges reads the badges a client shows from its client_badges value, e.g.
+// "Overwolf=1:badges=c2368518-3728-4260-bcd1-8b85e9f8984c,94ec66de-5940-4e38-b002-970df0cf6c94".
+// The badge identifiers are returned in lower case, with badgeOverwolf for the Overwolf badge.
+func clientBadges(value string) []string {
+	var badges []string
+	for _, field := range strings.Split(value, ":") {
+		key, list, _ := strings.Cut(field, "=")
+		switch strings.ToLower(key) {
+		case "overwolf":
+			if list == "1" {
+				badges = append(badges, badgeOverwolf)
+			}
+		case "badges":
+			for _, badge := range strings.Split(list, ",") {
+				if badge = strings.TrimSpace(badge); badge != "" {
+					badges = append(badges, strings.ToLower(badge))
+				}
+			}
+		}
+	}
+	return badges
+}
+
+// exemptAccount reports whether the client is exempt by its myTeamSpeak account, through
+// TS3_EXEMPT_MYTEAMSPEAK_IDS, or by a badge of TS3_EXEMPT_BADGES it shows.
+func exemptAccount(config Config, details *clientDetails) bool {
+	if details.MyTeamSpeakID != "" && containsString(config.ExemptMyTeamSpeakIDs, details.MyTeamSpeakID) {
+		return true
+	}
+	if len(config.ExemptBadges) == 0 {
+		return false
+	}
+	for _, badge := range clientBadges(details.Badges) {
+		if containsFold(config.ExemptBadges, badge) {
+			return true
+		}
+	}
+	return false
+}
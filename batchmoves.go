@@ -0,0 +1,89 @@
+package main
+
+import "go.uber.org/zap"
+
+// moveBatches moves the candidates of a sweep with one clientmove per target channel instead of one per client,
+// so clients subscribed to the AFK channel see all arrivals at once rather than spread over the sweep.
+func (s *sweep) moveBatches(statuses []clientStatus, candidates []int, clients map[int]*clientInfo) {
+	var targets []int
+	batches := make(map[int][]int)
+	planned := 0
+	for _, i := range candidates {
+		if _, ok := clients[statuses[i].ID]; !ok {
+			continue
+		}
+		if s.config.MaxMovesPerSweep > 0 && planned >= s.config.MaxMovesPerSweep {
+			statuses[i].Status = statusDeferred
+			continue
+		}
+		planned++
+		target := statuses[i].targetChannelID
+		if _, ok := batches[target]; !ok {
+			targets = append(targets, target)
+		}
+		batches[target] = append(batches[target], i)
+	}
+
+	for _, target := range targets {
+		batch := batches[target]
+		if len(batch) == 1 {
+			statuses[batch[0]] = s.move(clients[statuses[batch[0]].ID], statuses[batch[0]], target)
+		} else {
+			s.moveBatch(statuses, batch, clients, target)
+		}
+		if s.aborted {
+			return
+		}
+	}
+}
+
+// moveBatch moves the clients of the statuses at the given indexes into target with a single command.
+func (s *sweep) moveBatch(statuses []clientStatus, batch []int, clients map[int]*clientInfo, target int) {
+	if s.config.ObserveOnly {
+		for _, i := range batch {
+			statuses[i] = s.observe(clients[statuses[i].ID], statuses[i], target)
+		}
+		return
+	}
+	if s.pausedMidSweep() {
+		for _, i := range batch {
+			statuses[i].Status = statusPaused
+		}
+		return
+	}
+	ids := make([]int, 0, len(batch))
+	for _, i := range batch {
+		ids = append(ids, statuses[i].ID)
+	}
+	zap.S().Infof("Moving %d users to afk channel [%d] at once", len(ids), target)
+	err := moveClients(s.client, ids, target)
+	if err == nil {
+		for _, i := range batch {
+			statuses[i] = s.moved(clients[statuses[i].ID], statuses[i], target)
+		}
+		return
+	}
+
+	// The server stops at the first client that left or may not enter the channel, so some may have been moved.
+	// Find out which and move the others one by one, which handles every error on its own.
+	zap.S().Warnf("Moving %d users at once failed, moving them one by one: %v", len(ids), err)
+	// Without a client list every move is retried, and those already done fail as such.
+	current, _ := listClients(s.client)
+	inTarget := make(map[int]bool)
+	for _, c := range current {
+		if c.ChannelID == target {
+			inTarget[c.ID] = true
+		}
+	}
+	for _, i := range batch {
+		c := clients[statuses[i].ID]
+		if inTarget[c.ID] {
+			statuses[i] = s.moved(c, statuses[i], target)
+			continue
+		}
+		statuses[i] = s.move(c, statuses[i], target)
+		if s.aborted {
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+)
+
+// queryErrAlreadyMember is returned when moving a client into the channel it is already in.
+const queryErrAlreadyMember = 770
+
+// botClientID is the client ID of the bot's own ServerQuery connection.
+var botClientID int
+
+// botChannelID is the ID of the bot channel once the bot joined it.
+var botChannelID int
+
+// joinBotChannel moves the bot into TS3_BOT_CHANNEL, so it does not sit in the default channel where users poke it.
+func joinBotChannel(client *ts3.Client, config Config) {
+	if config.BotChannel == "" || botClientID == 0 || config.ObserveOnly {
+		return
+	}
+	channels, err := listChannels(client)
+	if err != nil {
+		zap.S().Errorf("Failed to list channels to join the bot channel: %v", err)
+		return
+	}
+	channel := newChannelTree(channels).byName(config.BotChannel)
+	if channel == nil {
+		zap.S().Warnf("Bot channel %q not found", config.BotChannel)
+		return
+	}
+	if err = moveClient(client, botClientID, channel.ID); err != nil {
+		if _, tsErr := classifyQueryError(err); tsErr != nil && tsErr.ID == queryErrAlreadyMember {
+			botChannelID = channel.ID
+			return
+		}
+		zap.S().Errorf("Failed to join bot channel %q: %v", config.BotChannel, err)
+		return
+	}
+	botChannelID = channel.ID
+	zap.S().Infof("Joined bot channel %q", config.BotChannel)
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRecurrenceSteps bounds the expansion of a recurring event, so a malformed
+// or very old daily rule cannot stall a sweep.
+const maxRecurrenceSteps = 100000
+
+// calendarEvent is a VEVENT of an ICS calendar.
+type calendarEvent struct {
+	Summary  string
+	Start    time.Time
+	Duration time.Duration
+	Rule     *recurrenceRule
+}
+
+// recurrenceRule is the subset of RFC 5545 RRULE the bot understands.
+type recurrenceRule struct {
+	Freq     string
+	Interval int
+	Count    int
+	Until    time.Time
+	ByDay    []time.Weekday
+}
+
+// calendarCache polls an ICS calendar and answers whether an event is currently running.
+type calendarCache struct {
+	url      string
+	location *time.Location
+	client   *http.Client
+
+	mu     sync.RWMutex
+	events []calendarEvent
+}
+
+var calendar *calendarCache
+
+func newCalendarCache(url string, location *time.Location) *calendarCache {
+	return &calendarCache{url: url, location: location, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// run refreshes the calendar every interval until the process exits.
+func (c *calendarCache) run(interval time.Duration) {
+	for {
+		if err := c.refresh(); err != nil {
+			zap.S().Errorf("Failed to refresh calendar, keeping %d cached events: %v", c.size(), redactURLError(err))
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (c *calendarCache) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	events, err := parseICS(res.Body, c.location)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.events = events
+	c.mu.Unlock()
+	zap.S().Debugf("Calendar refreshed, %d events", len(events))
+	return nil
+}
+
+func (c *calendarCache) size() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.events)
+}
+
+// activeEvent returns the summary of an event running at t, or false if there is none.
+func (c *calendarCache) activeEvent(t time.Time) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, event := range c.events {
+		if event.activeAt(t) {
+			return event.Summary, true
+		}
+	}
+	return "", false
+}
+
+func (e calendarEvent) activeAt(t time.Time) bool {
+	if e.Rule == nil {
+		return !t.Before(e.Start) && t.Before(e.Start.Add(e.Duration))
+	}
+	active := false
+	e.Rule.occurrences(e.Start, func(start time.Time) bool {
+		if start.After(t) {
+			return false
+		}
+		if t.Before(start.Add(e.Duration)) {
+			active = true
+			return false
+		}
+		return true
+	})
+	return active
+}
+
+// occurrences calls yield with the start of every occurrence in chronological order until yield returns false.
+func (r *recurrenceRule) occurrences(start time.Time, yield func(time.Time) bool) {
+	emitted := 0
+	emit := func(t time.Time) bool {
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		if r.Count > 0 && emitted >= r.Count {
+			return false
+		}
+		emitted++
+		return yield(t)
+	}
+
+	if r.Freq == "WEEKLY" && len(r.ByDay) > 0 {
+		// Walk day by day and pick the listed weekdays of every interval-th week.
+		weekStart := start.AddDate(0, 0, -int(start.Weekday()))
+		for day := 0; day < maxRecurrenceSteps; day++ {
+			t := start.AddDate(0, 0, day)
+			week := int(t.AddDate(0, 0, -int(t.Weekday())).Sub(weekStart).Hours()+12) / (24 * 7)
+			if week%r.Interval != 0 || !containsWeekday(r.ByDay, t.Weekday()) {
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+		return
+	}
+
+	for step := 0; step < maxRecurrenceSteps; step++ {
+		n := step * r.Interval
+		var t time.Time
+		switch r.Freq {
+		case "DAILY":
+			t = start.AddDate(0, 0, n)
+		case "WEEKLY":
+			t = start.AddDate(0, 0, 7*n)
+		case "MONTHLY":
+			t = start.AddDate(0, n, 0)
+		case "YEARLY":
+			t = start.AddDate(n, 0, 0)
+		default:
+			return
+		}
+		if !emit(t) {
+			return
+		}
+	}
+}
+
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseICS reads the VEVENTs of an ICS calendar.
+// Floating times without timezone are interpreted in location.
+func parseICS(r io.Reader, location *time.Location) ([]calendarEvent, error) {
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []calendarEvent
+	var current map[string]icsProperty
+	for _, line := range lines {
+		prop, ok := parseICSLine(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case prop.name == "BEGIN" && prop.value == "VEVENT":
+			current = make(map[string]icsProperty)
+		case prop.name == "END" && prop.value == "VEVENT":
+			if current != nil {
+				event, err := buildCalendarEvent(current, location)
+				if err != nil {
+					zap.S().Warnf("Skipping calendar event %q: %v", current["SUMMARY"].value, err)
+				} else if event != nil {
+					events = append(events, *event)
+				}
+			}
+			current = nil
+		case current != nil:
+			if _, exists := current[prop.name]; !exists {
+				current[prop.name] = prop
+			}
+		}
+	}
+	return events, nil
+}
+
+// unfoldICS joins continuation lines, which start with a space or tab, to their predecessor.
+func unfoldICS(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func parseICSLine(line string) (icsProperty, bool) {
+	head, value, found := strings.Cut(line, ":")
+	if !found {
+		return icsProperty{}, false
+	}
+	parts := strings.Split(head, ";")
+	prop := icsProperty{name: strings.ToUpper(parts[0]), params: make(map[string]string), value: value}
+	for _, param := range parts[1:] {
+		if k, v, ok := strings.Cut(param, "="); ok {
+			prop.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return prop, true
+}
+
+func buildCalendarEvent(props map[string]icsProperty, location *time.Location) (*calendarEvent, error) {
+	if props["STATUS"].value == "CANCELLED" {
+		return nil, nil
+	}
+	startProp, ok := props["DTSTART"]
+	if !ok {
+		return nil, errors.New("missing DTSTART")
+	}
+	start, allDay, err := parseICSTime(startProp, location)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &calendarEvent{Summary: unescapeICSText(props["SUMMARY"].value), Start: start}
+	if endProp, ok := props["DTEND"]; ok {
+		end, _, err := parseICSTime(endProp, location)
+		if err != nil {
+			return nil, err
+		}
+		event.Duration = end.Sub(start)
+	} else if durationProp, ok := props["DURATION"]; ok {
+		if event.Duration, err = parseICSDuration(durationProp.value); err != nil {
+			return nil, err
+		}
+	} else if allDay {
+		event.Duration = 24 * time.Hour
+	}
+
+	if ruleProp, ok := props["RRULE"]; ok {
+		if event.Rule, err = parseRecurrenceRule(ruleProp.value, location); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
+}
+
+// parseICSTime parses a DATE or DATE-TIME value, in UTC, with TZID or floating.
+func parseICSTime(prop icsProperty, location *time.Location) (time.Time, bool, error) {
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if tz, err := time.LoadLocation(tzid); err == nil {
+			location = tz
+		}
+	}
+	value := prop.value
+	switch {
+	case prop.params["VALUE"] == "DATE" || len(value) == 8:
+		t, err := time.ParseInLocation("20060102", value, location)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	default:
+		t, err := time.ParseInLocation("20060102T150405", value, location)
+		return t, false, err
+	}
+}
+
+// parseICSDuration parses durations like PT2H30M or P1D.
+func parseICSDuration(value string) (time.Duration, error) {
+	if !strings.HasPrefix(value, "P") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var d time.Duration
+	number := ""
+	inTime := false
+	for _, r := range value[1:] {
+		if r >= '0' && r <= '9' {
+			number += string(r)
+			continue
+		}
+		if r == 'T' {
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		number = ""
+		switch {
+		case r == 'W':
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case r == 'D':
+			d += time.Duration(n) * 24 * time.Hour
+		case r == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case r == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case r == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+	return d, nil
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRecurrenceRule(value string, location *time.Location) (*recurrenceRule, error) {
+	rule := &recurrenceRule{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		var err error
+		switch key {
+		case "FREQ":
+			rule.Freq = val
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(val)
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(val)
+		case "UNTIL":
+			rule.Until, _, err = parseICSTime(icsProperty{value: val, params: map[string]string{}}, location)
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, ok := icsWeekdays[day]
+				if !ok {
+					return nil, fmt.Errorf("unsupported BYDAY %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %s: %v", key, err)
+		}
+	}
+	switch rule.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("unsupported RRULE frequency %q", rule.Freq)
+	}
+	if rule.Interval < 1 {
+		return nil, fmt.Errorf("invalid RRULE interval %d", rule.Interval)
+	}
+	return rule, nil
+}
+
+func unescapeICSText(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
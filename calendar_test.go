@@ -0,0 +1,205 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseTestICS parses the VEVENTs in body, given with \n line endings, as a calendar with CRLF line endings.
+func parseTestICS(t *testing.T, body string) []calendarEvent {
+	t.Helper()
+	ics := "BEGIN:VCALENDAR\nVERSION:2.0\n" + body + "END:VCALENDAR\n"
+	events, err := parseICS(strings.NewReader(strings.ReplaceAll(ics, "\n", "\r\n")), time.UTC)
+	if err != nil {
+		t.Fatalf("parseICS: %v", err)
+	}
+	return events
+}
+
+func expectActive(t *testing.T, event calendarEvent, at time.Time, want bool) {
+	t.Helper()
+	if got := event.activeAt(at); got != want {
+		t.Errorf("%q active at %s = %v, want %v", event.Summary, at.Format(time.RFC3339), got, want)
+	}
+}
+
+func TestParseICSFoldedLines(t *testing.T) {
+	events := parseTestICS(t, `BEGIN:VEVENT
+SUMMARY:Clan war\, finals
+  against the
+	 old guard
+DTSTART:20261017T180000Z
+DTEND:20261017T210000Z
+END:VEVENT
+`)
+	if len(events) != 1 {
+		t.Fatalf("parsed %d events, want 1", len(events))
+	}
+	if want := "Clan war, finals against the old guard"; events[0].Summary != want {
+		t.Errorf("summary = %q, want %q", events[0].Summary, want)
+	}
+	if events[0].Duration != 3*time.Hour {
+		t.Errorf("duration = %v, want 3h", events[0].Duration)
+	}
+}
+
+func TestParseICSTimezones(t *testing.T) {
+	events := parseTestICS(t, `BEGIN:VEVENT
+SUMMARY:Berlin
+DTSTART;TZID=Europe/Berlin:20261017T200000
+DTEND;TZID=Europe/Berlin:20261017T220000
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Quoted
+DTSTART;TZID="America/New_York":20261017T200000
+DURATION:PT1H30M
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Floating
+DTSTART:20261017T200000
+DURATION:PT1H
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Unknown zone
+DTSTART;TZID=Mars/Olympus:20261017T200000
+DURATION:PT1H
+END:VEVENT
+`)
+	want := map[string]time.Time{
+		"Berlin":   time.Date(2026, 10, 17, 18, 0, 0, 0, time.UTC),
+		"Quoted":   time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+		"Floating": time.Date(2026, 10, 17, 20, 0, 0, 0, time.UTC),
+		// Unknown zones are read like floating times.
+		"Unknown zone": time.Date(2026, 10, 17, 20, 0, 0, 0, time.UTC),
+	}
+	if len(events) != len(want) {
+		t.Fatalf("parsed %d events, want %d", len(events), len(want))
+	}
+	for _, event := range events {
+		if !event.Start.Equal(want[event.Summary]) {
+			t.Errorf("%q starts at %s, want %s", event.Summary, event.Start.UTC().Format(time.RFC3339), want[event.Summary].Format(time.RFC3339))
+		}
+	}
+	if events[0].Duration != 2*time.Hour || events[1].Duration != 90*time.Minute {
+		t.Errorf("durations = %v and %v, want 2h and 1h30m", events[0].Duration, events[1].Duration)
+	}
+}
+
+func TestParseICSAllDayEvents(t *testing.T) {
+	events := parseTestICS(t, `BEGIN:VEVENT
+SUMMARY:LAN party
+DTSTART;VALUE=DATE:20261031
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Tournament weekend
+DTSTART;VALUE=DATE:20261107
+DTEND;VALUE=DATE:20261109
+END:VEVENT
+`)
+	if len(events) != 2 {
+		t.Fatalf("parsed %d events, want 2", len(events))
+	}
+	lan, tournament := events[0], events[1]
+	expectActive(t, lan, time.Date(2026, 10, 30, 23, 59, 0, 0, time.UTC), false)
+	expectActive(t, lan, time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC), true)
+	expectActive(t, lan, time.Date(2026, 10, 31, 23, 59, 0, 0, time.UTC), true)
+	expectActive(t, lan, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), false)
+	expectActive(t, tournament, time.Date(2026, 11, 8, 12, 0, 0, 0, time.UTC), true)
+	expectActive(t, tournament, time.Date(2026, 11, 9, 0, 0, 0, 0, time.UTC), false)
+}
+
+func TestParseICSSkipsCancelledAndBrokenEvents(t *testing.T) {
+	events := parseTestICS(t, `BEGIN:VEVENT
+SUMMARY:Cancelled
+STATUS:CANCELLED
+DTSTART:20261017T180000Z
+DURATION:PT1H
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:No start
+DURATION:PT1H
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Bad rule
+DTSTART:20261017T180000Z
+DURATION:PT1H
+RRULE:FREQ=HOURLY
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Kept
+DTSTART:20261017T180000Z
+DURATION:PT1H
+END:VEVENT
+`)
+	if len(events) != 1 || events[0].Summary != "Kept" {
+		t.Fatalf("parsed %v, want only Kept", events)
+	}
+}
+
+func TestParseICSRecurrenceRules(t *testing.T) {
+	events := parseTestICS(t, `BEGIN:VEVENT
+SUMMARY:Raid night
+DTSTART;TZID=Europe/Berlin:20261016T200000
+DURATION:PT3H
+RRULE:FREQ=WEEKLY;BYDAY=FR,SA;COUNT=4
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Every other day
+DTSTART:20261017T120000Z
+DURATION:PT1H
+RRULE:FREQ=DAILY;INTERVAL=2;UNTIL=20261021T120000Z
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Monthly meeting
+DTSTART:20261017T190000Z
+DURATION:PT1H
+RRULE:FREQ=MONTHLY
+END:VEVENT
+`)
+	if len(events) != 3 {
+		t.Fatalf("parsed %d events, want 3", len(events))
+	}
+	raid, everyOther, monthly := events[0], events[1], events[2]
+
+	// Fri 16th, Sat 17th, Fri 23rd and Sat 24th, at 20:00 Berlin time. Clocks go back on the 25th.
+	expectActive(t, raid, time.Date(2026, 10, 16, 18, 30, 0, 0, time.UTC), true)
+	expectActive(t, raid, time.Date(2026, 10, 17, 18, 30, 0, 0, time.UTC), true)
+	expectActive(t, raid, time.Date(2026, 10, 18, 18, 30, 0, 0, time.UTC), false)
+	expectActive(t, raid, time.Date(2026, 10, 24, 18, 30, 0, 0, time.UTC), true)
+	expectActive(t, raid, time.Date(2026, 10, 30, 19, 30, 0, 0, time.UTC), false)
+	// Weekly rules keep the wall-clock time after the DST change, so the fifth one would start at 19:00 UTC.
+	rule := *raid.Rule
+	rule.Count = 5
+	raid.Rule = &rule
+	expectActive(t, raid, time.Date(2026, 10, 30, 18, 30, 0, 0, time.UTC), false)
+	expectActive(t, raid, time.Date(2026, 10, 30, 19, 30, 0, 0, time.UTC), true)
+
+	// The 17th, 19th and 21st, and no later than UNTIL.
+	expectActive(t, everyOther, time.Date(2026, 10, 17, 12, 30, 0, 0, time.UTC), true)
+	expectActive(t, everyOther, time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC), false)
+	expectActive(t, everyOther, time.Date(2026, 10, 21, 12, 30, 0, 0, time.UTC), true)
+	expectActive(t, everyOther, time.Date(2026, 10, 23, 12, 30, 0, 0, time.UTC), false)
+
+	expectActive(t, monthly, time.Date(2027, 3, 17, 19, 30, 0, 0, time.UTC), true)
+	expectActive(t, monthly, time.Date(2027, 3, 18, 19, 30, 0, 0, time.UTC), false)
+}
+
+func TestParseICSDuration(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"PT2H30M": 2*time.Hour + 30*time.Minute,
+		"P1D":     24 * time.Hour,
+		"P1W":     7 * 24 * time.Hour,
+		"P1DT12H": 36 * time.Hour,
+		"PT45S":   45 * time.Second,
+	} {
+		if got, err := parseICSDuration(value); err != nil || got != want {
+			t.Errorf("parseICSDuration(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"2H", "P1H", "PTH", "PT1X"} {
+		if _, err := parseICSDuration(value); err == nil {
+			t.Errorf("parseICSDuration(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// fixtureVersion is the version of the capture format, raised when it changes incompatibly.
+const fixtureVersion = 1
+
+// serverFixture is an anonymized snapshot of a virtual server, written by the capture command
+// so that users can attach the situation a bug happened in to its report.
+type serverFixture struct {
+	Version    int            `json:"version"`
+	CapturedAt time.Time      `json:"captured_at"`
+	Build      buildInfo      `json:"build"`
+	Channels   []*channelInfo `json:"channels"`
+	Clients    []*clientInfo  `json:"clients"`
+	// Details holds the clientinfo of each client by client ID, it is missing for clients that left during the capture.
+	Details map[int]*clientDetails `json:"details"`
+}
+
+// captureServer connects to the configured server without changing anything on it and writes an anonymized snapshot of its channels and clients to path, or to stdout if path is empty.
+func captureServer(path string) error {
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	client, err := connectReadOnly(config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	fixture := serverFixture{Version: fixtureVersion, CapturedAt: time.Now(), Build: currentBuild(), Details: make(map[int]*clientDetails)}
+	if fixture.Channels, err = listChannels(client); err != nil {
+		return fmt.Errorf("failed to list channels: %w", err)
+	}
+	if fixture.Clients, err = listClients(client); err != nil {
+		return fmt.Errorf("failed to list clients: %w", err)
+	}
+	for _, c := range fixture.Clients {
+		if details, err := getClientDetails(client, c.ID); err == nil {
+			fixture.Details[c.ID] = details
+		}
+	}
+	if err = anonymizeFixture(&fixture, config); err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if path != "" {
+		if out, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(fixture); err != nil {
+		return err
+	}
+	if path != "" {
+		fmt.Fprintf(os.Stderr, "Captured %d channels and %d clients to %s\n", len(fixture.Channels), len(fixture.Clients), path)
+	}
+	return nil
+}
+
+// anonymizeFixture replaces what identifies people in a capture. Nicknames become user-1, user-2 and so on,
+// except those matching TS3_EXEMPT_NICKNAMES, which name bots. Unique identifiers are hashed with a salt
+// that is thrown away, so they still tell clients apart but cannot be traced back, and so are myTeamSpeak IDs. Away messages are dropped
+// and channel topics reduced to the opt-out tag, channel names are kept since the configuration refers to them.
+func anonymizeFixture(fixture *serverFixture, config Config) error {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	hasher := privacyFilter{mode: privacyHash, salt: salt}
+	users, queries := 0, 0
+	for _, c := range fixture.Clients {
+		c.UniqueIdentifier = hasher.uid(c.UniqueIdentifier)
+		c.AwayMessage = ""
+		if c.Type == 1 {
+			queries++
+			c.Nickname = fmt.Sprintf("query-%d", queries)
+			continue
+		}
+		if exemptNickname(config, c.Nickname) {
+			continue
+		}
+		users++
+		c.Nickname = fmt.Sprintf("user-%d", users)
+	}
+	for _, details := range fixture.Details {
+		if details.MyTeamSpeakID != "" {
+			details.MyTeamSpeakID = hasher.uid(details.MyTeamSpeakID)
+		}
+	}
+	for _, channel := range fixture.Channels {
+		topic := ""
+		if config.OptOutTag != "" && channel.hasTag(config.OptOutTag) {
+			topic = config.OptOutTag
+		}
+		channel.Topic = topic
+	}
+	return nil
+}
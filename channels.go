@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// spacerRegex matches the names of spacer channels, e.g. "[spacer0]", "[cspacer]Gaming" or "[*spacer1]-".
+// The server renders them as decoration, they are never AFK, ignored or section channels.
+var spacerRegex = regexp.MustCompile(`(?i)^\[[lrc*]?spacer[^\]]*\]`)
+
+// channelRefreshInterval is how long a cached channel list is used if no channel notification invalidated it,
+// in case a notification was missed.
+const channelRefreshInterval = 5 * time.Minute
+
+// channelInfo is a ts3.Channel extended with the fields the bot requests on top of the defaults.
+type channelInfo struct {
+	ts3.Channel `ms:",squash"`
+	Topic       string `ms:"channel_topic"`
+	// MaxClients is -1 if the channel is not limited.
+	MaxClients      int `ms:"channel_maxclients"`
+	NeededTalkPower int `ms:"channel_needed_talk_power"`
+}
+
+// listChannels returns the channel list of the selected virtual server including channel topics, limits and talk power.
+func listChannels(client *ts3.Client) ([]*channelInfo, error) {
+	var channels []*channelInfo
+	if err := execQuery(client, ts3.NewCmd("channellist").WithOptions("-topic", "-limits", "-voice"), &channels); err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// channelCache holds the channel list between sweeps. Channel notifications mark it stale.
+// Client counts in the cached list are outdated, only the structure, names and topics are used.
+type channelCache struct {
+	channels  []*channelInfo
+	fetchedAt time.Time
+	stale     bool
+}
+
+var channelList = &channelCache{stale: true}
+
+// get returns the cached channel list, fetching it if it is stale or too old.
+func (c *channelCache) get(client *ts3.Client) ([]*channelInfo, error) {
+	if !c.stale && clock.Now().Sub(c.fetchedAt) < channelRefreshInterval {
+		return c.channels, nil
+	}
+	channels, err := listChannels(client)
+	if err != nil {
+		return nil, err
+	}
+	zap.S().Debugf("Fetched channel list, %d channels", len(channels))
+	c.channels, c.fetchedAt, c.stale = channels, clock.Now(), false
+	return channels, nil
+}
+
+// invalidate makes the next get fetch the channel list.
+func (c *channelCache) invalidate() {
+	c.stale = true
+}
+
+// isSpacer reports whether the channel is a decorative spacer.
+func (c *channelInfo) isSpacer() bool {
+	return spacerRegex.MatchString(c.ChannelName)
+}
+
+// hasTag reports whether the channel's name or topic contains tag, ignoring case.
+func (c *channelInfo) hasTag(tag string) bool {
+	tag = strings.ToLower(tag)
+	return strings.Contains(strings.ToLower(c.ChannelName), tag) || strings.Contains(strings.ToLower(c.Topic), tag)
+}
+
+// channelTree indexes the channel list of a virtual server by ID so that
+// the parents of a channel can be looked up cheaply.
+type channelTree struct {
+	channels []*channelInfo
+	byID     map[int]*channelInfo
+	children map[int][]*channelInfo
+}
+
+func newChannelTree(channels []*channelInfo) *channelTree {
+	tree := &channelTree{
+		channels: channels,
+		byID:     make(map[int]*channelInfo, len(channels)),
+		children: make(map[int][]*channelInfo),
+	}
+	for _, channel := range channels {
+		tree.byID[channel.ID] = channel
+		tree.children[channel.ParentID] = append(tree.children[channel.ParentID], channel)
+	}
+	return tree
+}
+
+// byName returns the first channel with the given name that is not a spacer, or nil if there is none.
+func (t *channelTree) byName(name string) *channelInfo {
+	for _, channel := range t.channels {
+		if channel.ChannelName == name && !channel.isSpacer() {
+			return channel
+		}
+	}
+	return nil
+}
+
+// path returns the channel with the given ID followed by all of its parents up to the root.
+func (t *channelTree) path(id int) []*channelInfo {
+	var path []*channelInfo
+	for id != 0 {
+		channel, ok := t.byID[id]
+		if !ok {
+			break
+		}
+		path = append(path, channel)
+		id = channel.ParentID
+		// Guard against malformed responses that would otherwise loop forever.
+		if len(path) > len(t.byID) {
+			break
+		}
+	}
+	return path
+}
+
+// inSubtree reports whether the channel with the given ID is one of the named
+// channels or lies below one of them. Spacers never match.
+func (t *channelTree) inSubtree(id int, names []string) bool {
+	for _, channel := range t.path(id) {
+		if channel.isSpacer() {
+			continue
+		}
+		for _, name := range names {
+			if channel.ChannelName == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nearestMatchingChild walks up from the channel with the given ID and returns the first
+// channel on that path, or subchannel of a channel on that path, whose name matches pattern.
+// Channels at the root of the tree are not considered, that is the global AFK channel's job. Spacers are skipped.
+func (t *channelTree) nearestMatchingChild(id int, pattern *regexp.Regexp) *channelInfo {
+	for _, channel := range t.path(id) {
+		if pattern.MatchString(channel.ChannelName) && !channel.isSpacer() {
+			return channel
+		}
+		for _, child := range t.children[channel.ID] {
+			if pattern.MatchString(child.ChannelName) && !child.isSpacer() {
+				return child
+			}
+		}
+	}
+	return nil
+}
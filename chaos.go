@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"math/rand"
+	"time"
+)
+
+// chaosMonkey injects random failures into ServerQuery commands to exercise the error handling,
+// backoff and reconnect paths without a misbehaving server. It is meant for local testing only.
+type chaosMonkey struct {
+	percent  int
+	maxDelay time.Duration
+	rand     *rand.Rand
+}
+
+// chaos is disabled unless TS3_CHAOS_PERCENT is set.
+var chaos = &chaosMonkey{}
+
+func newChaosMonkey(percent int, maxDelay time.Duration) *chaosMonkey {
+	return &chaosMonkey{percent: percent, maxDelay: maxDelay, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// chaosErrors are the server errors the bot handles specially, see queryerrors.go.
+var chaosErrors = []*ts3.Error{
+	{ID: queryErrClientFlooding, Msg: "client is flooding", Details: map[string]interface{}{"extra_msg": "please wait 10 seconds"}},
+	{ID: queryErrServerNotRunning, Msg: "server is not running"},
+	{ID: queryErrInvalidClientID, Msg: "invalid clientID"},
+	{ID: queryErrInvalidChannelID, Msg: "invalid channelID"},
+	{ID: queryErrInsufficientPermission, Msg: "insufficient client permissions", Details: map[string]interface{}{"failed_permid": 0}},
+}
+
+// inject decides whether cmd fails. It either returns the error cmd fails with, sleeps before cmd
+// is sent, or does nothing. Losing the connection closes client, so the bot has to reconnect.
+func (c *chaosMonkey) inject(client *ts3.Client, cmd string) error {
+	if c.percent == 0 || c.rand.Intn(100) >= c.percent {
+		return nil
+	}
+	switch choice := c.rand.Intn(len(chaosErrors) + 3); {
+	case choice < len(chaosErrors):
+		err := chaosErrors[choice]
+		zap.S().Warnf("Chaos: failing %q with %v", cmd, err)
+		return err
+	case choice == len(chaosErrors):
+		zap.S().Warnf("Chaos: %q timed out", cmd)
+		return ts3.ErrTimeout
+	case choice == len(chaosErrors)+1:
+		zap.S().Warnf("Chaos: dropping the connection before %q", cmd)
+		client.Close()
+		return ts3.ErrNotConnected
+	default:
+		if c.maxDelay > 0 {
+			delay := time.Duration(c.rand.Int63n(int64(c.maxDelay)))
+			zap.S().Warnf("Chaos: delaying %q by %v", cmd, delay.Truncate(time.Millisecond))
+			time.Sleep(delay)
+		}
+		return nil
+	}
+}
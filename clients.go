@@ -0,0 +1,67 @@
+package main
+
+import "github.com/multiplay/go-ts3"
+
+// clientInfo is a ts3.OnlineClient extended with the fields the bot requests on top of the defaults.
+type clientInfo struct {
+	ts3.OnlineClient `ms:",squash"`
+	UniqueIdentifier string `ms:"client_unique_identifier"`
+	Talking          bool   `ms:"client_flag_talking"`
+	Country          string `ms:"client_country"`
+}
+
+// listClients returns the online clients of the selected virtual server including their unique identifiers, away status, whether they are talking and their country.
+func listClients(client *ts3.Client) ([]*clientInfo, error) {
+	var clients []*clientInfo
+	if err := execQuery(client, ts3.NewCmd("clientlist").WithOptions("-uid", "-away", "-voice", "-country"), &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// clientDetails is the part of the clientinfo response the bot uses.
+type clientDetails struct {
+	IdleTimeMs int `ms:"client_idle_time"`
+	// ConnectedTimeMs is how long the client has been connected.
+	ConnectedTimeMs int    `ms:"connection_connected_time"`
+	InputMuted      bool   `ms:"client_input_muted"`
+	OutputMuted     bool   `ms:"client_output_muted"`
+	Platform        string `ms:"client_platform"`
+	Version         string `ms:"client_version"`
+	TalkPower       int    `ms:"client_talk_power"`
+	// MyTeamSpeakID is the myTeamSpeak account the client is logged in with, empty if none.
+	MyTeamSpeakID string `ms:"client_myteamspeak_id"`
+	// Badges lists the badges the client shows, see clientBadges.
+	Badges string `ms:"client_badges"`
+}
+
+// getClientDetails runs clientinfo for the client with ID clid.
+func getClientDetails(client *ts3.Client, clid int) (*clientDetails, error) {
+	details := &clientDetails{IdleTimeMs: -1}
+	if err := execQuery(client, ts3.NewCmd("clientinfo").WithArgs(ts3.NewArg("clid", clid)), details); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// moveClient moves the client with ID clid into the channel cid.
+func moveClient(client *ts3.Client, clid int, cid int) error {
+	_, err := execCmd(client, ts3.NewCmd("clientmove").WithArgs(ts3.NewArg("clid", clid), ts3.NewArg("cid", cid)))
+	return err
+}
+
+// moveClients moves the clients with the given IDs into the channel cid with a single command.
+func moveClients(client *ts3.Client, clids []int, cid int) error {
+	ids := make([]ts3.CmdArg, 0, len(clids))
+	for _, clid := range clids {
+		ids = append(ids, ts3.NewArg("clid", clid))
+	}
+	_, err := execCmd(client, ts3.NewCmd("clientmove").WithArgs(ts3.NewArgGroup(ids...), ts3.NewArg("cid", cid)))
+	return err
+}
+
+// pokeClient shows msg to the client with ID clid in a pop-up.
+func pokeClient(client *ts3.Client, clid int, msg string) error {
+	_, err := execCmd(client, ts3.NewCmd("clientpoke").WithArgs(ts3.NewArg("clid", clid), ts3.NewArg("msg", msg)))
+	return err
+}
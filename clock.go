@@ -0,0 +1,18 @@
+package main
+
+import "time"
+
+// Clock tells the time to everything that makes decisions based on it, such as
+// grace periods, pauses and schedules, so they can be driven by a fake clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clock is the time source of the bot.
+var clock Clock = systemClock{}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleTextMessage runs chat commands sent to the bot in a private message. country is the one
+// the sender's client reports, if known. Only clients listed in TS3_ADMIN_UIDS may use the admin commands.
+func handleTextMessage(client *ts3.Client, config Config, n ts3.Notification, country string) {
+	msg := strings.TrimSpace(n.Data["msg"])
+	if !strings.HasPrefix(msg, "!") {
+		return
+	}
+	invokerId, err := strconv.Atoi(n.Data["invokerid"])
+	if err != nil {
+		return
+	}
+	// The name only ends up in logs and events.
+	name, uid := privacy.nickname(n.Data["invokername"]), n.Data["invokeruid"]
+
+	fields := strings.Fields(msg)
+	command, args := strings.ToLower(fields[0]), fields[1:]
+	reply := func(format string, a ...interface{}) {
+		if err := sendPrivateMessage(client, invokerId, fmt.Sprintf(format, a...)); err != nil {
+			zap.S().Errorf("Failed to reply to %s: %v", name, err)
+		}
+	}
+	// The commands all clients may use reply in the client's language, the admin commands in English.
+	override, _ := clientOverrides.get(uid)
+	language := clientLanguage(config, override, country)
+	say := func(key string, a ...interface{}) {
+		reply("%s", translate(language, key, a...))
+	}
+
+	known := findChatCommand(command)
+	if known == nil {
+		return
+	}
+	admin := containsString(config.AdminUIDs, uid)
+	if known.admin && !admin {
+		zap.S().Warnf("User %s (%s) is not allowed to run %s", name, privacy.uid(uid), command)
+		say("not_allowed")
+		return
+	}
+
+	switch command {
+	case "!help":
+		reply("%s", helpText(config, admin, language))
+	case "!notify":
+		if len(args) == 0 {
+			say("notify.current", notifyMethod(config, override))
+			return
+		}
+		method := strings.ToLower(args[0])
+		if len(args) != 1 || !isNotifyMethod(method) {
+			say("notify.usage")
+			return
+		}
+		if err := setNotifyMethod(uid, method); err != nil {
+			zap.S().Errorf("Failed to save the notify preference of %s: %v", name, err)
+			say("notify.failed")
+			return
+		}
+		if config.WarnBefore == 0 {
+			say("notify.saved_disabled")
+			return
+		}
+		say("notify.saved", method)
+	case "!language":
+		available := strings.Join(languages(), ", ")
+		if len(args) == 0 {
+			say("language.current", translate(language, "language.name"), available)
+			return
+		}
+		code := strings.ToLower(args[0])
+		if len(args) != 1 || (code != languageAuto && !isLanguage(code)) {
+			say("language.usage", available)
+			return
+		}
+		if err := setLanguage(uid, code); err != nil {
+			zap.S().Errorf("Failed to save the language of %s: %v", name, err)
+			say("notify.failed")
+			return
+		}
+		if code == languageAuto {
+			say("language.auto")
+			return
+		}
+		reply("%s", translate(code, "language.saved"))
+	case "!whymoved":
+		limit := whyMovedDefault
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				say("whymoved.usage")
+				return
+			}
+			limit = n
+		}
+		if limit > whyMovedMax {
+			limit = whyMovedMax
+		}
+		history, err := storage.MoveHistory(privacy.uid(uid), limit)
+		if err != nil {
+			zap.S().Errorf("Failed to read the move history of %s: %v", name, err)
+			say("whymoved.failed")
+			return
+		}
+		reply("%s", whyMovedText(config, history, language))
+	case "!pause":
+		// !pause [minutes] [reason...]
+		duration := pause.defaultDuration
+		if len(args) > 0 {
+			if minutes, err := strconv.Atoi(args[0]); err == nil && minutes >= 0 {
+				duration = time.Duration(minutes) * time.Minute
+				args = args[1:]
+			}
+		}
+		status := pauseBot(duration, strings.Join(args, " "), name)
+		if status.Until != nil {
+			reply("Moves paused until %s.", status.Until.In(config.Location).Format("15:04"))
+		} else {
+			reply("Moves paused until !resume.")
+		}
+	case "!resume":
+		if resumeBot(name) {
+			reply("Moves resumed.")
+		} else {
+			reply("Moves are not paused.")
+		}
+	case "!sweep":
+		handleSweepCommand(client, config, uid, name, args, reply)
+	case "!confirm":
+		if config.LargeSweepAction != "confirm" || !largeSweepPending.Load() {
+			reply("There is no sweep waiting for confirmation.")
+			return
+		}
+		// Already in the main loop, so run the sweep right away.
+		handleLargeSweepApproval(client, config, name)
+		reply("Sweep confirmed.")
+	case "!undo":
+		// !undo [minutes]
+		if config.UndoWindow == 0 {
+			reply("!undo is switched off, see TS3_UNDO_WINDOW_SEC.")
+			return
+		}
+		var since time.Time
+		if len(args) > 0 {
+			minutes, err := strconv.Atoi(args[0])
+			if err != nil || minutes < 1 {
+				reply("Usage: !undo [minutes]")
+				return
+			}
+			since = clock.Now().Add(-time.Duration(minutes) * time.Minute)
+		}
+		undone, skipped, err := undoMoves(client, config, since, name)
+		switch {
+		case err != nil:
+			zap.S().Errorf("Failed to undo moves: %v", err)
+			reply("Undo failed: %v", err)
+		case undone == 0 && skipped == 0:
+			reply("There are no moves from the last %s to undo.", shortDuration(config.UndoWindow))
+		case skipped > 0:
+			reply("Moved %d users back, %d had left or were no longer in the AFK channel.", undone, skipped)
+		default:
+			reply("Moved %d users back.", undone)
+		}
+	case "!trace":
+		switch {
+		case len(args) == 1 && args[0] == "on":
+			setQueryTrace(true, name)
+		case len(args) == 1 && args[0] == "off":
+			setQueryTrace(false, name)
+		default:
+			reply("Usage: !trace on|off")
+			return
+		}
+		reply("Query trace is %s.", args[0])
+	case "!profile":
+		if len(args) == 0 {
+			status := profiles.status(config, clock.Now())
+			if len(status.Profiles) == 0 {
+				reply("No policy profiles are defined, see TS3_PROFILES.")
+				return
+			}
+			active := status.Active
+			if active == "" {
+				active = "none"
+			}
+			how := "by schedule"
+			if status.Manual {
+				how = "selected by an admin"
+			}
+			reply("Active profile: %s (%s). Profiles: %s. Usage: !profile <name>|auto", active, how, strings.Join(status.Profiles, ", "))
+			return
+		}
+		if err := profiles.selectProfile(config, args[0], name); err != nil {
+			reply("%v", err)
+			return
+		}
+		if args[0] == profileAuto {
+			reply("The profile follows the schedule again.")
+			return
+		}
+		reply("Profile %s is active until another one is selected, !profile auto returns to the schedule.", args[0])
+	case "!settings":
+		values := runtimeSettings.all()
+		for _, setting := range settingNames() {
+			value, changed := values[setting]
+			if !changed {
+				value = "(not changed)"
+			}
+			reply("%s = %s: %s", setting, value, runtimeSettingDefinitions[setting].description)
+		}
+	case "!set":
+		// !set <name> <value...>, the value may contain spaces.
+		if len(args) < 2 {
+			reply("Usage: !set <name> <value>, see !settings")
+			return
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(msg, fields[0])), args[0]))
+		canonical, err := runtimeSettings.set(args[0], value)
+		if canonical == "" && err != nil {
+			reply("%v", err)
+			return
+		}
+		if err != nil {
+			zap.S().Errorf("Failed to save setting %s: %v", args[0], err)
+			reply("%s set to %s, but it could not be saved and will be lost on restart.", args[0], canonical)
+			return
+		}
+		reply("%s set to %s.", args[0], canonical)
+	case "!reset":
+		if len(args) != 1 {
+			reply("Usage: !reset <name>")
+			return
+		}
+		if err := runtimeSettings.reset(args[0]); err != nil {
+			reply("%v", err)
+			return
+		}
+		reply("%s reset to the configured value.", args[0])
+	}
+}
+
+// invokerCountry returns the country of the client that sent the text message n, if it was online in the last sweep.
+func invokerCountry(n ts3.Notification) string {
+	invokerId, err := strconv.Atoi(n.Data["invokerid"])
+	if err != nil {
+		return ""
+	}
+	if c, ok := previousClients[invokerId]; ok && c.UniqueIdentifier == n.Data["invokeruid"] {
+		return c.Country
+	}
+	return ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold is containsString ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
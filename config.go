@@ -0,0 +1,736 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultMaxIdleTimeSec = 15 * 60
+
+// defaultGracePeriodSec is how long idle times are ignored in a channel after somebody joined it.
+const defaultGracePeriodSec = 10
+
+type Config struct {
+	UserName string
+	Password secret
+	// FallbackAccounts are the user:password pairs the bot switches to if the current account stops working.
+	FallbackAccounts       secret
+	Nickname               string
+	BotChannel             string
+	ServerId               int
+	ServerPort             int
+	AllServers             bool
+	NotificationConnection bool
+	Urls                   []string
+	AfkChannelName         string
+	SectionAfkRegex        *regexp.Regexp
+	MaxIdleTimeMs          int
+	// WeekendMaxIdleTimeMs replaces MaxIdleTimeMs on the WeekendDays, 0 if weekends use the same limit.
+	WeekendMaxIdleTimeMs int
+	WeekendDays          []time.Weekday
+	IdleConfirmSamples   int
+	IgnoredChannels      []string
+	AfkChannels          []weightedChannel
+	WarnOnlyChannels     []string
+	ReconnectWindow      time.Duration
+	MoveAway             bool
+	ChaosPercent         int
+	ChaosMaxDelay        time.Duration
+	WatchedChannels      []string
+	OptOutTag            string
+	AllowGracePeriod     bool
+	GracePeriod          time.Duration
+	Location             *time.Location
+	QuietHours           []dailyWindow
+	CalendarURL          secret
+	CalendarRefresh      time.Duration
+	CalendarAnnounce     bool
+	OverridesFile        string
+	Storage              string
+	StorageDSN           secret
+	StorageSync          time.Duration
+	// StorageBuffer is the number of writes held back while the storage backend fails.
+	StorageBuffer      int
+	OccupancySnapshot  time.Duration
+	OccupancyRetention time.Duration
+	Profiles           map[string]map[string]string
+	DefaultProfile     string
+	ProfileSchedule    []profileWindow
+	HTTPAddr           string
+	LogProfile         string
+	LogFields          map[string]string
+	LogDedupe          time.Duration
+	LogSummary         bool
+	PrivacyMode        string
+	PrivacySalt        secret
+	StatsdAddr         string
+	StatsdPrefix       string
+	StatsdTags         []string
+	DogStatsD          bool
+	AdminToken         secret
+	AdminUIDs          []string
+	PauseDefault       time.Duration
+	PauseAnnounce      bool
+	UpdateCheck        bool
+	UpdateWebhook      secret
+	AuditLog           string
+	EventWebhook       secret
+	DiscordWebhook     secret
+	SlackWebhook       secret
+	DiscordEventTypes  []string
+	MatrixEventTypes   []string
+	NotifyRatePerMin   int
+	NotifyMoveWindow   time.Duration
+	NotifyMaxMoves     int
+	// EventBuffer is the number of events buffered per event subscriber, EventOverflow which are dropped beyond it.
+	EventBuffer   int
+	EventOverflow string
+	// NotifyBuffer is the number of events each notifier holds back while its service fails.
+	NotifyBuffer     int
+	MatrixHomeserver string
+	MatrixToken      secret
+	MatrixRoom       string
+	SlackToken       secret
+	SlackChannel     string
+	SlackEventTypes  []string
+	// SlackDigestAt is the minute after midnight the daily Slack digest is posted at, -1 if disabled.
+	SlackDigestAt      int
+	EventWebhookTypes  []string
+	MutedAfkTime       time.Duration
+	MutedAfkMode       string
+	ExemptPlatforms    []string
+	ExemptVersionRegex *regexp.Regexp
+	// ExemptMyTeamSpeakIDs and ExemptBadges exempt clients by the myTeamSpeak account they are logged in with and the badges they show.
+	ExemptMyTeamSpeakIDs []string
+	ExemptBadges         []string
+	PlatformIdleTimeMs   map[string]int
+	// ConnectedIdleTimes are the idle limits by connection time, sorted by connection time.
+	ConnectedIdleTimes []connectedIdleTime
+	MinConnected       time.Duration
+	// ObserveOnly evaluates clients without moving or messaging anybody.
+	ObserveOnly bool
+	// StartupGrace counts idle times from the start of the bot at most.
+	StartupGrace      bool
+	ExemptNicknames   []*regexp.Regexp
+	ExemptGroups      []int
+	ExemptDatabaseIDs []int
+	// ExemptionSets are the named sets of clients, ExemptSets those exempt everywhere
+	// and ChannelExemptSets those exempt in a channel and its subchannels, keyed by channel name.
+	ExemptionSets     map[string]exemptionSet
+	ExemptSets        []string
+	ChannelExemptSets map[string][]string
+	GroupCacheTTL     time.Duration
+	AdaptivePolling   bool
+	PollMin           time.Duration
+	PollMax           time.Duration
+	PredictiveChecks  bool
+	SweepConfirmLimit int
+	MaxMovesPerSweep  int
+	LargeSweepLimit   int
+	BatchMoves        bool
+	// MinIdleRatio is the percentage of the users of a channel that must be idle before any of them is moved.
+	MinIdleRatio int
+	// ChannelMinIdleRatio overrides MinIdleRatio for single channels, keyed by channel name.
+	ChannelMinIdleRatio map[string]int
+	LargeSweepAction    string
+	Announce            string
+	WarnBefore          time.Duration
+	WarnMethod          string
+	ReturnHome          bool
+	ReturnCooldown      time.Duration
+	ManualMoveHold      time.Duration
+	PermissionSkipAfter int
+	PermissionSkip      time.Duration
+	// PermissionDigestAt is the minute after midnight the digest of failed moves is sent to the admins at, -1 if disabled.
+	PermissionDigestAt int
+	// ConversationWindow is how recently both users of a two-person channel must have talked to be left alone, 0 if disabled.
+	ConversationWindow time.Duration
+	// MusicBotDetection exempts clients talking in MusicBotSweeps sweeps in a row while idle for at least MusicBotIdle.
+	MusicBotDetection bool
+	MusicBotSweeps    int
+	MusicBotIdle      time.Duration
+	// AnomalyDetection skips sweeps whose client or channel list looks corrupted and holds back moves if all idle times are 0.
+	AnomalyDetection   bool
+	AnomalyChannelDrop int
+	// Language is the language of messages to clients that did not choose one and whose country is not detected.
+	Language          string
+	LanguageByCountry bool
+	// QueryInterval is the shortest time between ServerQuery commands; bans and flood errors raise it.
+	QueryInterval time.Duration
+	// UndoWindow is how long !undo can take back a move, 0 if it is disabled.
+	UndoWindow time.Duration
+	// CounterFlush is how often the cumulative counters are written to the storage backend.
+	CounterFlush time.Duration
+}
+
+func loadConfigFromEnv() (Config, error) {
+	env, err := newEnvReader()
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Config{
+		UserName:           env.required("TS3_USER"),
+		Password:           secret(env.required("TS3_PASSWORD")),
+		FallbackAccounts:   secret(env.optional("TS3_FALLBACK_ACCOUNTS", "")),
+		Urls:               env.requiredList("TS3_URL"),
+		ServerId:           env.int("TS3_SERVER_ID", 0, 1),
+		ServerPort:         env.int("TS3_SERVER_PORT", 0, 1),
+		AfkChannelName:     env.required("TS3_AFK_CHANNEL_NAME"),
+		SectionAfkRegex:    env.regexp("TS3_SECTION_AFK_CHANNEL_PATTERN"),
+		MaxIdleTimeMs:      env.int("TS3_MAX_IDLE_TIME_SEC", defaultMaxIdleTimeSec, 1) * 1000,
+		IdleConfirmSamples: env.int("TS3_IDLE_CONFIRM_SAMPLES", 1, 1),
+		IgnoredChannels:    env.stringList("TS3_IGNORED_CHANNELS"),
+		WatchedChannels:    env.stringList("TS3_WATCHED_CHANNELS"),
+		OptOutTag:          env.optional("TS3_OPT_OUT_TAG", "[noafk]"),
+		AllowGracePeriod:   env.bool("TS3_ALLOW_GRACE_PERIOD", true),
+		GracePeriod:        time.Duration(env.int("TS3_GRACE_PERIOD_SEC", defaultGracePeriodSec, 0)) * time.Second,
+		Location:           env.location("TS3_TIMEZONE"),
+		QuietHours:         env.dailyWindows("TS3_QUIET_HOURS"),
+		CalendarURL:        secret(env.optional("TS3_CALENDAR_URL", "")),
+		CalendarRefresh:    time.Duration(env.int("TS3_CALENDAR_REFRESH_SEC", 900, 60)) * time.Second,
+		CalendarAnnounce:   env.bool("TS3_CALENDAR_ANNOUNCE", false),
+	}
+	config.WeekendMaxIdleTimeMs = env.int("TS3_WEEKEND_MAX_IDLE_TIME_SEC", 0, 0) * 1000
+	config.WeekendDays = env.weekdays("TS3_WEEKEND_DAYS", defaultWeekend)
+	config.Nickname = env.optional("TS3_NICKNAME", config.UserName)
+	config.BotChannel = env.optional("TS3_BOT_CHANNEL", "")
+	config.OverridesFile = env.optional("TS3_OVERRIDES_FILE", "")
+	config.Storage = env.optional("TS3_STORAGE", "memory")
+	config.StorageDSN = secret(env.optional("TS3_STORAGE_DSN", ""))
+	config.Profiles = env.profiles("TS3_PROFILES")
+	config.DefaultProfile = env.optional("TS3_PROFILE", "")
+	config.ProfileSchedule = env.profileSchedule("TS3_PROFILE_SCHEDULE")
+	config.StorageSync = time.Duration(env.int("TS3_STORAGE_SYNC_SEC", 30, 0)) * time.Second
+	config.StorageBuffer = env.int("TS3_STORAGE_BUFFER", 1000, 0)
+	config.OccupancySnapshot = time.Duration(env.int("TS3_OCCUPANCY_SNAPSHOT_SEC", 300, 0)) * time.Second
+	config.OccupancyRetention = time.Duration(env.int("TS3_OCCUPANCY_RETENTION_DAYS", 90, 1)) * 24 * time.Hour
+	config.HTTPAddr = env.optional("TS3_HTTP_ADDR", "")
+	config.LogProfile = env.optional("TS3_LOG_PROFILE", "development")
+	config.LogFields = env.keyValueList("TS3_LOG_FIELDS")
+	config.LogDedupe = time.Duration(env.int("TS3_LOG_DEDUPE_SEC", 300, 0)) * time.Second
+	config.LogSummary = env.bool("TS3_LOG_SUMMARY", false)
+	config.PrivacyMode = env.optional("TS3_PRIVACY_MODE", privacyOff)
+	config.PrivacySalt = secret(env.optional("TS3_PRIVACY_SALT", ""))
+	config.StatsdAddr = env.optional("TS3_STATSD_ADDR", "")
+	config.StatsdPrefix = env.optional("TS3_STATSD_PREFIX", "ts3automove.")
+	config.StatsdTags = env.stringList("TS3_STATSD_TAGS")
+	config.DogStatsD = env.bool("TS3_STATSD_DOGSTATSD", false)
+	config.AdminToken = secret(env.optional("TS3_ADMIN_TOKEN", ""))
+	config.AdminUIDs = env.stringList("TS3_ADMIN_UIDS")
+	config.PauseDefault = time.Duration(env.int("TS3_PAUSE_DEFAULT_MIN", 60, 0)) * time.Minute
+	config.PauseAnnounce = env.bool("TS3_PAUSE_ANNOUNCE", false)
+	config.UpdateCheck = env.bool("TS3_UPDATE_CHECK", true)
+	config.UpdateWebhook = secret(env.optional("TS3_UPDATE_WEBHOOK", ""))
+	config.AuditLog = env.optional("TS3_AUDIT_LOG", "")
+	config.EventWebhook = secret(env.optional("TS3_EVENT_WEBHOOK", ""))
+	config.DiscordWebhook = secret(env.optional("TS3_DISCORD_WEBHOOK", ""))
+	config.EventWebhookTypes = env.stringList("TS3_EVENT_WEBHOOK_TYPES")
+	if config.EventWebhookTypes == nil {
+		config.EventWebhookTypes = []string{"move", "sweep", "pause", "resume", "error"}
+	}
+	config.DiscordEventTypes = env.stringList("TS3_DISCORD_EVENT_TYPES")
+	if config.DiscordEventTypes == nil {
+		config.DiscordEventTypes = config.EventWebhookTypes
+	}
+	config.MatrixEventTypes = env.stringList("TS3_MATRIX_EVENT_TYPES")
+	if config.MatrixEventTypes == nil {
+		config.MatrixEventTypes = config.EventWebhookTypes
+	}
+	config.NotifyRatePerMin = env.int("TS3_NOTIFY_RATE_PER_MIN", 20, 1)
+	config.NotifyMoveWindow = time.Duration(env.int("TS3_NOTIFY_MOVE_BATCH_SEC", 0, 0)) * time.Second
+	config.NotifyMaxMoves = env.int("TS3_NOTIFY_MOVE_BATCH_MAX", 25, 2)
+	config.NotifyBuffer = env.int("TS3_NOTIFY_BUFFER", 100, 1)
+	config.EventBuffer = env.int("TS3_EVENT_BUFFER", eventSubscriberBuffer, 1)
+	config.EventOverflow = env.optional("TS3_EVENT_OVERFLOW", overflowDropNewest)
+	config.MatrixHomeserver = env.optional("TS3_MATRIX_HOMESERVER", "")
+	config.MatrixToken = secret(env.optional("TS3_MATRIX_TOKEN", ""))
+	config.MatrixRoom = env.optional("TS3_MATRIX_ROOM", "")
+	config.SlackWebhook = secret(env.optional("TS3_SLACK_WEBHOOK", ""))
+	config.SlackToken = secret(env.optional("TS3_SLACK_TOKEN", ""))
+	config.SlackChannel = env.optional("TS3_SLACK_CHANNEL", "")
+	config.SlackEventTypes = env.stringList("TS3_SLACK_EVENT_TYPES")
+	if config.SlackEventTypes == nil {
+		config.SlackEventTypes = []string{"error"}
+	}
+	config.SlackDigestAt = env.timeOfDay("TS3_SLACK_DIGEST_TIME")
+	config.MutedAfkTime = time.Duration(env.int("TS3_MUTED_AFK_SEC", 0, 0)) * time.Second
+	config.MutedAfkMode = env.optional("TS3_MUTED_AFK_MODE", "input")
+	config.ExemptPlatforms = env.stringList("TS3_EXEMPT_PLATFORMS")
+	config.ExemptVersionRegex = env.regexp("TS3_EXEMPT_VERSION_PATTERN")
+	config.ExemptMyTeamSpeakIDs = env.stringList("TS3_EXEMPT_MYTEAMSPEAK_IDS")
+	config.ExemptBadges = env.stringList("TS3_EXEMPT_BADGES")
+	config.PlatformIdleTimeMs = env.platformIdleTimes("TS3_PLATFORM_MAX_IDLE_TIME_SEC")
+	config.ConnectedIdleTimes = env.connectedIdleTimes("TS3_CONNECTED_MAX_IDLE_TIME_SEC")
+	config.MinConnected = time.Duration(env.int("TS3_MIN_CONNECTED_SEC", 0, 0)) * time.Second
+	config.StartupGrace = env.bool("TS3_STARTUP_GRACE", false)
+	config.ObserveOnly = env.bool("TS3_OBSERVE_ONLY", false)
+	config.ExemptNicknames = env.regexpList("TS3_EXEMPT_NICKNAMES")
+	config.AdaptivePolling = env.bool("TS3_ADAPTIVE_POLLING", false)
+	config.PollMin = time.Duration(env.int("TS3_POLL_MIN_SEC", 5, 1)) * time.Second
+	config.PollMax = time.Duration(env.int("TS3_POLL_MAX_SEC", 60, 1)) * time.Second
+	config.PredictiveChecks = env.bool("TS3_PREDICTIVE_CHECKS", true)
+	config.SweepConfirmLimit = env.int("TS3_SWEEP_CONFIRM_LIMIT", 10, 0)
+	config.MaxMovesPerSweep = env.int("TS3_MAX_MOVES_PER_SWEEP", 0, 0)
+	config.ExemptGroups = env.intList("TS3_EXEMPT_SERVER_GROUPS")
+	config.ExemptDatabaseIDs = env.intList("TS3_EXEMPT_DATABASE_IDS")
+	config.ExemptionSets = env.exemptionSets("TS3_EXEMPTION_SETS")
+	config.ExemptSets = env.stringList("TS3_EXEMPT_SETS")
+	config.ChannelExemptSets = env.channelExemptSets("TS3_CHANNEL_EXEMPT_SETS")
+	config.GroupCacheTTL = time.Duration(env.int("TS3_GROUP_CACHE_SEC", 300, 0)) * time.Second
+	config.LargeSweepLimit = env.int("TS3_LARGE_SWEEP_LIMIT", 0, 0)
+	config.LargeSweepAction = env.optional("TS3_LARGE_SWEEP_ACTION", "confirm")
+	config.BatchMoves = env.bool("TS3_BATCH_MOVES", false)
+	config.MinIdleRatio = env.int("TS3_MIN_IDLE_RATIO_PERCENT", 0, 0)
+	config.ChannelMinIdleRatio = env.channelPercentages("TS3_CHANNEL_MIN_IDLE_RATIO_PERCENT")
+	config.Announce = env.optional("TS3_ANNOUNCE", announceOff)
+	config.WarnBefore = time.Duration(env.int("TS3_WARN_BEFORE_SEC", 0, 0)) * time.Second
+	config.WarnMethod = env.optional("TS3_WARN_METHOD", notifyMsg)
+	config.ReturnHome = env.bool("TS3_RETURN_HOME", false)
+	config.ReturnCooldown = time.Duration(env.int("TS3_RETURN_COOLDOWN_SEC", 60, 0)) * time.Second
+	config.AfkChannels = env.weightedChannels("TS3_AFK_CHANNELS")
+	config.WarnOnlyChannels = env.stringList("TS3_WARN_ONLY_CHANNELS")
+	config.MoveAway = env.bool("TS3_MOVE_AWAY", false)
+	// Only for local testing, deliberately left out of the README's configuration table.
+	config.ChaosPercent = env.int("TS3_CHAOS_PERCENT", 0, 0)
+	config.ChaosMaxDelay = time.Duration(env.int("TS3_CHAOS_MAX_DELAY_MS", 2000, 0)) * time.Millisecond
+	config.ReconnectWindow = time.Duration(env.int("TS3_RECONNECT_WINDOW_SEC", 120, 0)) * time.Second
+	config.ManualMoveHold = time.Duration(env.int("TS3_MANUAL_MOVE_HOLD_SEC", 600, 0)) * time.Second
+	config.PermissionSkipAfter = env.int("TS3_PERMISSION_SKIP_AFTER", 3, 0)
+	config.PermissionSkip = time.Duration(env.int("TS3_PERMISSION_SKIP_HOURS", 24, 1)) * time.Hour
+	config.PermissionDigestAt = env.timeOfDay("TS3_PERMISSION_DIGEST_TIME")
+	config.ConversationWindow = time.Duration(env.int("TS3_CONVERSATION_WINDOW_SEC", 0, 0)) * time.Second
+	config.MusicBotDetection = env.bool("TS3_MUSIC_BOT_DETECTION", false)
+	config.MusicBotSweeps = env.int("TS3_MUSIC_BOT_SWEEPS", 3, 1)
+	config.MusicBotIdle = time.Duration(env.int("TS3_MUSIC_BOT_IDLE_SEC", 600, 1)) * time.Second
+	config.AnomalyDetection = env.bool("TS3_ANOMALY_DETECTION", true)
+	config.AnomalyChannelDrop = env.int("TS3_ANOMALY_CHANNEL_DROP_PERCENT", 80, 1)
+	config.Language = strings.ToLower(env.optional("TS3_LANGUAGE", defaultLanguage))
+	config.LanguageByCountry = env.bool("TS3_LANGUAGE_BY_COUNTRY", true)
+	config.QueryInterval = time.Duration(env.int("TS3_QUERY_INTERVAL_MS", 0, 0)) * time.Millisecond
+	config.UndoWindow = time.Duration(env.int("TS3_UNDO_WINDOW_SEC", 900, 0)) * time.Second
+	config.CounterFlush = time.Duration(env.int("TS3_COUNTER_FLUSH_SEC", 60, 1)) * time.Second
+
+	config.AllServers = env.bool("TS3_ALL_SERVERS", false)
+	config.NotificationConnection = env.bool("TS3_NOTIFICATION_CONNECTION", false)
+
+	for _, problem := range validateConfig(config) {
+		env.fail(problem)
+	}
+	return config, env.err()
+}
+
+// envReader reads configuration values from the environment.
+// Instead of stopping at the first problem it records every missing or malformed
+// key, so a broken deployment can be fixed in a single pass.
+type envReader struct {
+	errs []error
+	// file holds the settings read from the -config file, the environment takes precedence.
+	file map[string]string
+}
+
+// newEnvReader returns a reader of the environment and, if given, the -config file.
+func newEnvReader() (*envReader, error) {
+	r := &envReader{}
+	if *configFileFlag == "" {
+		return r, nil
+	}
+	file, err := readEnvFile(*configFileFlag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	r.file = file
+	return r, nil
+}
+
+func (r *envReader) lookup(key string) (string, bool) {
+	if value, found := os.LookupEnv(key); found {
+		return value, true
+	}
+	value, found := r.file[key]
+	return value, found
+}
+
+func (r *envReader) fail(err error) {
+	r.errs = append(r.errs, err)
+}
+
+func (r *envReader) err() error {
+	return errors.Join(r.errs...)
+}
+
+func (r *envReader) required(key string) string {
+	value, found := r.lookup(key)
+	if !found {
+		r.fail(fmt.Errorf("%s not set", key))
+	}
+	return value
+}
+
+func (r *envReader) optional(key string, fallback string) string {
+	value, found := r.lookup(key)
+	if !found || value == "" {
+		return fallback
+	}
+	return value
+}
+
+func (r *envReader) requiredInt(key string, min int) int {
+	value, found := r.lookup(key)
+	if !found {
+		r.fail(fmt.Errorf("%s not set", key))
+		return 0
+	}
+	return r.parseInt(key, value, min)
+}
+
+func (r *envReader) int(key string, fallback int, min int) int {
+	value, found := r.lookup(key)
+	if !found || value == "" {
+		return fallback
+	}
+	return r.parseInt(key, value, min)
+}
+
+func (r *envReader) parseInt(key string, value string, min int) int {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		r.fail(fmt.Errorf("%s is not a number: %v", key, err))
+		return 0
+	}
+	if number < min {
+		r.fail(fmt.Errorf("%s must be at least %d, got %d", key, min, number))
+	}
+	return number
+}
+
+func (r *envReader) bool(key string, fallback bool) bool {
+	value, found := r.lookup(key)
+	if !found || value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		r.fail(fmt.Errorf("%s is not a boolean: %v", key, err))
+		return fallback
+	}
+	return parsed
+}
+
+func (r *envReader) regexp(key string) *regexp.Regexp {
+	value, found := r.lookup(key)
+	if !found || value == "" {
+		return nil
+	}
+	re, err := regexp.Compile(value)
+	if err != nil {
+		r.fail(fmt.Errorf("%s is not a valid regular expression: %v", key, err))
+	}
+	return re
+}
+
+// requiredList reads a list that must have at least one entry, in any encoding stringList accepts.
+func (r *envReader) requiredList(key string) []string {
+	list := r.stringList(key)
+	if len(list) == 0 {
+		r.fail(fmt.Errorf("%s not set", key))
+	}
+	return list
+}
+
+// regexpList reads a list of regular expressions, in any encoding stringList accepts.
+func (r *envReader) regexpList(key string) []*regexp.Regexp {
+	var list []*regexp.Regexp
+	for _, item := range r.stringList(key) {
+		re, err := regexp.Compile(item)
+		if err != nil {
+			r.fail(fmt.Errorf("%s entry %q is not a valid regular expression: %v", key, item, err))
+			continue
+		}
+		list = append(list, re)
+	}
+	return list
+}
+
+// intList reads a list of numbers in the format of stringList.
+func (r *envReader) intList(key string) []int {
+	var list []int
+	for _, item := range r.stringList(key) {
+		number, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			r.fail(fmt.Errorf("%s entry %q is not a number", key, item))
+			continue
+		}
+		list = append(list, number)
+	}
+	return list
+}
+
+// stringList reads either a JSON array or a comma-separated list.
+// In the comma-separated form a literal comma is written as `\,` and a literal backslash as `\\`.
+func (r *envReader) stringList(key string) []string {
+	value, found := r.lookup(key)
+	value = strings.TrimSpace(value)
+	if !found || value == "" {
+		return nil
+	}
+	list, err := parseStringList(value)
+	if err != nil {
+		r.fail(fmt.Errorf("%s is not a valid %v", key, err))
+	}
+	return list
+}
+
+// parseStringList parses the list encodings stringList accepts.
+func parseStringList(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(value, "[") {
+		// Channel names such as "[spacer]" also start with a bracket, so only
+		// insist on JSON if the value clearly looks like an array of strings.
+		var list []string
+		err := json.Unmarshal([]byte(value), &list)
+		if err == nil {
+			return list, nil
+		}
+		if strings.HasPrefix(value, `["`) || strings.HasPrefix(value, "[]") {
+			return nil, fmt.Errorf("json array: %v", err)
+		}
+	}
+	list, err := splitCommaList(value)
+	if err != nil {
+		return nil, fmt.Errorf("comma-separated list: %v", err)
+	}
+	return list, nil
+}
+
+// weekdays reads a list of day names like sat,sun, in any encoding stringList accepts.
+func (r *envReader) weekdays(key string, fallback []time.Weekday) []time.Weekday {
+	list := r.stringList(key)
+	if len(list) == 0 {
+		return fallback
+	}
+	days := make([]time.Weekday, 0, len(list))
+	for _, item := range list {
+		day, err := parseWeekday(item)
+		if err != nil {
+			r.fail(fmt.Errorf("%s: %v", key, err))
+			continue
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+// location reads an IANA timezone name such as Europe/Berlin, defaulting to the host's local time.
+func (r *envReader) location(key string) *time.Location {
+	value, found := r.lookup(key)
+	if !found || value == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		r.fail(fmt.Errorf("%s is not a valid IANA timezone: %v", key, err))
+		return time.Local
+	}
+	return location
+}
+
+// dailyWindows reads a list of time ranges like 22:00-07:00, in any encoding stringList accepts.
+func (r *envReader) dailyWindows(key string) []dailyWindow {
+	var windows []dailyWindow
+	for _, item := range r.stringList(key) {
+		window, err := parseDailyWindow(item)
+		if err != nil {
+			r.fail(fmt.Errorf("%s: %v", key, err))
+			continue
+		}
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+// profiles reads the policy profiles, see parseProfiles.
+func (r *envReader) profiles(key string) map[string]map[string]string {
+	value, found := r.lookup(key)
+	if !found || strings.TrimSpace(value) == "" {
+		return nil
+	}
+	defined, err := parseProfiles(value)
+	if err != nil {
+		r.fail(fmt.Errorf("%s: %v", key, err))
+	}
+	return defined
+}
+
+// profileSchedule reads a list of profile=time range entries, e.g. night=22:00-07:00, in any encoding stringList accepts.
+func (r *envReader) profileSchedule(key string) []profileWindow {
+	var schedule []profileWindow
+	for _, item := range r.stringList(key) {
+		name, value, found := strings.Cut(item, "=")
+		if !found {
+			r.fail(fmt.Errorf("%s entry %q is not profile=time range", key, item))
+			continue
+		}
+		window, err := parseDailyWindow(strings.TrimSpace(value))
+		if err != nil {
+			r.fail(fmt.Errorf("%s: %v", key, err))
+			continue
+		}
+		schedule = append(schedule, profileWindow{profile: strings.TrimSpace(name), window: window})
+	}
+	return schedule
+}
+
+// timeOfDay reads a time like 07:30 as minutes after midnight, or returns -1 if it is not set.
+func (r *envReader) timeOfDay(key string) int {
+	value := r.optional(key, "")
+	if value == "" {
+		return -1
+	}
+	minutes, err := parseClock(value)
+	if err != nil {
+		r.fail(fmt.Errorf("%s: %v", key, err))
+		return -1
+	}
+	return minutes
+}
+
+// keyValueList reads a list of key=value pairs, in any encoding stringList accepts.
+func (r *envReader) keyValueList(key string) map[string]string {
+	list := r.stringList(key)
+	if len(list) == 0 {
+		return nil
+	}
+	pairs := make(map[string]string, len(list))
+	for _, item := range list {
+		k, v, found := strings.Cut(item, "=")
+		if !found || strings.TrimSpace(k) == "" {
+			r.fail(fmt.Errorf("%s entry %q is not a key=value pair", key, item))
+			continue
+		}
+		pairs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return pairs
+}
+
+// weightedChannels reads a channel list whose entries may end in =weight, e.g. "AFK 2=3".
+// Entries without weight get weight 1.
+func (r *envReader) weightedChannels(key string) []weightedChannel {
+	var channels []weightedChannel
+	for _, item := range r.stringList(key) {
+		entry := weightedChannel{Name: item, Weight: 1}
+		if i := strings.LastIndex(item, "="); i >= 0 {
+			weight, err := strconv.Atoi(strings.TrimSpace(item[i+1:]))
+			if err != nil || weight < 1 {
+				r.fail(fmt.Errorf("%s entry %q must have a weight of at least 1", key, item))
+				continue
+			}
+			entry = weightedChannel{Name: strings.TrimSpace(item[:i]), Weight: weight}
+		}
+		channels = append(channels, entry)
+	}
+	return channels
+}
+
+// platformIdleTimes reads platform=seconds pairs into idle times in milliseconds, keyed by lower-case platform.
+func (r *envReader) platformIdleTimes(key string) map[string]int {
+	pairs := r.keyValueList(key)
+	if pairs == nil {
+		return nil
+	}
+	times := make(map[string]int, len(pairs))
+	for platform, value := range pairs {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			r.fail(fmt.Errorf("%s entry for %s must be a number of seconds of at least 1, got %q", key, platform, value))
+			continue
+		}
+		times[strings.ToLower(platform)] = seconds * 1000
+	}
+	return times
+}
+
+// connectedIdleTimes reads connected=idle pairs of seconds into idle limits by connection time,
+// e.g. "43200=600" for a limit of 10 minutes after 12 hours.
+func (r *envReader) connectedIdleTimes(key string) []connectedIdleTime {
+	pairs := r.keyValueList(key)
+	var rules []connectedIdleTime
+	for connected, value := range pairs {
+		connectedSec, err := strconv.Atoi(connected)
+		if err != nil || connectedSec < 0 {
+			r.fail(fmt.Errorf("%s entry %s=%s must start with a number of seconds connected", key, connected, value))
+			continue
+		}
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			r.fail(fmt.Errorf("%s entry for %s seconds connected must be a number of seconds of at least 1, got %q", key, connected, value))
+			continue
+		}
+		rules = append(rules, connectedIdleTime{connectedMs: connectedSec * 1000, maxIdleTimeMs: seconds * 1000})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].connectedMs < rules[j].connectedMs })
+	return rules
+}
+
+// channelPercentages reads channel=percent pairs, keyed by channel name.
+func (r *envReader) channelPercentages(key string) map[string]int {
+	pairs := r.keyValueList(key)
+	if pairs == nil {
+		return nil
+	}
+	percentages := make(map[string]int, len(pairs))
+	for channel, value := range pairs {
+		percent, err := strconv.Atoi(value)
+		if err != nil || percent < 0 {
+			r.fail(fmt.Errorf("%s entry for %s must be a percentage, got %q", key, channel, value))
+			continue
+		}
+		percentages[channel] = percent
+	}
+	return percentages
+}
+
+func splitCommaList(value string) ([]string, error) {
+	var list []string
+	var current strings.Builder
+	flush := func() {
+		if item := strings.TrimSpace(current.String()); item != "" {
+			list = append(list, item)
+		}
+		current.Reset()
+	}
+
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			if r != ',' && r != '\\' {
+				return nil, fmt.Errorf("invalid escape sequence \\%c", r)
+			}
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ',':
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	flush()
+
+	return list, nil
+}
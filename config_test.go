@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitCommaList(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  []string
+		err   string
+	}{
+		{value: "Lobby", want: []string{"Lobby"}},
+		{value: "Lobby, Music ,Games", want: []string{"Lobby", "Music", "Games"}},
+		{value: `Rock\, Paper, Scissors`, want: []string{"Rock, Paper", "Scissors"}},
+		{value: `C:\\Games,Lobby`, want: []string{`C:\Games`, "Lobby"}},
+		{value: `ends in \\`, want: []string{`ends in \`}},
+		{value: `\,`, want: []string{","}},
+		{value: "Lobby,,Music,", want: []string{"Lobby", "Music"}},
+		{value: " , ", want: nil},
+		{value: "", want: nil},
+		{value: `Lobby\`, err: "trailing backslash"},
+		{value: `Lobby,\\\`, err: "trailing backslash"},
+		{value: `\n`, err: `invalid escape sequence \n`},
+	} {
+		list, err := splitCommaList(test.value)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("splitCommaList(%q) error = %v, want %q", test.value, err, test.err)
+			}
+			continue
+		}
+		if err != nil || strings.Join(list, "|") != strings.Join(test.want, "|") || len(list) != len(test.want) {
+			t.Errorf("splitCommaList(%q) = %q, %v, want %q", test.value, list, err, test.want)
+		}
+	}
+}
+
+func TestParseStringList(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  []string
+		err   string
+	}{
+		{value: `["Lobby", "Rock, Paper"]`, want: []string{"Lobby", "Rock, Paper"}},
+		{value: ` ["Lobby"] `, want: []string{"Lobby"}},
+		{value: "[]", want: []string{}},
+		// Channel names that only start with a bracket are not JSON.
+		{value: "[spacer]", want: []string{"[spacer]"}},
+		{value: "[AFK] Lobby, Music", want: []string{"[AFK] Lobby", "Music"}},
+		{value: `["Lobby",`, err: "json array"},
+		{value: `[spacer\`, err: "comma-separated list: trailing backslash"},
+		{value: "   ", want: nil},
+	} {
+		list, err := parseStringList(test.value)
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("parseStringList(%q) error = %v, want %q", test.value, err, test.err)
+			}
+			continue
+		}
+		if err != nil || strings.Join(list, "|") != strings.Join(test.want, "|") || len(list) != len(test.want) {
+			t.Errorf("parseStringList(%q) = %q, %v, want %q", test.value, list, err, test.want)
+		}
+	}
+}
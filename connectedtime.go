@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// connectedIdleTime is the idle limit of clients that have been connected for at least connectedMs.
+type connectedIdleTime struct {
+	connectedMs   int
+	maxIdleTimeMs int
+}
+
+// connectedIdleLimit returns the idle limit of the longest connection time a client connected for
+// connectedMs has reached, and false if it has not reached any. rules are sorted by connection time.
+func connectedIdleLimit(rules []connectedIdleTime, connectedMs int) (int, bool) {
+	limit, found := 0, false
+	for _, rule := range rules {
+		if connectedMs < rule.connectedMs {
+			break
+		}
+		limit, found = rule.maxIdleTimeMs, true
+	}
+	return limit, found
+}
+
+// nextConnectedRule returns how long it takes until the next rule applies to a client connected
+// for connectedMs, or 0 if it has reached all of them.
+func nextConnectedRule(rules []connectedIdleTime, connectedMs int) time.Duration {
+	for _, rule := range rules {
+		if connectedMs < rule.connectedMs {
+			return time.Duration(rule.connectedMs-connectedMs) * time.Millisecond
+		}
+	}
+	return 0
+}
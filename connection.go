@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reconnectDelay is how long the bot waits before trying all addresses again after none of them worked.
+const reconnectDelay = 10 * time.Second
+
+// srvPrefix marks a TS3_URL entry that is resolved through a DNS SRV record.
+const srvPrefix = "srv:"
+
+// queryAddresses expands the configured addresses, resolving SRV entries into their targets
+// ordered by priority and weight.
+func queryAddresses(urls []string) []string {
+	var addresses []string
+	for _, url := range urls {
+		if !strings.HasPrefix(url, srvPrefix) {
+			addresses = append(addresses, url)
+			continue
+		}
+		name := strings.TrimPrefix(url, srvPrefix)
+		_, records, err := net.LookupSRV("", "", name)
+		if err != nil {
+			zap.S().Errorf("Failed to resolve SRV record %s: %v", name, err)
+			continue
+		}
+		for _, record := range records {
+			addresses = append(addresses, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+		}
+	}
+	return addresses
+}
+
+// connectedAddress is the address of the current command connection.
+var connectedAddress string
+
+// connect tries every address in turn and returns a client set up on the first one that works.
+func connect(config Config) (*ts3.Client, error) {
+	addresses := queryAddresses(config.Urls)
+	if len(addresses) == 0 {
+		return nil, errors.New("no ServerQuery address to connect to")
+	}
+	var errs []error
+	for _, address := range addresses {
+		client, err := connectTo(address, config)
+		if err == nil {
+			connectedAddress = address
+			if config.NotificationConnection && !config.AllServers {
+				openListener(client, config)
+			}
+			return client, nil
+		}
+		zap.S().Warnf("Failed to connect to %s: %v", address, err)
+		metrics.count("errors", 1, "op:connect")
+		errs = append(errs, fmt.Errorf("%s: %w", address, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// reconnect keeps trying to connect until it succeeds.
+func reconnect(config Config) *ts3.Client {
+	for {
+		client, err := connect(config)
+		if err == nil {
+			return client
+		}
+		if wait, banned := queryBan(err); banned {
+			waitOutBan(wait, err)
+			continue
+		}
+		if code := classifyExit(err); code == exitAuth || code == exitProtocol {
+			// Retrying does not help until someone changes the configuration.
+			exitWith(code, err)
+		}
+		zap.S().Errorf("Failed to connect to any ServerQuery address, retrying in %v: %v", reconnectDelay, err)
+		health.set(healthReconnecting, err.Error())
+		// The loop is not stuck, a restart by systemd would not bring the server back.
+		watchdogPing()
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// connectTo logs in at address, selects the virtual server and registers for the notifications the bot needs.
+// If the account lacks a permission for that, the other accounts are tried in turn.
+func connectTo(address string, config Config) (*ts3.Client, error) {
+	for {
+		client, err := ts3.NewClient(address, ts3.NotificationBuffer(notificationBufferSize))
+		if err != nil {
+			return nil, err
+		}
+		if err = setupClient(client, config); err != nil {
+			client.Close()
+			if classifyExit(err) == exitPermission {
+				revokedAccounts[accountIndex] = true
+				if nextAccount(config, fmt.Sprintf("%s lacks a permission needed to start: %v", currentAccount(config).user, err)) {
+					continue
+				}
+			}
+			return nil, err
+		}
+		zap.S().Infof("Connected to %s as %s", address, currentAccount(config).user)
+		serverDown = false
+		joinBotChannel(client, config)
+		alertRepeatedBans(client, config, address)
+		announceAccount(client, config)
+		return client, nil
+	}
+}
+
+// connectReadOnly logs in and selects the virtual server like the bot, but sets no nickname, joins no channel
+// and registers for no notifications, for the commands that only look at the server.
+func connectReadOnly(config Config) (*ts3.Client, error) {
+	addresses := queryAddresses(config.Urls)
+	if len(addresses) == 0 {
+		return nil, errors.New("no ServerQuery address to connect to")
+	}
+	var errs []error
+	for _, address := range addresses {
+		client, err := ts3.NewClient(address)
+		if err == nil {
+			if err = login(client, config); err == nil {
+				if _, err = useServer(client, config); err == nil {
+					return client, nil
+				}
+			}
+			client.Close()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", address, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func setupClient(client *ts3.Client, config Config) error {
+	if err := login(client, config); err != nil {
+		return err
+	}
+	if config.AllServers {
+		// Every sweep selects the virtual servers in turn.
+		return nil
+	}
+	return selectServer(client, config)
+}
+
+// serverID is the ID of the selected virtual server.
+var serverID int
+
+// selectServer selects the virtual server, sets the nickname and registers for notifications.
+// A restarted virtual server forgets all of this, so it is repeated once the server is back.
+func selectServer(client *ts3.Client, config Config) error {
+	// The go-ts3 calls below do not go through execCmd, which serializes commands.
+	queryMu.Lock()
+	defer queryMu.Unlock()
+	id, err := useServer(client, config)
+	if err != nil {
+		return err
+	}
+	serverID = id
+
+	if !config.ObserveOnly {
+		if err = client.SetNick(config.Nickname); err != nil {
+			zap.S().Warn(err)
+		}
+	}
+
+	whoami, err := client.Whoami()
+	if err != nil {
+		return err
+	}
+	zap.S().Infof("%+v", whoami)
+	botClientID = whoami.ClientID
+
+	if config.NotificationConnection {
+		// Notifications arrive on the listener connection.
+		return nil
+	}
+	return registerNotifications(client)
+}
+
+// useServer selects the virtual server given by TS3_SERVER_PORT or TS3_SERVER_ID and returns its ID.
+func useServer(client *ts3.Client, config Config) (int, error) {
+	id := config.ServerId
+	if config.ServerPort != 0 {
+		// Server IDs change when a snapshot is restored, the voice port stays the same.
+		var err error
+		if id, err = client.Server.IDGetByPort(uint16(config.ServerPort)); err != nil {
+			return 0, fmt.Errorf("failed to find virtual server on port %d: %w", config.ServerPort, err)
+		}
+	}
+	return id, client.Use(id)
+}
+
+// registerNotifications registers client for the notifications the bot needs.
+func registerNotifications(client *ts3.Client) error {
+	// Channel events include cliententerview and clientmoved for all channels.
+	if err := client.Register(ts3.ChannelEvents); err != nil {
+		return fmt.Errorf("failed to register for channel events: %w", err)
+	}
+	// Private text messages carry the chat commands.
+	if err := client.Register(ts3.TextPrivateEvents); err != nil {
+		return fmt.Errorf("failed to register for private text messages: %w", err)
+	}
+	return nil
+}
+
+// reopen closes the connections after one of them was lost and connects again.
+func reopen(client *ts3.Client, config Config) *ts3.Client {
+	closeListener()
+	client.Close()
+	metrics.count("reconnects", 1)
+	client = reconnect(config)
+	// Notifications were missed while disconnected.
+	channelList.invalidate()
+	return client
+}
+
+// recoverServer selects the virtual server again after it was stopped and reports whether it is running.
+func recoverServer(client *ts3.Client, config Config) bool {
+	if err := selectServer(client, config); err != nil {
+		if kind, _ := classifyQueryError(err); kind != queryErrorServerDown {
+			zap.S().Errorf("Failed to select virtual server %d: %v", serverID, err)
+		}
+		return false
+	}
+	serverDown = false
+	zap.S().Infof("Virtual server %d is running again", serverID)
+	if config.NotificationConnection {
+		// The listener lost its virtual server as well.
+		closeListener()
+		openListener(client, config)
+	}
+	// Channels and clients may have changed while the server was down.
+	channelList.invalidate()
+	joinBotChannel(client, config)
+	return true
+}
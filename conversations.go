@@ -0,0 +1,48 @@
+package main
+
+import "time"
+
+// lastTalked holds, per client ID, when the client was last seen talking by a sweep.
+var lastTalked = make(map[int]time.Time)
+
+// trackTalking remembers which clients are talking at the time of the sweep.
+// A sweep only sees who talks at that moment, so over a conversation each side is caught now and then.
+func (s *sweep) trackTalking() {
+	if s.config.ConversationWindow <= 0 {
+		return
+	}
+	for _, c := range s.clients {
+		if c.Talking {
+			lastTalked[c.ID] = s.now
+		}
+	}
+	for id, at := range lastTalked {
+		if s.now.Sub(at) > s.config.ConversationWindow {
+			delete(lastTalked, id)
+		}
+	}
+}
+
+// inConversation reports whether c shares its channel with exactly one other client
+// and both of them talked within TS3_CONVERSATION_WINDOW_SEC.
+func (s *sweep) inConversation(c *clientInfo) bool {
+	if s.config.ConversationWindow <= 0 {
+		return false
+	}
+	var other *clientInfo
+	for _, c2 := range s.clients {
+		if c2.ChannelID != c.ChannelID || c2.ID == c.ID {
+			continue
+		}
+		if other != nil {
+			return false
+		}
+		other = c2
+	}
+	return other != nil && s.talkedRecently(c) && s.talkedRecently(other)
+}
+
+func (s *sweep) talkedRecently(c *clientInfo) bool {
+	at, ok := lastTalked[c.ID]
+	return ok && s.now.Sub(at) <= s.config.ConversationWindow
+}
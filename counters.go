@@ -0,0 +1,110 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Counters the bot keeps in the storage backend besides the metrics counters.
+const (
+	// counterStarts counts the starts of the bot.
+	counterStarts = "starts"
+	// counterUptime is the time the bot ran, in seconds.
+	counterUptime = "uptime_sec"
+)
+
+// counterFlusher adds what the metrics counters counted since the last flush, and the time the bot ran,
+// to the cumulative counters in the storage backend, so totals survive restarts and upgrades.
+type counterFlusher struct {
+	mu sync.Mutex
+	// flushed holds the values of the metrics counters already added to the storage.
+	flushed map[string]int64
+	// runningSince is the start of the uptime not yet added to the storage.
+	runningSince time.Time
+}
+
+var counters = &counterFlusher{flushed: make(map[string]int64)}
+
+// start counts a start of the bot and begins measuring its uptime.
+func (f *counterFlusher) start(now time.Time) {
+	f.mu.Lock()
+	f.runningSince = now
+	f.mu.Unlock()
+	if err := storage.AddCounters(map[string]int64{counterStarts: 1}); err != nil {
+		zap.S().Errorf("Failed to count the start: %v", err)
+	}
+}
+
+// flush adds the counts and uptime since the last flush to the storage. After a failure they are added by the next one.
+func (f *counterFlusher) flush(now time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deltas := make(map[string]int64)
+	stats.mu.Lock()
+	for name, value := range stats.counts {
+		if delta := value - f.flushed[name]; delta != 0 {
+			deltas[name] = delta
+		}
+	}
+	stats.mu.Unlock()
+	uptime := now.Sub(f.runningSince) / time.Second
+	if !f.runningSince.IsZero() && uptime > 0 {
+		deltas[counterUptime] = int64(uptime)
+	}
+	if len(deltas) == 0 {
+		return nil
+	}
+	if err := storage.AddCounters(deltas); err != nil {
+		return err
+	}
+	for name, delta := range deltas {
+		if name != counterUptime {
+			f.flushed[name] += delta
+		}
+	}
+	if uptime > 0 {
+		f.runningSince = f.runningSince.Add(uptime * time.Second)
+	}
+	return nil
+}
+
+// totals flushes the counters and returns the cumulative ones from the storage.
+func (f *counterFlusher) totals() (map[string]int64, error) {
+	if err := f.flush(time.Now()); err != nil {
+		return nil, err
+	}
+	return storage.Counters()
+}
+
+// runCounterFlush flushes the counters every interval.
+func runCounterFlush(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := counters.flush(time.Now()); err != nil {
+			zap.S().Errorf("Failed to store counters: %v", err)
+		}
+	}
+}
+
+// flushCounters flushes the counters before the bot exits.
+func flushCounters() {
+	if err := counters.flush(time.Now()); err != nil {
+		zap.S().Errorf("Failed to store counters: %v", err)
+	}
+}
+
+// handleTotals serves GET /stats/totals with the counters of all runs of the bot.
+func handleTotals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	totals, err := counters.totals()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, totals)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+)
+
+// databaseIDLookup is the clientgetdbidfromuid response.
+type databaseIDLookup struct {
+	DatabaseID int `ms:"cldbid"`
+}
+
+// getDatabaseID returns the database ID of the client with the given unique identifier, whether it is online or not.
+func getDatabaseID(client *ts3.Client, uid string) (int, error) {
+	var lookup databaseIDLookup
+	if err := execQuery(client, ts3.NewCmd("clientgetdbidfromuid").WithArgs(ts3.NewArg("cluid", uid)), &lookup); err != nil {
+		return 0, err
+	}
+	return lookup.DatabaseID, nil
+}
+
+// databaseIDExemptions holds the exemptions keyed by client database ID. Besides TS3_EXEMPT_DATABASE_IDS it
+// contains the database IDs of clients exempted by a UID override, so integrations keyed by database ID,
+// e.g. ranking bots, can share the list through GET /exemptions.
+// It is shared between the mover loop and the admin API and therefore guarded by a mutex.
+type databaseIDExemptions struct {
+	mu         sync.RWMutex
+	configured []int
+	byUID      map[string]int
+}
+
+var exemptDatabaseIDs = &databaseIDExemptions{byUID: make(map[string]int)}
+
+// contains reports whether the client with database ID cldbid is exempt.
+func (e *databaseIDExemptions) contains(cldbid int) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, id := range e.configured {
+		if id == cldbid {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve looks up the database IDs of clients exempted by an override that were not looked up yet.
+// Unique identifiers the server does not know are remembered as 0 and not looked up again.
+func (e *databaseIDExemptions) resolve(client *ts3.Client, config Config) {
+	for uid, override := range clientOverrides.all() {
+		e.mu.RLock()
+		_, known := e.byUID[uid]
+		e.mu.RUnlock()
+		if !override.Exempt || known {
+			continue
+		}
+		id, err := getDatabaseID(client, uid)
+		if err != nil {
+			if _, tsErr := classifyQueryError(err); tsErr == nil {
+				zap.S().Errorf("Failed to look up database ID of %s: %v", privacy.uid(uid), err)
+				return
+			}
+			zap.S().Warnf("Exempt client %s is not known to the server: %v", privacy.uid(uid), err)
+			handleQueryError(client, config, "clientgetdbidfromuid", err)
+		}
+		e.mu.Lock()
+		e.byUID[uid] = id
+		e.mu.Unlock()
+	}
+}
+
+// all returns the database IDs of all exempt clients in ascending order.
+func (e *databaseIDExemptions) all() []int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	seen := make(map[int]bool)
+	ids := []int{}
+	add := func(id int) {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range e.configured {
+		add(id)
+	}
+	for uid, id := range e.byUID {
+		// Overrides removed since the lookup are no longer exempt.
+		if override, ok := clientOverrides.get(uid); ok && override.Exempt {
+			add(id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
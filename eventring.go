@@ -0,0 +1,99 @@
+package main
+
+import "sync"
+
+// Overflow policies of the event buffers, chosen by TS3_EVENT_OVERFLOW.
+const (
+	overflowDropOldest = "drop-oldest"
+	overflowDropNewest = "drop-newest"
+)
+
+// eventRing buffers the events of one subscriber, so the mover never waits for it. Once size events
+// are waiting, policy decides whether the oldest one or the new one is dropped.
+type eventRing struct {
+	name   string
+	policy string
+
+	mu    sync.Mutex
+	buf   []botEvent
+	start int
+	count int
+	// sending is set while an event taken from the buffer waits for the subscriber to receive it.
+	sending bool
+	// ready is signalled when an event was added.
+	ready chan struct{}
+}
+
+func newEventRing(name string, size int, policy string) *eventRing {
+	return &eventRing{name: name, policy: policy, buf: make([]botEvent, size), ready: make(chan struct{}, 1)}
+}
+
+// push adds an event, dropping one if the buffer is full.
+func (r *eventRing) push(event botEvent) {
+	r.mu.Lock()
+	if r.count == len(r.buf) {
+		if r.policy != overflowDropOldest {
+			r.mu.Unlock()
+			metrics.count("events.dropped", 1, "subscriber:"+r.name)
+			return
+		}
+		r.start = (r.start + 1) % len(r.buf)
+		r.count--
+		metrics.count("events.dropped", 1, "subscriber:"+r.name)
+	}
+	r.buf[(r.start+r.count)%len(r.buf)] = event
+	r.count++
+	r.mu.Unlock()
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pending returns the number of events the subscriber has not received yet.
+func (r *eventRing) pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sending {
+		return r.count + 1
+	}
+	return r.count
+}
+
+// run hands the buffered events, oldest first, to out until done is closed.
+func (r *eventRing) run(out chan<- botEvent, done <-chan struct{}) {
+	for {
+		r.mu.Lock()
+		if r.count == 0 {
+			r.mu.Unlock()
+			select {
+			case <-r.ready:
+				continue
+			case <-done:
+				return
+			}
+		}
+		event := r.buf[r.start]
+		r.buf[r.start] = botEvent{}
+		r.start = (r.start + 1) % len(r.buf)
+		r.count--
+		r.sending = true
+		r.mu.Unlock()
+
+		select {
+		case out <- event:
+		case <-done:
+			return
+		}
+		r.mu.Lock()
+		r.sending = false
+		r.mu.Unlock()
+	}
+}
+
+// eventSubscriber receives the published events on ch.
+type eventSubscriber struct {
+	ch   chan botEvent
+	ring *eventRing
+	done chan struct{}
+}
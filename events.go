@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// recentJoins holds the last time a client entered each channel, keyed by channel ID.
+var recentJoins = make(map[int]time.Time)
+
+// handleNotification updates the bot state from a ServerQuery notification.
+func handleNotification(client *ts3.Client, config Config, n ts3.Notification) {
+	switch n.Type {
+	case "textmessage":
+		handleTextMessage(client, config, n, invokerCountry(n))
+	case "channeledited", "channelcreated", "channeldeleted", "channelmoved", "channeldescriptionchanged", "channelpasswordchanged":
+		// Channel IDs of the AFK, ignored and bot channels are resolved by name from the channel list, so
+		// a renamed or deleted channel is picked up by the next sweep.
+		channelList.invalidate()
+		if n.Type == "channeldeleted" {
+			if channelId, err := strconv.Atoi(n.Data["cid"]); err == nil && channelId == botChannelID {
+				botChannelID = 0
+			}
+		}
+	case "cliententerview", "clientmoved":
+		// Both events carry the channel the client ended up in as ctid.
+		channelId, err := strconv.Atoi(n.Data["ctid"])
+		if err != nil {
+			zap.S().Warnf("Ignoring %s notification without valid ctid: %v", n.Type, n.Data)
+			return
+		}
+		if clientId, err := strconv.Atoi(n.Data["clid"]); err == nil && clientId == botClientID {
+			if channelId != botChannelID {
+				// Somebody moved the bot, go back to the bot channel.
+				joinBotChannel(client, config)
+			}
+			return
+		}
+		// A client that reconnects right away does not give its channel a new grace period.
+		if n.Type == "clientmoved" || !reconnecting(n.Data["client_unique_identifier"], config.ReconnectWindow) {
+			recentJoins[channelId] = clock.Now()
+		}
+
+		clientId, err := strconv.Atoi(n.Data["clid"])
+		if err != nil {
+			return
+		}
+		if n.Type == "clientmoved" {
+			trackManualMove(clientId, n.Data, config.ManualMoveHold)
+		} else {
+			// Client IDs are reused, so a joining client must not inherit the state of a previous one.
+			checks.remove(clientId)
+			delete(manualMoves, clientId)
+		}
+	}
+}
+
+// pruneRecentJoins forgets joins whose grace period has run out.
+func pruneRecentJoins(gracePeriod time.Duration) {
+	for channelId, joinTime := range recentJoins {
+		if clock.Now().Sub(joinTime) > gracePeriod {
+			delete(recentJoins, channelId)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"time"
+)
+
+// notifyMaxBatch is the number of events chat notifiers combine into one message.
+const notifyMaxBatch = 10
+
+// eventSinkTimeout bounds how long a webhook may take, so a hanging endpoint does not pile up events.
+const eventSinkTimeout = 10 * time.Second
+
+// eventSink receives bot events, e.g. to forward them to another service.
+// Each sink runs in its own goroutine, so it may block without holding up the mover or other sinks.
+type eventSink interface {
+	handle(event botEvent)
+}
+
+// attach delivers the events of the given types, or all events if types is empty, to sink.
+func (h *eventHub) attach(name string, sink eventSink, types []string) {
+	sub := h.subscribe(name)
+	go func() {
+		for event := range sub.ch {
+			if wantsEvent(types, event) {
+				sink.handle(event)
+			}
+		}
+	}()
+}
+
+// setupEventSinks attaches the sinks enabled in the config to the event hub.
+func setupEventSinks(config Config) error {
+	events.attach("metrics", metricsEventSink{}, nil)
+	if config.AuditLog != "" {
+		file, err := os.OpenFile(config.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		events.attach("audit", &auditLogSink{encoder: json.NewEncoder(file)}, nil)
+	}
+	chat := notifyOptions{perMinute: config.NotifyRatePerMin, maxBatch: notifyMaxBatch, moveWindow: config.NotifyMoveWindow, maxMoves: config.NotifyMaxMoves, buffer: config.NotifyBuffer}
+	if config.EventWebhook != "" {
+		events.attachNotifier(&webhookNotifier{url: config.EventWebhook.value(), client: http.Client{Timeout: eventSinkTimeout}},
+			notifyOptions{types: config.EventWebhookTypes, perMinute: config.NotifyRatePerMin, maxBatch: 1, moveWindow: config.NotifyMoveWindow, maxMoves: config.NotifyMaxMoves, buffer: config.NotifyBuffer})
+	}
+	if config.DiscordWebhook != "" {
+		chat.types = config.DiscordEventTypes
+		events.attachNotifier(&discordNotifier{url: config.DiscordWebhook.value(), client: http.Client{Timeout: eventSinkTimeout}}, chat)
+	}
+	if config.MatrixHomeserver != "" {
+		chat.types = config.MatrixEventTypes
+		events.attachNotifier(newMatrixNotifier(config), chat)
+	}
+	if config.SlackWebhook != "" || config.SlackToken != "" {
+		slack := newSlackNotifier(config)
+		chat.types = config.SlackEventTypes
+		events.attachNotifier(slack, chat)
+		if config.SlackDigestAt >= 0 {
+			digest := &slackDigest{slack: slack, counts: make(map[string]int), since: time.Now()}
+			events.attach("slack-digest", digest, nil)
+			go digest.run(config.SlackDigestAt, config.Location)
+		}
+	}
+	return nil
+}
+
+// metricsEventSink counts events by type.
+type metricsEventSink struct{}
+
+func (metricsEventSink) handle(event botEvent) {
+	if event.Reason != "" {
+		metrics.count("events", 1, "type:"+event.Type, "reason:"+event.Reason)
+		return
+	}
+	metrics.count("events", 1, "type:"+event.Type)
+}
+
+// auditLogSink appends every event as a JSON line to TS3_AUDIT_LOG.
+type auditLogSink struct {
+	encoder *json.Encoder
+}
+
+func (s *auditLogSink) handle(event botEvent) {
+	if err := s.encoder.Encode(event); err != nil {
+		zap.S().Errorf("Failed to write audit log: %v", err)
+	}
+}
+
+// webhookNotifier posts each event as JSON to TS3_EVENT_WEBHOOK.
+type webhookNotifier struct {
+	url    string
+	client http.Client
+}
+
+func (n *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *webhookNotifier) Send(events []botEvent) error {
+	// Receivers expect one event per request, so the webhook is attached with batches of one.
+	for _, event := range events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return permanentError{err}
+		}
+		if _, err = postJSON(&n.client, http.MethodPost, n.url, body, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discordMessageLimit is the longest message content Discord accepts.
+const discordMessageLimit = 2000
+
+// discordNotifier posts events as messages to a Discord webhook.
+type discordNotifier struct {
+	url    string
+	client http.Client
+}
+
+func (n *discordNotifier) Name() string {
+	return "discord"
+}
+
+func (n *discordNotifier) Send(events []botEvent) error {
+	body, err := json.Marshal(map[string]string{"content": eventLines(events, discordMessageLimit)})
+	if err != nil {
+		return permanentError{err}
+	}
+	_, err = postJSON(&n.client, http.MethodPost, n.url, body, nil)
+	return err
+}
+
+// text formats the event as a single line for chat services.
+func (e botEvent) text() string {
+	msg := e.Message
+	if e.Reason != "" {
+		msg += " (" + e.Reason + ")"
+	}
+	if e.Nickname != "" {
+		return fmt.Sprintf("[%s] %s: %s", e.Type, e.Nickname, msg)
+	}
+	return fmt.Sprintf("[%s] %s", e.Type, msg)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventSubscriberBuffer is the default number of events buffered per subscriber before events are dropped for it.
+const eventSubscriberBuffer = 64
+
+// botEvent is a structured record of something the bot decided or did.
+type botEvent struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Nickname string    `json:"nickname,omitempty"`
+	UID      string    `json:"uid,omitempty"`
+	Message  string    `json:"message"`
+	// Reason is the reason code of a warning or move.
+	Reason string `json:"reason,omitempty"`
+	// Nicknames lists the moved clients of a move event combined from several, see batchMoveEvents.
+	Nicknames []string `json:"nicknames,omitempty"`
+}
+
+// eventHub fans bot events out to all subscribers.
+// A slow subscriber loses events instead of holding up the mover.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+	// size and policy apply to the buffers of new subscribers, see TS3_EVENT_BUFFER and TS3_EVENT_OVERFLOW.
+	size   int
+	policy string
+}
+
+var events = &eventHub{subscribers: make(map[*eventSubscriber]struct{}), size: eventSubscriberBuffer, policy: overflowDropNewest}
+
+// configure sets the buffer size and overflow policy of the subscribers attached afterwards.
+func (h *eventHub) configure(size int, policy string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.size, h.policy = size, policy
+}
+
+// subscribe starts delivering events to a new subscriber, named for the events.dropped metric.
+func (h *eventHub) subscribe(name string) *eventSubscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := &eventSubscriber{ch: make(chan botEvent), ring: newEventRing(name, h.size, h.policy), done: make(chan struct{})}
+	go sub.ring.run(sub.ch, sub.done)
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, sub)
+	close(sub.done)
+}
+
+func (h *eventHub) publish(event botEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		sub.ring.push(event)
+	}
+}
+
+// drain waits until every subscriber has taken its pending events, or until timeout has passed,
+// so that a bot about to exit gets its last events out.
+func (h *eventHub) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		h.mu.Lock()
+		pending := 0
+		for sub := range h.subscribers {
+			pending += sub.ring.pending()
+		}
+		h.mu.Unlock()
+		if pending == 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// publishClientEvent publishes an event concerning a single client.
+func publishClientEvent(eventType string, c *clientInfo, message string) {
+	publishClientAction(eventType, c, "", message)
+}
+
+// publishClientAction publishes a warning or move of a single client with its reason code.
+func publishClientAction(eventType string, c *clientInfo, reason string, message string) {
+	events.publish(botEvent{Type: eventType, Nickname: c.logName(), UID: privacy.uid(c.UniqueIdentifier), Message: message, Reason: reason})
+}
+
+var upgrader = websocket.Upgrader{
+	// Access is guarded by the admin token, so cross-origin dashboards are fine.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleEvents serves GET /events, streaming bot events as JSON messages over a WebSocket.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with an error.
+		return
+	}
+	defer conn.Close()
+
+	sub := events.subscribe("websocket")
+	defer events.unsubscribe(sub)
+
+	// Drain incoming frames so close and pong messages are processed.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case event := <-sub.ch:
+			_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err = conn.WriteJSON(event); err != nil {
+				zap.S().Debugf("Event stream client disconnected: %v", err)
+				return
+			}
+		case <-ping.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
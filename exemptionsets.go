@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// exemptionSet is a named group of clients, e.g. bots or staff, that TS3_EXEMPT_SETS, profiles
+// and TS3_CHANNEL_EXEMPT_SETS exempt by name. A client belongs to it if any of its entries match.
+type exemptionSet struct {
+	UIDs         []string
+	ServerGroups []int
+	Nicknames    []*regexp.Regexp
+	DatabaseIDs  []int
+}
+
+// parseExemptionSets reads a JSON object of sets, e.g. {"bots": {"nicknames": ["(?i)musicbot"]}, "staff": {"server_groups": [6]}}.
+func parseExemptionSets(value string) (map[string]exemptionSet, error) {
+	var raw map[string]struct {
+		UIDs         []string `json:"uids"`
+		ServerGroups []int    `json:"server_groups"`
+		Nicknames    []string `json:"nicknames"`
+		DatabaseIDs  []int    `json:"database_ids"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("not a JSON object of sets with uids, server_groups, nicknames and database_ids: %v", err)
+	}
+	sets := make(map[string]exemptionSet, len(raw))
+	for name, entries := range raw {
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%q cannot be used as set name", name)
+		}
+		set := exemptionSet{UIDs: entries.UIDs, ServerGroups: entries.ServerGroups, DatabaseIDs: entries.DatabaseIDs}
+		for _, pattern := range entries.Nicknames {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("set %s: invalid nickname pattern %q: %v", name, pattern, err)
+			}
+			set.Nicknames = append(set.Nicknames, re)
+		}
+		sets[name] = set
+	}
+	return sets, nil
+}
+
+// exemptionSets reads TS3_EXEMPTION_SETS.
+func (r *envReader) exemptionSets(key string) map[string]exemptionSet {
+	value, found := r.lookup(key)
+	if !found || strings.TrimSpace(value) == "" {
+		return nil
+	}
+	sets, err := parseExemptionSets(value)
+	if err != nil {
+		r.fail(fmt.Errorf("%s: %v", key, err))
+	}
+	return sets
+}
+
+// channelExemptSets reads channel=set pairs whose sets are separated by spaces, e.g. "Lounge=staff donors".
+func (r *envReader) channelExemptSets(key string) map[string][]string {
+	pairs := r.keyValueList(key)
+	if pairs == nil {
+		return nil
+	}
+	channels := make(map[string][]string, len(pairs))
+	for channel, value := range pairs {
+		names := strings.Fields(value)
+		if len(names) == 0 {
+			r.fail(fmt.Errorf("%s entry for %s names no set", key, channel))
+			continue
+		}
+		channels[channel] = names
+	}
+	return channels
+}
+
+// unknownExemptionSets returns the names among names that TS3_EXEMPTION_SETS does not define, sorted.
+func unknownExemptionSets(config Config, names []string) []string {
+	var unknown []string
+	for _, name := range names {
+		if _, ok := config.ExemptionSets[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// exemptionSetNames returns the names of the defined sets, sorted.
+func exemptionSetNames(config Config) []string {
+	names := make([]string, 0, len(config.ExemptionSets))
+	for name := range config.ExemptionSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// memberOfExemptSet returns the name of the first set exempting c, either everywhere by TS3_EXEMPT_SETS
+// or by TS3_CHANNEL_EXEMPT_SETS in the channel c is in, and whether there is one.
+func (s *sweep) memberOfExemptSet(c *clientInfo) (string, bool) {
+	names := s.config.ExemptSets
+	for channel, channelSets := range s.config.ChannelExemptSets {
+		if s.tree.inSubtree(c.ChannelID, []string{channel}) {
+			names = append(names[:len(names):len(names)], channelSets...)
+		}
+	}
+	for _, name := range names {
+		set, ok := s.config.ExemptionSets[name]
+		if ok && s.inExemptionSet(c, set) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// inExemptionSet reports whether c matches an entry of set. Server groups are checked last,
+// since their members may have to be queried.
+func (s *sweep) inExemptionSet(c *clientInfo, set exemptionSet) bool {
+	for _, uid := range set.UIDs {
+		if uid == c.UniqueIdentifier {
+			return true
+		}
+	}
+	for _, re := range set.Nicknames {
+		if re.MatchString(c.Nickname) {
+			return true
+		}
+	}
+	for _, id := range set.DatabaseIDs {
+		if id == c.DatabaseID {
+			return true
+		}
+	}
+	return len(set.ServerGroups) > 0 && groupMembers.inAny(s.client, s.config, c.DatabaseID, set.ServerGroups)
+}
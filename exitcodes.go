@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes of the bot, so that systemd, Docker or scripts can tell whether restarting it will help.
+const (
+	// exitFailure covers everything else, e.g. an unreachable server. Restarting may help.
+	exitFailure = 1
+	// exitUsage is an unknown command-line subcommand.
+	exitUsage = 2
+	// exitConfig is an invalid environment variable or configuration file.
+	exitConfig = 3
+	// exitAuth is a rejected ServerQuery login.
+	exitAuth = 4
+	// exitPermission is a permission the query account lacks and the bot cannot start without.
+	exitPermission = 5
+	// exitProtocol is a server that does not speak ServerQuery or sends responses the bot cannot read.
+	exitProtocol = 6
+	// exitIncomplete is a --once run whose sweep did not run or could not check or move every client.
+	exitIncomplete = 7
+)
+
+// restartDelay is how long the bot waits before exiting after a failure restarting may fix,
+// so that a container restarted right away does not hammer the server.
+const restartDelay = time.Minute
+
+// classifyExit returns the exit code for an error the bot cannot recover from.
+func classifyExit(err error) int {
+	var tsErr *ts3.Error
+	if errors.As(err, &tsErr) {
+		switch tsErr.ID {
+		case queryErrInvalidLogin:
+			return exitAuth
+		case queryErrInsufficientPermission:
+			return exitPermission
+		}
+	}
+	if _, banned := queryBan(err); banned {
+		// The ban ends, and with it the greeting go-ts3 cannot read.
+		return exitFailure
+	}
+	var responseErr *ts3.InvalidResponseError
+	// go-ts3 does not export an error for an unexpected greeting.
+	if errors.As(err, &responseErr) || strings.Contains(err.Error(), "invalid connection header") {
+		return exitProtocol
+	}
+	return exitFailure
+}
+
+// exitWith logs err and terminates the bot with code.
+func exitWith(code int, err error) {
+	zap.S().Errorf("%v (exit code %d)", err, code)
+	if code == exitFailure {
+		time.Sleep(restartDelay)
+	}
+	_ = zap.L().Sync()
+	os.Exit(code)
+}
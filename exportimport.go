@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// dumpVersion is the version of the export format, raised when it changes incompatibly.
+const dumpVersion = 1
+
+// storageDump is everything in a storage backend, in the format of the export and import commands.
+// Client unique identifiers are kept as they are in the backend, i.e. hashed or truncated with TS3_PRIVACY_MODE.
+type storageDump struct {
+	Version      int                             `json:"version"`
+	ExportedAt   time.Time                       `json:"exported_at"`
+	Overrides    map[string]ClientOverride       `json:"overrides"`
+	HomeChannels map[string]int                  `json:"home_channels"`
+	Settings     map[string]string               `json:"settings"`
+	Cooldowns    map[string]map[string]time.Time `json:"cooldowns"`
+	Moves        []MoveRecord                    `json:"moves"`
+	Occupancy    []OccupancySnapshot             `json:"occupancy"`
+	Counters     map[string]int64                `json:"counters,omitempty"`
+}
+
+// dumpKinds are the cooldown kinds the export includes.
+var dumpKinds = []string{cooldownManualMove}
+
+// openConfiguredStorage opens the storage backend selected by TS3_STORAGE and TS3_STORAGE_DSN,
+// for the commands that work on it without starting the bot.
+func openConfiguredStorage() (Storage, error) {
+	env, err := newEnvReader()
+	if err != nil {
+		return nil, err
+	}
+	kind := env.optional("TS3_STORAGE", "memory")
+	if kind == "memory" {
+		return nil, errors.New("TS3_STORAGE is memory, which keeps nothing to export or import into")
+	}
+	return openStorage(kind, env.optional("TS3_STORAGE_DSN", ""))
+}
+
+// exportStorage writes the configured storage as JSON to path, or to stdout if path is empty.
+func exportStorage(path string) error {
+	store, err := openConfiguredStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	dump := storageDump{Version: dumpVersion, ExportedAt: time.Now(), Cooldowns: make(map[string]map[string]time.Time)}
+	if dump.Overrides, err = store.Overrides(); err != nil {
+		return fmt.Errorf("failed to read overrides: %w", err)
+	}
+	if dump.HomeChannels, err = store.HomeChannels(); err != nil {
+		return fmt.Errorf("failed to read home channels: %w", err)
+	}
+	if dump.Settings, err = store.Settings(); err != nil {
+		return fmt.Errorf("failed to read settings: %w", err)
+	}
+	for _, kind := range dumpKinds {
+		if dump.Cooldowns[kind], err = store.Cooldowns(kind); err != nil {
+			return fmt.Errorf("failed to read %s cooldowns: %w", kind, err)
+		}
+	}
+	if dump.Moves, err = store.Moves(); err != nil {
+		return fmt.Errorf("failed to read moves: %w", err)
+	}
+	if dump.Occupancy, err = store.OccupancyHistory(time.Time{}); err != nil {
+		return fmt.Errorf("failed to read occupancy: %w", err)
+	}
+	if dump.Counters, err = store.Counters(); err != nil {
+		return fmt.Errorf("failed to read counters: %w", err)
+	}
+
+	out := os.Stdout
+	if path != "" {
+		if out, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(dump); err != nil {
+		return err
+	}
+	if path != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d overrides, %d home channels, %d settings, %d moves and %d occupancy snapshots to %s\n",
+			len(dump.Overrides), len(dump.HomeChannels), len(dump.Settings), len(dump.Moves), len(dump.Occupancy), path)
+	}
+	return nil
+}
+
+// importStorage adds the contents of an export at path to the configured storage.
+// Overrides, home channels, settings and cooldowns replace those with the same key,
+// moves and occupancy snapshots are appended and counters added to, so importing the same file twice duplicates them.
+func importStorage(path string) error {
+	if path == "" {
+		return errors.New("usage: import <file>")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var dump storageDump
+	if err = json.Unmarshal(data, &dump); err != nil {
+		return fmt.Errorf("%s is not an export: %w", path, err)
+	}
+	if dump.Version != dumpVersion {
+		return fmt.Errorf("%s has export format version %d, this version reads %d", path, dump.Version, dumpVersion)
+	}
+
+	store, err := openConfiguredStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for uid, override := range dump.Overrides {
+		if err = store.SaveOverride(uid, override); err != nil {
+			return fmt.Errorf("failed to import override of %s: %w", uid, err)
+		}
+	}
+	for uid, channelId := range dump.HomeChannels {
+		if err = store.SetHomeChannel(uid, channelId); err != nil {
+			return fmt.Errorf("failed to import home channel of %s: %w", uid, err)
+		}
+	}
+	for name, value := range dump.Settings {
+		if err = store.SaveSetting(name, value); err != nil {
+			return fmt.Errorf("failed to import setting %s: %w", name, err)
+		}
+	}
+	for kind, cooldowns := range dump.Cooldowns {
+		for uid, until := range cooldowns {
+			if err = store.SetCooldown(kind, uid, until); err != nil {
+				return fmt.Errorf("failed to import %s cooldown of %s: %w", kind, uid, err)
+			}
+		}
+	}
+	for _, record := range dump.Moves {
+		if err = store.RecordMove(record); err != nil {
+			return fmt.Errorf("failed to import move of %s: %w", record.UID, err)
+		}
+	}
+	if len(dump.Occupancy) > 0 {
+		if err = store.RecordOccupancy(dump.Occupancy); err != nil {
+			return fmt.Errorf("failed to import occupancy: %w", err)
+		}
+	}
+	if len(dump.Counters) > 0 {
+		if err = store.AddCounters(dump.Counters); err != nil {
+			return fmt.Errorf("failed to import counters: %w", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d overrides, %d home channels, %d settings, %d moves and %d occupancy snapshots from %s\n",
+		len(dump.Overrides), len(dump.HomeChannels), len(dump.Settings), len(dump.Moves), len(dump.Occupancy), path)
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock the tests move forward by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// fakeClient is a client connected to a fakeServer.
+type fakeClient struct {
+	id       int
+	channel  int
+	nickname string
+	uid      string
+	idle     time.Duration
+}
+
+// fakeServer answers the ServerQuery commands of a sweep from a fixed channel list and the clients
+// added to it, and records the moves. Commands it does not know succeed with an empty response.
+type fakeServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	channels []string
+	clients  []*fakeClient
+	// moves holds the clientmove commands received, as "clid->cid".
+	moves []string
+}
+
+// newFakeServer starts a server with a Lobby (1) and an AFK channel (2) and returns a client connected to it.
+func newFakeServer(t *testing.T) (*fakeServer, *ts3.Client) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeServer{listener: listener, channels: []string{
+		`cid=1 pid=0 channel_order=0 channel_name=Lobby total_clients=0 channel_maxclients=-1`,
+		`cid=2 pid=0 channel_order=1 channel_name=AFK total_clients=0 channel_maxclients=-1`,
+	}}
+	go s.serve()
+	client, err := ts3.NewClient(listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		listener.Close()
+	})
+	return s, client
+}
+
+func (s *fakeServer) add(c *fakeClient) {
+	s.mu.Lock()
+	s.clients = append(s.clients, c)
+	s.mu.Unlock()
+}
+
+func (s *fakeServer) setIdle(id int, idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		if c.id == id {
+			c.idle = idle
+		}
+	}
+}
+
+func (s *fakeServer) movedClients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.moves...)
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprint(conn, "TS3\n\rWelcome to the fake TeamSpeak 3 ServerQuery interface.\n\r")
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		response, trailer := s.answer(line)
+		if response != "" {
+			fmt.Fprint(conn, response+"\n\r")
+		}
+		fmt.Fprint(conn, trailer+"\n\r")
+		if line == "quit" {
+			return
+		}
+	}
+}
+
+// answer returns the response to cmd and the error line ending it.
+func (s *fakeServer) answer(cmd string) (string, string) {
+	fields := strings.Fields(cmd)
+	args := make(map[string]int)
+	for _, field := range fields[1:] {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			args[key], _ = strconv.Atoi(value)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch fields[0] {
+	case "channellist":
+		return strings.Join(s.channels, "|"), "error id=0 msg=ok"
+	case "clientlist":
+		entries := make([]string, 0, len(s.clients))
+		for _, c := range s.clients {
+			entries = append(entries, fmt.Sprintf("clid=%d cid=%d client_database_id=%d client_nickname=%s client_type=0 client_unique_identifier=%s",
+				c.id, c.channel, c.id+100, c.nickname, c.uid))
+		}
+		return strings.Join(entries, "|"), "error id=0 msg=ok"
+	case "clientinfo":
+		for _, c := range s.clients {
+			if c.id == args["clid"] {
+				return fmt.Sprintf("client_idle_time=%d connection_connected_time=%d client_platform=Windows client_version=3.6.2",
+					c.idle.Milliseconds(), time.Hour.Milliseconds()), "error id=0 msg=ok"
+			}
+		}
+		return "", `error id=512 msg=invalid\sclientID`
+	case "clientmove":
+		for _, c := range s.clients {
+			if c.id == args["clid"] {
+				c.channel = args["cid"]
+				s.moves = append(s.moves, fmt.Sprintf("%d->%d", c.id, c.channel))
+				return "", "error id=0 msg=ok"
+			}
+		}
+		return "", `error id=512 msg=invalid\sclientID`
+	}
+	return "", "error id=0 msg=ok"
+}
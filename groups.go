@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"time"
+)
+
+// queryErrEmptyResult is returned for queries without results, e.g. the member list of an empty server group.
+const queryErrEmptyResult = 1281
+
+// groupMember is an entry of the servergroupclientlist response.
+type groupMember struct {
+	DatabaseID int `ms:"cldbid"`
+}
+
+// listGroupMembers returns the database IDs of the members of server group sgid.
+func listGroupMembers(client *ts3.Client, sgid int) (map[int]bool, error) {
+	var members []*groupMember
+	if err := execQuery(client, ts3.NewCmd("servergroupclientlist").WithArgs(ts3.NewArg("sgid", sgid)), &members); err != nil {
+		if _, tsErr := classifyQueryError(err); tsErr != nil && tsErr.ID == queryErrEmptyResult {
+			return map[int]bool{}, nil
+		}
+		return nil, err
+	}
+	ids := make(map[int]bool, len(members))
+	for _, member := range members {
+		ids[member.DatabaseID] = true
+	}
+	return ids, nil
+}
+
+// groupCache holds the members of the server groups used by rules, so that membership is resolved with one
+// query per group every TS3_GROUP_CACHE_SEC instead of one query per client and sweep.
+type groupCache struct {
+	members   map[int]map[int]bool
+	fetchedAt map[int]time.Time
+}
+
+var groupMembers = newGroupCache()
+
+func newGroupCache() *groupCache {
+	return &groupCache{members: make(map[int]map[int]bool), fetchedAt: make(map[int]time.Time)}
+}
+
+// inAny reports whether the client with database ID cldbid is a member of one of the groups.
+// Groups whose members cannot be fetched are skipped until the next attempt.
+func (g *groupCache) inAny(client *ts3.Client, config Config, cldbid int, groups []int) bool {
+	for _, sgid := range groups {
+		if clock.Now().Sub(g.fetchedAt[sgid]) >= config.GroupCacheTTL {
+			members, err := listGroupMembers(client, sgid)
+			if err != nil {
+				zap.S().Errorf("Failed to list members of server group %d: %v", sgid, err)
+				handleQueryError(client, config, "servergroupclientlist", err)
+				continue
+			}
+			g.members[sgid], g.fetchedAt[sgid] = members, clock.Now()
+		}
+		if g.members[sgid][cldbid] {
+			return true
+		}
+	}
+	return false
+}
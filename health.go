@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Health states of the bot. It starts connecting, is healthy once it swept, and moves between the
+// others as problems come and go.
+const (
+	healthConnecting   = "connecting"
+	healthHealthy      = "healthy"
+	healthNoAfkChannel = "degraded-no-afk-channel"
+	healthServerDown   = "degraded-server-down"
+	healthRateLimited  = "rate-limited"
+	healthBanned       = "banned"
+	healthReconnecting = "reconnecting"
+	healthAnomaly      = "degraded-anomaly"
+)
+
+// healthStates lists every state, for the per-state gauges.
+var healthStates = []string{healthConnecting, healthHealthy, healthNoAfkChannel, healthServerDown, healthRateLimited, healthBanned, healthReconnecting, healthAnomaly}
+
+// healthTracker is the current health state with the reason the bot is in it.
+type healthTracker struct {
+	mu     sync.Mutex
+	state  string
+	reason string
+	since  time.Time
+}
+
+// healthStatus is a snapshot of the health state, as /healthz reports it.
+type healthStatus struct {
+	State  string    `json:"state"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+var health = &healthTracker{state: healthConnecting, reason: "starting", since: time.Now()}
+
+// sweepProblem is the first problem the running sweep found, it decides the health state once the sweep is done.
+var sweepProblem healthStatus
+
+// set moves to state for the given reason. A change of state is logged, sets the health gauges and
+// the systemd status; a new reason in the same state only replaces the reported one.
+func (h *healthTracker) set(state string, reason string) {
+	h.mu.Lock()
+	previous := h.state
+	h.reason = reason
+	if state == previous {
+		h.mu.Unlock()
+		return
+	}
+	h.state, h.since = state, time.Now()
+	h.mu.Unlock()
+
+	msg := fmt.Sprintf("Health changed from %s to %s", previous, state)
+	if reason != "" {
+		msg += ": " + reason
+	}
+	if state == healthHealthy {
+		zap.S().Info(msg)
+	} else {
+		zap.S().Warn(msg)
+	}
+	for _, s := range healthStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		metrics.gauge("health", value, "state:"+s)
+	}
+	sdNotify("STATUS=" + state)
+}
+
+func (h *healthTracker) status() healthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return healthStatus{State: h.state, Reason: h.reason, Since: h.since}
+}
+
+// reportSweepProblem records a problem of the running sweep; the first one wins.
+func reportSweepProblem(state string, reason string) {
+	if sweepProblem.State == "" {
+		sweepProblem = healthStatus{State: state, Reason: reason}
+	}
+}
+
+// finishSweepHealth sets the health state from the problems of the sweep that just ended.
+func finishSweepHealth() {
+	problem := sweepProblem
+	sweepProblem = healthStatus{}
+	switch {
+	case clock.Now().Before(floodBackoffUntil):
+		health.set(healthRateLimited, "the server reported flooding, sweeps resume at "+floodBackoffUntil.Format(time.RFC3339))
+	case problem.State != "":
+		health.set(problem.State, problem.Reason)
+	default:
+		health.set(healthHealthy, "")
+	}
+}
+
+// Names of the optional subsystems besides the notifiers, which go by their own names.
+const (
+	subsystemStatsd  = "statsd"
+	subsystemStorage = "storage"
+)
+
+// subsystemRetryInterval is how often a failing optional subsystem is tried again.
+const subsystemRetryInterval = 30 * time.Second
+
+// subsystemStatus describes an optional subsystem that is failing, e.g. StatsD, a webhook or the storage backend.
+type subsystemStatus struct {
+	Name   string    `json:"name"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	// Buffered is the number of events or writes held back until the subsystem recovers,
+	// Dropped the number lost since it failed because the buffer was full.
+	Buffered int `json:"buffered,omitempty"`
+	Dropped  int `json:"dropped,omitempty"`
+}
+
+// subsystemTracker holds the optional subsystems that are failing. They retry in the background
+// without holding up sweeps, so they do not change the health state.
+type subsystemTracker struct {
+	mu       sync.Mutex
+	degraded map[string]*subsystemStatus
+}
+
+var subsystems = &subsystemTracker{degraded: make(map[string]*subsystemStatus)}
+
+// degrade records that the named subsystem failed with err. Only the first failure is logged.
+func (t *subsystemTracker) degrade(name string, err error) {
+	t.mu.Lock()
+	status, ok := t.degraded[name]
+	if !ok {
+		status = &subsystemStatus{Name: name, Since: time.Now()}
+		t.degraded[name] = status
+	}
+	status.Reason = err.Error()
+	t.mu.Unlock()
+	if !ok {
+		zap.S().Warnf("%s is degraded, retrying in the background: %v", name, err)
+	}
+}
+
+// recover records that the named subsystem works again.
+func (t *subsystemTracker) recover(name string) {
+	t.mu.Lock()
+	status, ok := t.degraded[name]
+	delete(t.degraded, name)
+	t.mu.Unlock()
+	if ok {
+		zap.S().Infof("%s recovered after %s", name, shortDuration(time.Since(status.Since).Round(time.Second)))
+	}
+}
+
+// buffer updates the number of buffered and dropped items of a failing subsystem.
+func (t *subsystemTracker) buffer(name string, buffered int, dropped int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if status, ok := t.degraded[name]; ok {
+		status.Buffered, status.Dropped = buffered, dropped
+	}
+}
+
+// list returns the failing subsystems, sorted by name.
+func (t *subsystemTracker) list() []subsystemStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]subsystemStatus, 0, len(t.degraded))
+	for _, status := range t.degraded {
+		list = append(list, *status)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
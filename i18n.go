@@ -0,0 +1,98 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// defaultLanguage is the language of messages a translation lacks.
+const defaultLanguage = "en"
+
+// languageAuto clears a client's language choice, so its country decides again.
+const languageAuto = "auto"
+
+//go:embed translations/*.json
+var translationFiles embed.FS
+
+// translations holds the bundled messages by language code and message key.
+var translations = loadTranslations()
+
+// countryLanguages maps the client_country of clients to the bundled language spoken there.
+// Countries with several languages map to the most common one.
+var countryLanguages = map[string]string{
+	"DE": "de", "AT": "de", "CH": "de", "LI": "de",
+	"FR": "fr", "BE": "fr", "LU": "fr", "MC": "fr",
+	"ES": "es", "MX": "es", "AR": "es", "CO": "es", "CL": "es", "PE": "es", "VE": "es", "UY": "es",
+}
+
+func loadTranslations() map[string]map[string]string {
+	files, err := translationFiles.ReadDir("translations")
+	if err != nil {
+		panic(err)
+	}
+	loaded := make(map[string]map[string]string, len(files))
+	for _, file := range files {
+		data, err := translationFiles.ReadFile(path.Join("translations", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err = json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("translations/%s: %v", file.Name(), err))
+		}
+		loaded[strings.TrimSuffix(file.Name(), ".json")] = messages
+	}
+	return loaded
+}
+
+// languages returns the codes of the bundled languages, sorted.
+func languages() []string {
+	codes := make([]string, 0, len(translations))
+	for code := range translations {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func isLanguage(code string) bool {
+	_, ok := translations[code]
+	return ok
+}
+
+// clientLanguage returns the language of messages to a client: its !language choice, with
+// TS3_LANGUAGE_BY_COUNTRY the language of the country its client reports, or TS3_LANGUAGE.
+func clientLanguage(config Config, override ClientOverride, country string) string {
+	if isLanguage(override.Language) {
+		return override.Language
+	}
+	if config.LanguageByCountry {
+		if language, ok := countryLanguages[strings.ToUpper(country)]; ok && isLanguage(language) {
+			return language
+		}
+	}
+	return config.Language
+}
+
+// translate formats the message key in language, falling back to English for messages it lacks.
+func translate(language string, key string, args ...interface{}) string {
+	format, ok := translations[language][key]
+	if !ok {
+		format = translations[defaultLanguage][key]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// setLanguage stores the language the client with the given UID wants messages in, or clears it with languageAuto.
+func setLanguage(uid string, language string) error {
+	override, _ := clientOverrides.get(uid)
+	override.Language = language
+	if language == languageAuto {
+		override.Language = ""
+	}
+	return clientOverrides.set(uid, override)
+}
@@ -0,0 +1,70 @@
+package main
+
+// minIdleRatio returns the percentage of the users in channel id that must be idle before any of them is moved.
+// TS3_CHANNEL_MIN_IDLE_RATIO_PERCENT takes precedence over TS3_MIN_IDLE_RATIO_PERCENT.
+func (s *sweep) minIdleRatio(id int) int {
+	if channel, ok := s.tree.byID[id]; ok {
+		if percent, ok := s.config.ChannelMinIdleRatio[channel.ChannelName]; ok {
+			return percent
+		}
+	}
+	return s.config.MinIdleRatio
+}
+
+// usesIdleRatios reports whether moves depend on how many users of a channel are idle.
+func usesIdleRatios(config Config) bool {
+	if config.MinIdleRatio > 0 {
+		return true
+	}
+	for _, percent := range config.ChannelMinIdleRatio {
+		if percent > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// applyIdleRatios holds back the planned moves out of channels in which no more than
+// the minimum idle ratio of the users are idle, so one idler in an active group is left alone.
+func (s *sweep) applyIdleRatios(statuses []clientStatus) {
+	users := make(map[int]bool, len(s.clients))
+	for _, c := range s.clients {
+		// ServerQuery clients such as the bot itself are not part of the conversation.
+		users[c.ID] = c.Type == 0
+	}
+	occupants := make(map[int]int)
+	idle := make(map[int]int)
+	for _, status := range statuses {
+		if !users[status.ID] {
+			continue
+		}
+		occupants[status.ChannelID]++
+		if status.Reason != "" {
+			idle[status.ChannelID]++
+		}
+	}
+	for i, status := range statuses {
+		if status.Status != statusWouldMove {
+			continue
+		}
+		percent := s.minIdleRatio(status.ChannelID)
+		if idle[status.ChannelID]*100 > percent*occupants[status.ChannelID] {
+			continue
+		}
+		statuses[i].Status = statusBusyChannel
+		if c := s.clientByID(status.ID); c != nil {
+			logDecision(c, "User %s is idle for %d seconds, but only %d of %d users in the channel are idle, more than %d%% have to be",
+				c.logName(), status.IdleTimeMs/1000, idle[status.ChannelID], occupants[status.ChannelID], percent)
+		}
+	}
+}
+
+// clientByID returns the client of the sweep with the given ID, or nil if there is none.
+func (s *sweep) clientByID(id int) *clientInfo {
+	for _, c := range s.clients {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
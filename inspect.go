@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// clientRow is a client as the clients command prints it; --output=json keeps these field names stable.
+type clientRow struct {
+	ID         int    `json:"id"`
+	DatabaseID int    `json:"database_id"`
+	UID        string `json:"uid"`
+	Nickname   string `json:"nickname"`
+	ChannelID  int    `json:"channel_id"`
+	Channel    string `json:"channel"`
+	// IdleSec is -1 if the client left before its details were read.
+	IdleSec     int    `json:"idle_sec"`
+	Away        bool   `json:"away"`
+	InputMuted  bool   `json:"input_muted"`
+	OutputMuted bool   `json:"output_muted"`
+	Platform    string `json:"platform"`
+	Country     string `json:"country"`
+	Query       bool   `json:"query"`
+	// MyTeamSpeakID and Badges are empty for clients without a myTeamSpeak account.
+	MyTeamSpeakID string   `json:"myteamspeak_id,omitempty"`
+	Badges        []string `json:"badges,omitempty"`
+}
+
+// channelRow is a channel as the channels command prints it; --output=json keeps these field names stable.
+type channelRow struct {
+	ID       int    `json:"id"`
+	ParentID int    `json:"parent_id"`
+	Name     string `json:"name"`
+	// Depth is 0 for channels at the top level.
+	Depth   int `json:"depth"`
+	Clients int `json:"clients"`
+	// MaxClients is -1 if the channel is not limited.
+	MaxClients int  `json:"max_clients"`
+	Spacer     bool `json:"spacer"`
+}
+
+// listClientsCommand prints the online clients of the configured virtual server with their idle times.
+func listClientsCommand() error {
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	client, err := connectReadOnly(config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	channels, err := listChannels(client)
+	if err != nil {
+		return fmt.Errorf("failed to list channels: %w", err)
+	}
+	clients, err := listClients(client)
+	if err != nil {
+		return fmt.Errorf("failed to list clients: %w", err)
+	}
+	tree := newChannelTree(channels)
+
+	rows := make([]clientRow, 0, len(clients))
+	for _, c := range clients {
+		row := clientRow{ID: c.ID, DatabaseID: c.DatabaseID, UID: c.UniqueIdentifier, Nickname: c.Nickname, ChannelID: c.ChannelID,
+			IdleSec: -1, Away: c.Away, Country: c.Country, Query: c.Type == 1}
+		if channel, ok := tree.byID[c.ChannelID]; ok {
+			row.Channel = channel.ChannelName
+		}
+		if details, err := getClientDetails(client, c.ID); err == nil {
+			row.IdleSec = details.IdleTimeMs / 1000
+			row.InputMuted, row.OutputMuted, row.Platform = details.InputMuted, details.OutputMuted, details.Platform
+			row.MyTeamSpeakID, row.Badges = details.MyTeamSpeakID, clientBadges(details.Badges)
+		}
+		rows = append(rows, row)
+	}
+
+	if *outputFlag == outputJSON {
+		printJSON(struct {
+			Clients []clientRow `json:"clients"`
+		}{rows})
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNICKNAME\tCHANNEL\tIDLE\tAWAY\tPLATFORM")
+	for _, row := range rows {
+		idle := "?"
+		if row.IdleSec >= 0 {
+			idle = shortDuration(time.Duration(row.IdleSec) * time.Second)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\t%s\n", row.ID, row.Nickname, row.Channel, idle, row.Away, row.Platform)
+	}
+	return w.Flush()
+}
+
+// listChannelsCommand prints the channel tree of the configured virtual server.
+func listChannelsCommand() error {
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	client, err := connectReadOnly(config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	channels, err := listChannels(client)
+	if err != nil {
+		return fmt.Errorf("failed to list channels: %w", err)
+	}
+	tree := newChannelTree(channels)
+
+	// The server lists channels in the order clients show them, parents before their children.
+	rows := make([]channelRow, 0, len(channels))
+	for _, channel := range channels {
+		rows = append(rows, channelRow{ID: channel.ID, ParentID: channel.ParentID, Name: channel.ChannelName,
+			Depth: len(tree.path(channel.ID)) - 1, Clients: channel.TotalClients, MaxClients: channel.MaxClients, Spacer: channel.isSpacer()})
+	}
+
+	if *outputFlag == outputJSON {
+		printJSON(struct {
+			Channels []channelRow `json:"channels"`
+		}{rows})
+		return nil
+	}
+	for _, row := range rows {
+		fmt.Printf("%s%s [%d] %d clients\n", strings.Repeat("  ", row.Depth), row.Name, row.ID, row.Clients)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"sync/atomic"
+)
+
+// largeSweepPending is set while a sweep that would move more than TS3_LARGE_SWEEP_LIMIT clients waits for confirmation.
+var largeSweepPending atomic.Bool
+
+// largeSweepWarned is set once admins were told about held back moves, so they are not told again every sweep.
+var largeSweepWarned bool
+
+// largeSweepApprovals carries admin confirmations of a held back sweep to the main loop.
+var largeSweepApprovals = make(chan string, 1)
+
+// largeSweepApproved lets the next sweep move any number of clients.
+var largeSweepApproved bool
+
+// approveLargeSweep confirms a held back sweep and reports whether one was waiting.
+func approveLargeSweep(source string) bool {
+	if !largeSweepPending.Load() {
+		return false
+	}
+	select {
+	case largeSweepApprovals <- source:
+	default:
+	}
+	return true
+}
+
+// finishSweep moves the clients a dry-run sweep found, unless there are more than TS3_LARGE_SWEEP_LIMIT
+// and no admin confirmed it. That is usually a sign of misconfiguration, e.g. a far too low idle limit.
+func (s *sweep) finishSweep(statuses []clientStatus) {
+	s.applyIdleRatios(statuses)
+	var candidates []int
+	for i, status := range statuses {
+		if status.Status == statusWouldMove {
+			candidates = append(candidates, i)
+		}
+	}
+
+	if s.config.LargeSweepLimit > 0 && len(candidates) > s.config.LargeSweepLimit && !largeSweepApproved {
+		for i := range statuses {
+			if statuses[i].Status == statusWouldMove || statuses[i].Status == statusWouldReturn {
+				statuses[i].Status = statusHeld
+			}
+		}
+		largeSweepPending.Store(s.config.LargeSweepAction == "confirm")
+		if largeSweepWarned {
+			return
+		}
+		largeSweepWarned = true
+		msg := fmt.Sprintf("This sweep would move %d users, more than the limit of %d, so nobody was moved.", len(candidates), s.config.LargeSweepLimit)
+		if s.config.LargeSweepAction == "confirm" {
+			msg += " Send !confirm to move them."
+		} else {
+			msg += " Check the configuration, the moves are held back until fewer users are idle."
+		}
+		zap.S().Warn(msg)
+		events.publish(botEvent{Type: "sweep", Message: msg})
+		notifyAdmins(s.client, s.config, "AFK bot: "+msg)
+		return
+	}
+	largeSweepPending.Store(false)
+	largeSweepWarned = false
+	largeSweepApproved = false
+
+	s.dryRun = false
+	clients := make(map[int]*clientInfo, len(s.clients))
+	for _, c := range s.clients {
+		clients[c.ID] = c
+	}
+	s.finishReturns(statuses, clients)
+	if s.aborted {
+		return
+	}
+	if s.config.BatchMoves {
+		s.moveBatches(statuses, candidates, clients)
+		return
+	}
+	for _, i := range candidates {
+		c, ok := clients[statuses[i].ID]
+		if !ok {
+			continue
+		}
+		if s.config.MaxMovesPerSweep > 0 && s.moves >= s.config.MaxMovesPerSweep {
+			statuses[i].Status = statusDeferred
+			continue
+		}
+		statuses[i] = s.move(c, statuses[i], statuses[i].targetChannelID)
+		if s.aborted {
+			break
+		}
+	}
+}
+
+// handleLargeSweepApproval runs the sweep an admin confirmed right away.
+func handleLargeSweepApproval(client *ts3.Client, config Config, source string) {
+	zap.S().Infof("Large sweep confirmed by %s", source)
+	largeSweepApproved = true
+	sweepServers(client, config)
+}
+
+// finishReturns moves back the clients a dry run found active again.
+func (s *sweep) finishReturns(statuses []clientStatus, clients map[int]*clientInfo) {
+	for i := range statuses {
+		c, ok := clients[statuses[i].ID]
+		if statuses[i].Status != statusWouldReturn || !ok {
+			continue
+		}
+		if s.pausedMidSweep() {
+			statuses[i].Status = statusPaused
+			continue
+		}
+		if s.config.MaxMovesPerSweep > 0 && s.moves >= s.config.MaxMovesPerSweep {
+			statuses[i].Status = statusDeferred
+			continue
+		}
+		s.moveBack(c, &statuses[i], statuses[i].targetChannelID)
+		if s.aborted {
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+)
+
+// listener is the ServerQuery connection dedicated to notifications with TS3_NOTIFICATION_CONNECTION,
+// so they are never held up behind a slow command. It is nil if notifications arrive on the command connection.
+var listener *ts3.Client
+
+// openListener connects the listener to the address and virtual server of the command connection client.
+// If that fails, notifications are registered on client instead, so none are lost.
+func openListener(client *ts3.Client, config Config) {
+	address := connectedAddress
+	conn, err := ts3.NewClient(address, ts3.NotificationBuffer(notificationBufferSize))
+	if err == nil {
+		account := currentAccount(config)
+		if err = conn.Login(account.user, account.password.value()); err == nil {
+			if err = conn.Use(serverID); err == nil {
+				err = registerNotifications(conn)
+			}
+		}
+		if err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		zap.S().Errorf("Failed to open notification connection to %s, using the command connection: %v", address, err)
+		metrics.count("errors", 1, "op:connect")
+		if err = registerNotifications(client); err != nil {
+			zap.S().Errorf("Failed to register for notifications: %v", err)
+		}
+		return
+	}
+	listener = conn
+	zap.S().Infof("Opened notification connection to %s", address)
+}
+
+// closeListener closes the listener, if there is one.
+func closeListener() {
+	if listener == nil {
+		return
+	}
+	if err := listener.Close(); err != nil {
+		zap.S().Debugf("Failed to close notification connection: %v", err)
+	}
+	listener = nil
+}
+
+// notifications returns the channel notifications arrive on.
+func notifications(client *ts3.Client) <-chan ts3.Notification {
+	if listener != nil {
+		return listener.Notifications()
+	}
+	return client.Notifications()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// logDedupeWindow is how long an unchanged log line about a client is suppressed after it was logged, 0 logs every line.
+var logDedupeWindow time.Duration
+
+// repeatedLog tracks when a log line was last logged and how often it was suppressed since.
+type repeatedLog struct {
+	loggedAt   time.Time
+	suppressed int
+}
+
+// repeatedLogs holds the log lines per client ID, keyed by format string, so that changing numbers
+// such as the idle time do not count as a change.
+var repeatedLogs = make(map[int]map[string]*repeatedLog)
+
+// logClientDeduped logs a line about c unless the same line was logged within logDedupeWindow.
+// The next line that is logged says how many repeats were suppressed.
+func logClientDeduped(c *clientInfo, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if logSummary {
+		// The sweep summary counts the decision.
+		zap.S().Debug(message)
+		return
+	}
+	if logDedupeWindow <= 0 {
+		zap.S().Info(message)
+		return
+	}
+	logs, ok := repeatedLogs[c.ID]
+	if !ok {
+		logs = make(map[string]*repeatedLog)
+		repeatedLogs[c.ID] = logs
+	}
+	now := clock.Now()
+	if entry, ok := logs[format]; ok {
+		if now.Sub(entry.loggedAt) < logDedupeWindow {
+			entry.suppressed++
+			return
+		}
+		if entry.suppressed > 0 {
+			message = fmt.Sprintf("%s (%d repeats suppressed)", message, entry.suppressed)
+		}
+	}
+	logs[format] = &repeatedLog{loggedAt: now}
+	zap.S().Info(message)
+}
+
+// logSummary replaces the log lines about single decisions with one summary per sweep, see TS3_LOG_SUMMARY.
+var logSummary bool
+
+// logSweepSummary logs how many clients a sweep looked at and what it decided, counted by status.
+func logSweepSummary(statuses []clientStatus) {
+	counts := make(map[string]int)
+	for _, status := range statuses {
+		counts[status.Status]++
+	}
+	zap.S().Infow("Sweep summary",
+		"server", serverID,
+		"scanned", len(statuses),
+		"moved", counts[statusMoved],
+		"statuses", counts,
+	)
+}
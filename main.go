@@ -1,292 +1,355 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/multiplay/go-ts3"
 	"go.uber.org/zap"
 	"os"
-	"regexp"
-	"strconv"
 	"time"
 )
 
-var idleTimeRegex = regexp.MustCompile(`client_idle_time=(\d+)`)
-var recentJoins = make(map[int]time.Time)
-
-type Config struct {
-	UserName         string
-	Password         string
-	ServerId         int
-	Url              string
-	AfkChannelName   string
-	MaxIdleTimeMs    int
-	IgnoredChannels  []string
-	AllowGracePeriod bool
-}
+// sweepInterval is the time between two sweeps over all clients.
+const sweepInterval = 10 * time.Second
 
-func loadConfigFromEnv() (Config, error) {
-	config := Config{}
-	var err error
+// notificationBufferSize is large enough to not drop events that arrive while a sweep is running.
+const notificationBufferSize = 256
 
-	config.UserName, err = getRequiredEnv("TS3_USER")
-	if err != nil {
-		return config, err
-	}
+var (
+	tuiFlag     = flag.Bool("tui", false, "show a live terminal view of channels, clients and recent actions")
+	logFileFlag = flag.String("log-file", "", "write logs to this file instead of stderr")
+	versionFlag = flag.Bool("version", false, "print the version and exit")
+	traceFlag   = flag.Bool("trace", false, "log every raw ServerQuery command and response line")
+	recordFlag  = flag.String("record", "", "append all ServerQuery commands, responses and notifications to this file")
+)
 
-	config.Password, err = getRequiredEnv("TS3_PASSWORD")
-	if err != nil {
-		return config, err
+// setupLogging replaces the global logger with one built from the given profile.
+// "production" logs JSON at info level with sampling, "development" logs human-readable lines at debug level.
+func setupLogging(profile string, fields map[string]string) error {
+	var config zap.Config
+	options := []zap.Option{zap.AddCaller()}
+	switch profile {
+	case "production":
+		config = zap.NewProductionConfig()
+	default:
+		config = zap.NewDevelopmentConfig()
+		options = append(options, zap.Development())
 	}
 
-	config.Url, err = getRequiredEnv("TS3_URL")
-	if err != nil {
-		return config, err
+	if *logFileFlag != "" {
+		config.OutputPaths = []string{*logFileFlag}
+		config.ErrorOutputPaths = []string{*logFileFlag}
+	} else if *tuiFlag {
+		// Log lines would tear up the terminal view, which shows errors and moves itself.
+		config.OutputPaths = []string{os.DevNull}
 	}
-
-	serverIdStr, err := getRequiredEnv("TS3_SERVER_ID")
-	if err != nil {
-		return config, err
+	if *tuiFlag {
+		options = append(options, zap.WithFatalHook(tuiFatalHook{}))
 	}
-
-	config.ServerId, err = strconv.Atoi(serverIdStr)
-	if err != nil {
-		return config, fmt.Errorf("TS3_SERVER_ID is not a number: %v", err)
+	for key, value := range fields {
+		options = append(options, zap.Fields(zap.String(key, value)))
 	}
 
-	config.AfkChannelName, err = getRequiredEnv("TS3_AFK_CHANNEL_NAME")
+	logger, err := config.Build(options...)
 	if err != nil {
-		return config, err
+		return err
 	}
+	zap.ReplaceGlobals(logger)
+	return nil
+}
 
-	maxIdleTimeStr, err := getRequiredEnv("TS3_MAX_IDLE_TIME_SEC")
-	if err != nil {
-		return config, err
-	}
+// applyConfigGlobals hands the settings kept in package variables to their users, on startup and after a reload.
+func applyConfigGlobals(config Config) {
+	logDedupeWindow = config.LogDedupe
+	logSummary = config.LogSummary
+	observeOnly = config.ObserveOnly
+	exemptDatabaseIDs.mu.Lock()
+	exemptDatabaseIDs.configured = config.ExemptDatabaseIDs
+	exemptDatabaseIDs.mu.Unlock()
+}
 
-	config.MaxIdleTimeMs, err = strconv.Atoi(maxIdleTimeStr)
-	if err != nil {
-		return config, fmt.Errorf("TS3_MAX_IDLE_TIME_SEC is not a number: %v", err)
+func main() {
+	flag.Parse()
+
+	if *versionFlag {
+		fmt.Println("ts3-afk-mover", currentBuild())
+		return
 	}
-	config.MaxIdleTimeMs *= 1000
 
-	ignoredChannelsRaw, err := getRequiredEnv("TS3_IGNORED_CHANNELS")
-	if err != nil {
-		return config, err
+	if *outputFlag != outputText && *outputFlag != outputJSON {
+		fmt.Fprintf(os.Stderr, "unknown output format %q, use text or json\n", *outputFlag)
+		os.Exit(exitUsage)
 	}
 
-	err = json.Unmarshal([]byte(ignoredChannelsRaw), &config.IgnoredChannels)
-	if err != nil {
-		return config, fmt.Errorf("TS3_IGNORED_CHANNELS is not a valid json array: %v", err)
+	switch flag.Arg(0) {
+	case "":
+	case "dump-state":
+		if err := dumpState(); err != nil {
+			fmt.Fprintln(os.Stderr, "dump-state:", err)
+			os.Exit(1)
+		}
+		return
+	case "export":
+		if err := exportStorage(flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, "export:", err)
+			os.Exit(1)
+		}
+		return
+	case "import":
+		if err := importStorage(flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, "import:", err)
+			os.Exit(1)
+		}
+		return
+	case "capture":
+		if err := captureServer(flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, "capture:", err)
+			os.Exit(1)
+		}
+		return
+	case "clients":
+		if err := listClientsCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, "clients:", err)
+			os.Exit(1)
+		}
+		return
+	case "channels":
+		if err := listChannelsCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, "channels:", err)
+			os.Exit(1)
+		}
+		return
+	case "validate":
+		os.Exit(validateCommand())
+	case "replay":
+		if err := replayTraffic(flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, "replay:", err)
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		os.Exit(exitUsage)
 	}
 
-	allowGracePeriod, err := getRequiredEnv("TS3_ALLOW_GRACE_PERIOD")
+	// Start with development logging so configuration errors are readable.
+	err := setupLogging("development", nil)
 	if err != nil {
-		return config, err
+		exitWith(exitFailure, err)
 	}
 
-	config.AllowGracePeriod, err = strconv.ParseBool(allowGracePeriod)
+	config, err := loadConfigFromEnv()
 	if err != nil {
-		return config, fmt.Errorf("TS3_ALLOW_GRACE_PERIOD is not a boolean: %v", err)
+		exitWith(exitConfig, err)
 	}
 
-	return config, nil
-}
-
-func getRequiredEnv(key string) (string, error) {
-	value, found := os.LookupEnv(key)
-	if !found {
-		return "", fmt.Errorf("%s not set", key)
+	if err = setupLogging(config.LogProfile, config.LogFields); err != nil {
+		exitWith(exitConfig, err)
 	}
-	return value, nil
-}
+	zap.S().Infof("Starting ts3-afk-mover %s", currentBuild())
 
-func setupLogging() error {
-	logger, err := zap.NewDevelopment(zap.Development())
-	if err != nil {
-		return err
+	queryTrace.Store(*traceFlag)
+	queryPace.setInterval(config.QueryInterval)
+	if config.ChaosPercent > 0 {
+		chaos = newChaosMonkey(config.ChaosPercent, config.ChaosMaxDelay)
+		zap.S().Warnf("Chaos mode: %d%% of ServerQuery commands fail or are delayed, do not use this in production", config.ChaosPercent)
 	}
-	zap.ReplaceGlobals(logger)
-	return nil
-}
-
-func handleError(err error) {
-	zap.S().Error(err)
-	time.Sleep(1 * time.Minute)
-	panic(err)
-}
-
-func main() {
-	err := setupLogging()
-	if err != nil {
-		handleError(err)
+	if *recordFlag != "" {
+		if traffic, err = newTrafficRecorder(*recordFlag); err != nil {
+			exitWith(exitFailure, err)
+		}
+		zap.S().Warnf("Recording ServerQuery traffic to %s, it contains nicknames and messages of all clients", *recordFlag)
 	}
 
-	zap.S().Info("Starting ts3-afk-mover")
-	config, err := loadConfigFromEnv()
+	storage, err = openStorage(config.Storage, config.StorageDSN.value())
 	if err != nil {
-		handleError(err)
+		exitWith(exitFailure, err)
+	}
+	if config.Storage != "memory" {
+		buffered := newBufferedStorage(storage, config.StorageBuffer)
+		storage = buffered
+		if !*onceFlag {
+			go buffered.run()
+		}
 	}
+	defer storage.Close()
 
-	client, err := ts3.NewClient(config.Url)
+	clientOverrides, err = loadOverrides(config.OverridesFile)
 	if err != nil {
-		handleError(err)
+		exitWith(exitConfig, err)
 	}
-	defer client.Close()
 
-	if err = client.Login(config.UserName, config.Password); err != nil {
-		zap.S().Fatal(err)
+	counters.start(time.Now())
+	if !*onceFlag {
+		// Every --once run is a start, idle times measured from it would never reach the limit.
+		startedAt = clock.Now()
 	}
-
-	err = client.Use(config.ServerId)
-	if err != nil {
-		zap.S().Fatal(err)
+	if !*onceFlag {
+		go runCounterFlush(config.CounterFlush)
 	}
 
-	err = client.SetNick(config.UserName)
-	if err != nil {
-		zap.S().Warn(err)
+	if containsString(sharedStorages, config.Storage) && config.StorageSync > 0 {
+		go runSharedStateSync(config.StorageSync)
 	}
 
-	whoami, err := client.Whoami()
+	runtimeSettings, err = loadSettings()
 	if err != nil {
-		zap.S().Fatal(err)
+		exitWith(exitFailure, err)
 	}
-
-	zap.S().Info("%v", whoami)
-
-	for {
-		processClients(client, config)
-		time.Sleep(10 * time.Second)
+	setRunningConfig(config)
+	if problems := validateConfig(runtimeSettings.apply(withCurrentProfile(config))); len(problems) > 0 {
+		zap.S().Warnf("Stored settings make the configuration invalid, check them with !settings: %v", errors.Join(problems...))
 	}
-}
 
-func isChannelIgnored(channels []int, id int) bool {
-	for _, channel := range channels {
-		if channel == id {
-			return true
+	if config.StatsdAddr != "" {
+		tags := append([]string{"version:" + currentBuild().Version}, config.StatsdTags...)
+		statsd, err := newStatsdMetrics(config.StatsdAddr, config.StatsdPrefix, tags, config.DogStatsD)
+		if err != nil {
+			exitWith(exitConfig, err)
 		}
+		metrics = statsd
 	}
-	return false
-}
+	stats.next = metrics
+	metrics = stats
 
-func processClients(client *ts3.Client, config Config) {
-	// Get the list of channels.
-	channels, err := client.Server.ChannelList()
-	if err != nil {
-		zap.S().Errorf("Error getting channel list: %v", err)
-		time.Sleep(5 * time.Second)
-		return
+	events.configure(config.EventBuffer, config.EventOverflow)
+	if err = setupEventSinks(config); err != nil {
+		exitWith(exitConfig, err)
 	}
 
-	var afkChannelId int
-	var allowedIdleChannels []int
-
-	for _, channel := range channels {
-		if channel.ChannelName == config.AfkChannelName {
-			afkChannelId = channel.ID
-		}
-
-		for _, ignoredChannel := range config.IgnoredChannels {
-			if channel.ChannelName == ignoredChannel {
-				allowedIdleChannels = append(allowedIdleChannels, channel.ID)
-				//zap.S().Infof("Ignoring channel %s [%d]", channel.ChannelName, channel.ID)
+	if config.CalendarURL != "" {
+		calendar = newCalendarCache(config.CalendarURL.value(), config.Location)
+		if *onceFlag {
+			// The single sweep needs the calendar before it starts.
+			if err = calendar.refresh(); err != nil {
+				zap.S().Errorf("Failed to fetch calendar: %v", redactURLError(err))
 			}
+		} else {
+			go calendar.run(config.CalendarRefresh)
 		}
 	}
 
-	if afkChannelId == 0 {
-		zap.S().Fatal("afk channel not found")
+	if config.PermissionDigestAt >= 0 && !*onceFlag {
+		go runPermissionDigest(config.PermissionDigestAt, config.Location)
 	}
 
-	// Get the list of clients.
-	clients, err := client.Server.ClientList()
-	if err != nil {
-		zap.S().Errorf("Error getting c list: %v", err)
-		time.Sleep(5 * time.Second)
-		return
+	if config.UpdateCheck && !*onceFlag {
+		go runUpdateCheck(config.UpdateWebhook.value())
 	}
 
-	for _, c := range clients {
-		// If the client is in a channel that had a recent join, ignore their idle time for 10 seconds.
-		if joinTime, ok := recentJoins[c.ChannelID]; ok {
-			if time.Since(joinTime) <= 10*time.Second {
-				zap.S().Infof("User %s's idle time ignored for 10 seconds due to recent join", c.Nickname)
-				continue
-			}
-		}
+	pause.defaultDuration = config.PauseDefault
+	privacy = privacyFilter{mode: config.PrivacyMode, salt: []byte(config.PrivacySalt.value())}
+	applyConfigGlobals(config)
+	if config.ObserveOnly {
+		zap.S().Warn("Observer mode: the bot moves, pokes and messages nobody, it only collects statistics")
+	}
 
-		exec, err := client.Server.Exec(fmt.Sprintf("clientinfo clid=%d", c.ID))
-		if err != nil {
-			zap.S().Error(err)
-			continue
-		}
+	// A --once run next to a running bot must not take its HTTP address.
+	if config.HTTPAddr != "" && !*onceFlag {
+		startAPIServer(config.HTTPAddr, config.AdminToken.value())
+	}
 
-		// Extract client_idle_time=<number> from exec
-		matches := idleTimeRegex.FindStringSubmatch(exec[0])
-		if len(matches) != 2 {
-			zap.S().Error("client_idle_time not found")
-			continue
+	client, err := connect(config)
+	if err != nil {
+		// A --once run is better off failing than waiting out a ban until the next one starts.
+		if _, banned := queryBan(err); !banned || *onceFlag {
+			exitWith(classifyExit(err), err)
 		}
+		client = reconnect(config)
+	}
+	if *onceFlag {
+		code := runOnce(client, runtimeSettings.apply(profiles.apply(config, clock.Now())))
+		closeListener()
+		client.Close()
+		flushCounters()
+		storage.Close()
+		_ = zap.L().Sync()
+		os.Exit(code)
+	}
+	defer func() {
+		sdNotify("STOPPING=1")
+		closeListener()
+		client.Close()
+		flushCounters()
+	}()
+
+	watchPauseSignal()
+	watchStatsSignal()
+
+	if *tuiFlag {
+		go runTUI()
+	}
 
-		for _, c := range clients {
-			// If the client is in a channel that had a recent join, ignore their idle time for 10 seconds.
-			if joinTime, ok := recentJoins[c.ChannelID]; ok {
-				if time.Since(joinTime) <= 10*time.Second {
-					zap.S().Infof("User %s's idle time ignored for 10 seconds due to recent join", c.Nickname)
-					continue
-				}
-			}
-
-			exec, err := client.Server.Exec(fmt.Sprintf("clientinfo clid=%d", c.ID))
-			if err != nil {
-				zap.S().Error(err)
-				continue
-			}
-
-			// Extract client_idle_time=<number> from exec
-			matches := idleTimeRegex.FindStringSubmatch(exec[0])
-			if len(matches) != 2 {
-				zap.S().Error("client_idle_time not found")
-				continue
-			}
+	// Commands like !pause are read on their own goroutine, so they work even during the first sweep.
+	routed, stopRouting := routeNotifications(client)
+	announce(client, config)
+	sweepServers(client, runtimeSettings.apply(profiles.apply(config, clock.Now())))
+	sdNotify("READY=1")
+	watchdogPing()
+	timer := time.NewTimer(withinWatchdog(nextSweepInterval(config, latestSweep())))
+	defer timer.Stop()
 
-			idleTime, err := strconv.Atoi(matches[1])
-			if err != nil {
-				zap.S().Error(err)
+	for {
+		// Settings changed at runtime take precedence over the policy profile, which takes precedence over the environment.
+		current := runtimeSettings.apply(profiles.apply(config, clock.Now()))
+		select {
+		case n, ok := <-routed:
+			if !ok {
+				// The connection was lost, fail over to whichever address works now.
+				zap.S().Error("Connection to the server lost, reconnecting")
+				health.set(healthReconnecting, "the connection to the server was lost")
+				stopRouting()
+				client = reopen(client, config)
+				routed, stopRouting = routeNotifications(client)
 				continue
 			}
-
-			if idleTime > config.MaxIdleTimeMs {
-				if isChannelIgnored(allowedIdleChannels, c.ChannelID) {
-					zap.S().Infof("User %s is idle for %d seconds, but in allowed channel", c.Nickname, idleTime/1000)
-					continue
-				}
-				if c.ChannelID == afkChannelId {
-					zap.S().Infof("User %s is idle for %d seconds, but already in afk channel", c.Nickname, idleTime/1000)
-					continue
-				}
-
-				// Check if a user is solo in a channel
-				isSolo := true
-				for _, c2 := range clients {
-					if c2.ChannelID == c.ChannelID && c2.ID != c.ID {
-						isSolo = false
-						break
-					}
-				}
-				if isSolo {
-					zap.S().Infof("User %s is idle for %d seconds, but solo in channel", c.Nickname, idleTime/1000)
+			handleNotification(client, current, n)
+		case <-statsRequests:
+			logRuntimeStats()
+		case <-pause.changed:
+			announcePause(client, current)
+		case source := <-largeSweepApprovals:
+			handleLargeSweepApproval(client, current, source)
+			timer.Reset(withinWatchdog(nextSweepInterval(current, latestSweep())))
+		case req := <-massSweepRequests:
+			req.result <- massSweepServers(client, current, req)
+		case req := <-configReloads:
+			changes := diffConfig(config, req.config)
+			config = reloadConfig(config, req.config)
+			setRunningConfig(config)
+			applyConfigGlobals(config)
+			for _, change := range changes {
+				if change.RestartRequired {
+					zap.S().Warnf("Config reload: %s changed to %s, which only takes effect after a restart", change.Setting, change.New)
 					continue
 				}
-
-				zap.S().Infof("User %s is idle for %d seconds", c.Nickname, idleTime/1000)
-				zap.S().Info("moving c to afk channel")
-				_, err = client.Server.Exec(fmt.Sprintf("clientmove clid=%d cid=%d", c.ID, afkChannelId))
-				if err != nil {
-					zap.S().Error(err)
-				}
+				zap.S().Infof("Config reload: %s changed from %s to %s", change.Setting, change.Old, change.New)
+			}
+			req.result <- changes
+		case tag := <-updateNotices:
+			notifyAdminsOfUpdate(client, current, tag)
+		case <-permissionDigests:
+			sendPermissionDigest(client, current)
+		case <-timer.C:
+			if accountSwitchPending {
+				accountSwitchPending = false
+				zap.S().Warn("Connecting again with the next query account")
+				health.set(healthReconnecting, "switching to query account "+currentAccount(config).user)
+				stopRouting()
+				client = reopen(client, config)
+				routed, stopRouting = routeNotifications(client)
+			}
+			if !client.IsConnected() || (listener != nil && !listener.IsConnected()) {
+				// A lost connection does not close its notification channel.
+				zap.S().Error("Connection to the server lost, reconnecting")
+				health.set(healthReconnecting, "the connection to the server was lost")
+				stopRouting()
+				client = reopen(client, config)
+				routed, stopRouting = routeNotifications(client)
 			}
+			sweepServers(client, current)
+			watchdogPing()
+			timer.Reset(withinWatchdog(nextSweepInterval(current, latestSweep())))
 		}
 	}
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// reasonMoved is the reasonid of a clientmoved notification for a client moved by somebody else.
+const reasonMoved = "1"
+
+// manualMoves holds, per client ID, when a moderator last moved the client.
+var manualMoves = make(map[int]time.Time)
+
+// trackManualMove remembers clients moved by somebody other than the bot, so that the bot does not
+// move them again right away, e.g. back out of a channel a moderator put them in.
+// The hold is also stored, so other instances sharing the storage respect it.
+func trackManualMove(clientId int, n map[string]string, hold time.Duration) {
+	if n["reasonid"] != reasonMoved {
+		return
+	}
+	if invokerId, err := strconv.Atoi(n["invokerid"]); err != nil || invokerId == botClientID {
+		return
+	}
+	now := clock.Now()
+	manualMoves[clientId] = now
+	c, ok := previousClients[clientId]
+	if !ok || hold <= 0 {
+		return
+	}
+	if err := storage.SetCooldown(cooldownManualMove, privacy.uid(c.UniqueIdentifier), now.Add(hold)); err != nil {
+		zap.S().Errorf("Failed to store the manual move hold of %s: %v", c.logName(), err)
+	}
+}
+
+// manuallyMoved reports whether a moderator moved the client within hold, as seen by this
+// or another instance sharing the storage.
+func manuallyMoved(c *clientInfo, hold time.Duration, now time.Time) bool {
+	movedAt, ok := manualMoves[c.ID]
+	if !ok {
+		return sharedHold(c.UniqueIdentifier, now)
+	}
+	if now.Sub(movedAt) >= hold {
+		delete(manualMoves, c.ID)
+		return false
+	}
+	return true
+}
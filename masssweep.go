@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// massSweepConfirmTimeout is how long a mass move waiting for confirmation stays valid.
+const massSweepConfirmTimeout = time.Minute
+
+// massSweepRequest asks the main loop to move every client idle longer than thresholdMs right away.
+// A thresholdMs of 0 stands for TS3_MAX_IDLE_TIME_SEC.
+type massSweepRequest struct {
+	thresholdMs int
+	confirmed   bool
+	source      string
+	result      chan massSweepResult
+}
+
+// massSweepResult reports the outcome of a mass move.
+type massSweepResult struct {
+	ThresholdSec int `json:"threshold_sec"`
+	// Candidates is the number of clients the mass move affects.
+	Candidates int `json:"candidates"`
+	Moved      int `json:"moved"`
+	// NeedsConfirmation is set when nobody was moved because more than TS3_SWEEP_CONFIRM_LIMIT clients are affected.
+	NeedsConfirmation bool   `json:"needs_confirmation"`
+	Error             string `json:"error,omitempty"`
+}
+
+// massSweepRequests carries mass moves requested through the API to the main loop, which owns the connection.
+var massSweepRequests = make(chan massSweepRequest)
+
+// pendingMassSweep is a mass move requested in chat that waits for !sweep confirm.
+type pendingMassSweep struct {
+	thresholdMs int
+	expires     time.Time
+}
+
+// pendingMassSweeps holds the unconfirmed mass moves per admin UID.
+var pendingMassSweeps = make(map[string]pendingMassSweep)
+
+// runMassSweep moves all clients idle longer than thresholdMs, respecting exemptions.
+// Unless confirmed it first counts the affected clients and stops if there are more than TS3_SWEEP_CONFIRM_LIMIT.
+func runMassSweep(client *ts3.Client, config Config, req massSweepRequest) massSweepResult {
+	if req.thresholdMs == 0 {
+		req.thresholdMs = config.MaxIdleTimeMs
+	}
+	result := massSweepResult{ThresholdSec: req.thresholdMs / 1000}
+	s, _, ok := newSweep(client, config, clock.Now())
+	if !ok {
+		result.Error = "failed to fetch channels or clients"
+		return result
+	}
+	s.forcedThresholdMs = req.thresholdMs
+
+	s.dryRun = true
+	var candidates []*clientInfo
+	for _, c := range s.clients {
+		if s.processClient(c).Status == statusWouldMove {
+			candidates = append(candidates, c)
+		}
+	}
+	result.Candidates = len(candidates)
+	if result.Candidates > config.SweepConfirmLimit && !req.confirmed {
+		result.NeedsConfirmation = true
+		return result
+	}
+
+	s.dryRun = false
+	for _, c := range candidates {
+		if s.processClient(c).Status == statusMoved {
+			result.Moved++
+		}
+		if s.aborted {
+			break
+		}
+	}
+	message := fmt.Sprintf("Mass move by %s moved %d of %d clients idle for more than %d seconds", req.source, result.Moved, result.Candidates, result.ThresholdSec)
+	zap.S().Info(message)
+	events.publish(botEvent{Type: "sweep", Message: message})
+	return result
+}
+
+// handleSweepCommand runs "!sweep [minutes]" and "!sweep confirm" for the admin with the given UID.
+func handleSweepCommand(client *ts3.Client, config Config, uid string, name string, args []string, reply func(string, ...interface{})) {
+	req := massSweepRequest{source: name}
+	if len(args) > 0 && args[0] == "confirm" {
+		pending, ok := pendingMassSweeps[uid]
+		delete(pendingMassSweeps, uid)
+		if !ok || clock.Now().After(pending.expires) {
+			reply("There is no mass move waiting for confirmation.")
+			return
+		}
+		req.thresholdMs, req.confirmed = pending.thresholdMs, true
+	} else if len(args) > 0 {
+		minutes, err := strconv.Atoi(args[0])
+		if err != nil || minutes < 1 {
+			reply("Usage: !sweep [minutes] or !sweep confirm")
+			return
+		}
+		req.thresholdMs = minutes * 60 * 1000
+	}
+
+	result := runMassSweep(client, config, req)
+	switch {
+	case result.Error != "":
+		reply("Mass move failed: %s.", result.Error)
+	case result.NeedsConfirmation:
+		pendingMassSweeps[uid] = pendingMassSweep{thresholdMs: req.thresholdMs, expires: clock.Now().Add(massSweepConfirmTimeout)}
+		reply("This would move %d clients. Send !sweep confirm within %v to do it.", result.Candidates, massSweepConfirmTimeout)
+	default:
+		reply("Moved %d of %d clients idle for more than %d minutes.", result.Moved, result.Candidates, result.ThresholdSec/60)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// matrixNotifier posts events as text messages to a Matrix room, with the access token of a user in that room.
+type matrixNotifier struct {
+	homeserver string
+	token      string
+	room       string
+	client     http.Client
+	// txnPrefix makes the transaction IDs, which the homeserver uses to drop duplicates,
+	// unique for the access token across restarts.
+	txnPrefix string
+}
+
+func newMatrixNotifier(config Config) *matrixNotifier {
+	return &matrixNotifier{
+		homeserver: strings.TrimSuffix(config.MatrixHomeserver, "/"),
+		token:      config.MatrixToken.value(),
+		room:       config.MatrixRoom,
+		client:     http.Client{Timeout: eventSinkTimeout},
+		txnPrefix:  fmt.Sprintf("ts3automove-%d", time.Now().UnixNano()),
+	}
+}
+
+func (n *matrixNotifier) Name() string {
+	return "matrix"
+}
+
+// Send keeps the transaction ID of a batch across retries, so a message that arrived although
+// the answer got lost is not posted twice.
+func (n *matrixNotifier) Send(events []botEvent) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": eventLines(events, matrixMessageLimit)})
+	if err != nil {
+		return permanentError{err}
+	}
+	txn := fmt.Sprintf("%s-%d-%d", n.txnPrefix, events[0].Time.UnixNano(), len(events))
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", n.homeserver, url.PathEscape(n.room), txn)
+	// Errors carry a code such as M_FORBIDDEN when the user is not in the room.
+	_, err = postJSON(&n.client, http.MethodPut, endpoint, body, http.Header{"Authorization": {"Bearer " + n.token}})
+	return err
+}
+
+// matrixMessageLimit keeps messages well below the 64 KiB limit of Matrix events.
+const matrixMessageLimit = 16000
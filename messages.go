@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+)
+
+// Text message target modes of the sendtextmessage command.
+const (
+	textTargetClient  = 1
+	textTargetChannel = 2
+	textTargetServer  = 3
+)
+
+// sendServerMessage posts msg to the server-wide chat of the selected virtual server.
+func sendServerMessage(client *ts3.Client, serverId int, msg string) error {
+	_, err := execCmd(client, ts3.NewCmd("sendtextmessage").WithArgs(
+		ts3.NewArg("targetmode", textTargetServer),
+		ts3.NewArg("target", serverId),
+		ts3.NewArg("msg", msg),
+	))
+	return err
+}
+
+// sendChannelMessage posts msg to the chat of the channel the bot is in.
+func sendChannelMessage(client *ts3.Client, msg string) error {
+	_, err := execCmd(client, ts3.NewCmd("sendtextmessage").WithArgs(
+		ts3.NewArg("targetmode", textTargetChannel),
+		ts3.NewArg("msg", msg),
+	))
+	return err
+}
+
+// sendPrivateMessage sends msg to a single client.
+func sendPrivateMessage(client *ts3.Client, clientId int, msg string) error {
+	_, err := execCmd(client, ts3.NewCmd("sendtextmessage").WithArgs(
+		ts3.NewArg("targetmode", textTargetClient),
+		ts3.NewArg("target", clientId),
+		ts3.NewArg("msg", msg),
+	))
+	return err
+}
+
+// notifyAdmins sends msg as private message to every client in TS3_ADMIN_UIDS that is online.
+func notifyAdmins(client *ts3.Client, config Config, msg string) {
+	if len(config.AdminUIDs) == 0 {
+		return
+	}
+	clients, err := listClients(client)
+	if err != nil {
+		zap.S().Errorf("Failed to list clients to notify admins: %v", err)
+		return
+	}
+	for _, c := range clients {
+		if !containsString(config.AdminUIDs, c.UniqueIdentifier) {
+			continue
+		}
+		if err = sendPrivateMessage(client, c.ID, msg); err != nil {
+			zap.S().Errorf("Failed to notify admin %s: %v", c.logName(), err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// metricsSink receives the metrics the bot emits.
+// Tags are given as "key:value" pairs; sinks that don't support tags drop them.
+type metricsSink interface {
+	count(name string, value int64, tags ...string)
+	gauge(name string, value float64, tags ...string)
+	timing(name string, d time.Duration, tags ...string)
+}
+
+var metrics metricsSink = noopMetrics{}
+
+type noopMetrics struct{}
+
+func (noopMetrics) count(string, int64, ...string)          {}
+func (noopMetrics) gauge(string, float64, ...string)        {}
+func (noopMetrics) timing(string, time.Duration, ...string) {}
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// announcedCalendarEvent is the calendar event moves were last announced to be suspended for.
+var announcedCalendarEvent string
+
+// mutedSince holds, per client ID, since when the client has been muted without interruption.
+var mutedSince = make(map[int]time.Time)
+
+// idleStreaks counts, per client ID, how many consecutive sweeps saw the client above the idle threshold.
+var idleStreaks = make(map[int]int)
+
+// startedAt is when the bot started; with TS3_STARTUP_GRACE idle times count from then at most.
+var startedAt time.Time
+
+// Outcomes of evaluating a client during a sweep.
+const (
+	statusUnwatched    = "unwatched"
+	statusGrace        = "grace period"
+	statusExempt       = "exempt"
+	statusError        = "error"
+	statusActive       = "active"
+	statusAllowed      = "allowed channel"
+	statusInAfk        = "in afk channel"
+	statusConfirming   = "confirming"
+	statusSolo         = "solo"
+	statusConversation = "in conversation"
+	statusBusyChannel  = "active channel"
+	statusNewClient    = "recently connected"
+	statusSkipped      = "skipped"
+	statusQuiet        = "quiet hours"
+	statusEvent        = "calendar event"
+	statusPaused       = "paused"
+	statusLeft         = "left"
+	statusScheduled    = "scheduled"
+	statusWouldMove    = "would move"
+	statusWouldReturn  = "would return"
+	statusDeferred     = "deferred"
+	statusHeld         = "held for confirmation"
+	statusMoved        = "moved"
+	statusReturned     = "returned"
+	statusManual       = "moved manually"
+	statusNoTarget     = "no target"
+	statusWarnOnly     = "warn only"
+	statusObserved     = "observed"
+	statusAnomaly      = "held for anomaly"
+)
+
+// sweep is what a single pass over all online clients knows about the server.
+type sweep struct {
+	client       *ts3.Client
+	config       Config
+	tree         *channelTree
+	clients      []*clientInfo
+	afkChannelId int
+	// afkChannels are the AFK channel and those of TS3_AFK_CHANNELS that exist, moves are spread over them.
+	afkChannels         []*afkChannel
+	allowedIdleChannels []int
+	// calendarEvent is the summary of the calendar event running during this sweep, if any.
+	calendarEvent string
+	pause         pauseStatus
+	// now is the time the sweep started at, all decisions of the sweep are based on it.
+	now time.Time
+	// forcedThresholdMs is set for mass moves ordered by an admin. It replaces every idle limit,
+	// and only exemptions are respected, not grace periods, confirmations, pauses or schedules.
+	forcedThresholdMs int
+	// dryRun evaluates clients without moving them.
+	dryRun bool
+	// idleSamples counts the clients whose idle time was queried, zeroIdleSamples those that reported 0.
+	idleSamples     int
+	zeroIdleSamples int
+	// moves counts the clients moved during this sweep.
+	moves int
+	// aborted is set when the server asked the bot to back off, ending the sweep early.
+	aborted bool
+}
+
+// clientStatus is the outcome of evaluating a single client during a sweep.
+type clientStatus struct {
+	ID            int    `json:"clid"`
+	ChannelID     int    `json:"cid"`
+	Nickname      string `json:"nickname"`
+	UID           string `json:"uid"`
+	Platform      string `json:"platform,omitempty"`
+	Version       string `json:"version,omitempty"`
+	IdleTimeMs    int    `json:"idle_time_ms"`
+	MaxIdleTimeMs int    `json:"max_idle_time_ms"`
+	// ConnectedTimeMs is how long the client has been connected, if its details were queried.
+	ConnectedTimeMs int    `json:"connected_time_ms,omitempty"`
+	Status          string `json:"status"`
+	// Reason is the reason code of a client over its limit, see reasons.go.
+	Reason string `json:"reason,omitempty"`
+	// NextCheck is when the idle time of a client with status scheduled is queried next.
+	NextCheck *time.Time `json:"next_check,omitempty"`
+
+	// targetChannelID is the channel a client evaluated in a dry run would be moved to.
+	targetChannelID int
+}
+
+func isChannelIgnored(channels []int, id int) bool {
+	for _, channel := range channels {
+		if channel == id {
+			return true
+		}
+	}
+	return false
+}
+
+// previousClients is the client list of the last sweep, keyed by client ID.
+var previousClients = make(map[int]*clientInfo)
+
+// diffClients compares clients with the list of the last sweep and forgets the state kept for clients
+// that left. Clients that stayed keep their idle streaks, mute times and scheduled checks.
+func diffClients(clients []*clientInfo, reconnectWindow time.Duration) {
+	current := make(map[int]*clientInfo, len(clients))
+	joined := 0
+	for _, c := range clients {
+		current[c.ID] = c
+		if _, ok := previousClients[c.ID]; !ok {
+			joined++
+		}
+	}
+	left := 0
+	for id := range previousClients {
+		if _, ok := current[id]; !ok {
+			clientLeft(id, reconnectWindow)
+			left++
+		}
+	}
+	if joined > 0 || left > 0 {
+		zap.S().Debugf("%d clients joined and %d left since the last sweep", joined, left)
+	}
+	previousClients = current
+}
+
+// forgetClient drops everything the bot remembers about the client with the given ID.
+func forgetClient(id int) {
+	delete(idleStreaks, id)
+	delete(mutedSince, id)
+	delete(lastTalked, id)
+	checks.remove(id)
+	delete(repeatedLogs, id)
+	delete(warnedClients, id)
+	delete(manualMoves, id)
+}
+
+// logDecision logs why a client was left alone and publishes it on the event stream.
+// Repeats of an unchanged decision are only logged once per TS3_LOG_DEDUPE_SEC.
+func logDecision(c *clientInfo, format string, args ...interface{}) {
+	logClientDeduped(c, format, args...)
+	publishClientEvent("decision", c, fmt.Sprintf(format, args...))
+}
+
+func logClientError(c *clientInfo, err error) {
+	zap.S().Error(err)
+	publishClientEvent("error", c, err.Error())
+}
+
+// announceCalendarEvent tells the server when moves are suspended or resumed because of a calendar event.
+func announceCalendarEvent(client *ts3.Client, config Config, event string) {
+	if event == announcedCalendarEvent {
+		return
+	}
+	previous := announcedCalendarEvent
+	announcedCalendarEvent = event
+
+	var msg string
+	if event != "" {
+		zap.S().Infof("Suspending moves during calendar event %q", event)
+		msg = fmt.Sprintf("AFK bot paused for %s", event)
+	} else {
+		zap.S().Infof("Calendar event %q ended, resuming moves", previous)
+		msg = "AFK bot resumed"
+	}
+	if !config.CalendarAnnounce {
+		return
+	}
+	if err := sendServerMessage(client, serverID, msg); err != nil {
+		zap.S().Errorf("Failed to announce calendar event: %v", err)
+	}
+}
+
+func processClients(client *ts3.Client, config Config) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		metrics.count("sweeps", 1)
+		metrics.timing("sweep.duration", duration)
+		// With adaptive polling the interval depends on the clients, so take the one that follows this sweep.
+		if interval := sweepIntervalAfter(config, latestSweep()); duration > interval {
+			zap.S().Warnf("Sweep took %v, longer than the sweep interval of %v", duration.Truncate(time.Millisecond), interval)
+		}
+	}()
+
+	pruneRecentJoins(config.GracePeriod)
+	pruneRejectedTargets(clock.Now())
+	prunePermissionFailures(clock.Now(), config.PermissionDigestAt >= 0)
+
+	now := clock.Now()
+	if now.Before(floodBackoffUntil) {
+		zap.S().Debugf("Skipping sweep, backing off from flooding until %s", floodBackoffUntil.Format(time.RFC3339))
+		return
+	}
+
+	if serverDown && !recoverServer(client, config) {
+		zap.S().Debugf("Skipping sweep, virtual server %d is not running", serverID)
+		reportSweepProblem(healthServerDown, fmt.Sprintf("virtual server %d is not running", serverID))
+		return
+	}
+
+	s, channels, ok := newSweep(client, config, now)
+	if !ok {
+		return
+	}
+	if s.listAnomaly(channels) {
+		return
+	}
+	if !config.AllServers {
+		// Database IDs are only unique per virtual server.
+		exemptDatabaseIDs.resolve(client, config)
+	}
+	s.calendarEvent, _ = calendar.activeEvent(now)
+	announceCalendarEvent(client, config, s.calendarEvent)
+	s.pause = pause.status(now)
+	announcePause(client, config)
+
+	metrics.gauge("clients.online", float64(len(s.clients)))
+	s.trackOccupancy()
+	s.trackTalking()
+	s.trackMusicBots()
+	if config.AllServers {
+		trackJoins(s.clients, now)
+	}
+
+	// With a limit on large sweeps, idle ratios, batched moves or anomaly detection, clients are evaluated first
+	// and moved once it is clear how many there are, how many of their channels are idle and whether the data looks sane.
+	s.dryRun = config.LargeSweepLimit > 0 || usesIdleRatios(config) || config.BatchMoves || config.AnomalyDetection
+	s.correlateReconnects()
+	s.checkReturns()
+	s.restoreReturns()
+	statuses := make([]clientStatus, 0, len(s.clients))
+	for _, c := range s.clients {
+		statuses = append(statuses, s.processClient(c))
+		if s.aborted {
+			break
+		}
+	}
+	if s.dryRun && !s.aborted && !s.holdForAnomaly(statuses) {
+		s.finishSweep(statuses)
+	}
+
+	diffClients(s.clients, config.ReconnectWindow)
+	if logSummary {
+		logSweepSummary(statuses)
+	}
+	for i := range statuses {
+		if check, ok := checks.get(statuses[i].ID); ok {
+			at := check.at
+			statuses[i].NextCheck = &at
+		}
+	}
+
+	recordSweep(sweepSnapshot{
+		Time:         clock.Now(),
+		ServerID:     serverID,
+		AfkChannelID: s.afkChannelId,
+		Channels:     channels,
+		Clients:      statuses,
+	})
+}
+
+// afkChannelID is the ID the AFK channel had in the previous sweep, to log when it changes.
+var afkChannelID int
+
+// newSweep fetches the channels and clients a sweep works on.
+func newSweep(client *ts3.Client, config Config, now time.Time) (*sweep, []*channelInfo, bool) {
+	// Get the list of channels.
+	channels, err := channelList.get(client)
+	if err != nil {
+		zap.S().Errorf("Error getting channel list: %v", err)
+		events.publish(botEvent{Type: "error", Message: fmt.Sprintf("Error getting channel list: %v", err)})
+		if handleQueryError(client, config, "channellist", err) == queryErrorOther {
+			time.Sleep(5 * time.Second)
+		}
+		return nil, nil, false
+	}
+
+	s := &sweep{client: client, config: config, now: now}
+	for _, channel := range channels {
+		if channel.isSpacer() {
+			continue
+		}
+		if channel.ChannelName == config.AfkChannelName {
+			s.afkChannelId = channel.ID
+		}
+
+		for _, ignoredChannel := range config.IgnoredChannels {
+			if channel.ChannelName == ignoredChannel {
+				s.allowedIdleChannels = append(s.allowedIdleChannels, channel.ID)
+				//zap.S().Infof("Ignoring channel %s [%d]", channel.ChannelName, channel.ID)
+			}
+		}
+
+		// Channel owners can opt out without touching the bot config.
+		if config.OptOutTag != "" && channel.hasTag(config.OptOutTag) {
+			s.allowedIdleChannels = append(s.allowedIdleChannels, channel.ID)
+		}
+	}
+
+	if s.afkChannelId == 0 {
+		// The AFK channel may have been renamed or deleted, nobody is moved until it is back.
+		zap.S().Errorf("AFK channel %q not found, skipping sweep", config.AfkChannelName)
+		events.publish(botEvent{Type: "error", Message: fmt.Sprintf("AFK channel %q not found", config.AfkChannelName)})
+		reportSweepProblem(healthNoAfkChannel, fmt.Sprintf("no channel named %q", config.AfkChannelName))
+		return nil, nil, false
+	}
+	if s.afkChannelId != afkChannelID {
+		zap.S().Infof("AFK channel %q is channel %d", config.AfkChannelName, s.afkChannelId)
+		afkChannelID = s.afkChannelId
+	}
+
+	s.tree = newChannelTree(channels)
+
+	// Get the list of clients.
+	s.clients, err = listClients(client)
+	if err != nil {
+		zap.S().Errorf("Error getting c list: %v", err)
+		events.publish(botEvent{Type: "error", Message: fmt.Sprintf("Error getting client list: %v", err)})
+		if handleQueryError(client, config, "clientlist", err) == queryErrorOther {
+			time.Sleep(5 * time.Second)
+		}
+		return nil, nil, false
+	}
+	s.resolveAfkChannels()
+	return s, channels, true
+}
+
+// queryFailed handles a failed query of op for client c and returns the resulting status.
+func (s *sweep) queryFailed(c *clientInfo, op string, err error) string {
+	switch handleQueryError(s.client, s.config, op, err) {
+	case queryErrorInvalidClient:
+		// The client left since the client list was fetched, so the list is stale.
+		zap.S().Debugf("User %s left before %s, refreshing client list", c.logName(), op)
+		clientLeft(c.ID, s.config.ReconnectWindow)
+		if clients, err := listClients(s.client); err == nil {
+			s.clients = clients
+		}
+		return statusLeft
+	case queryErrorFlood:
+		s.aborted = true
+	}
+	logClientError(c, err)
+	return statusError
+}
+
+// trackMute records whether c is muted and returns for how long it has been muted without interruption.
+// Depending on TS3_MUTED_AFK_MODE a muted microphone is enough, or the speakers have to be muted as well.
+func (s *sweep) trackMute(c *clientInfo, details *clientDetails) time.Duration {
+	muted := details.InputMuted
+	if s.config.MutedAfkMode == "both" {
+		muted = details.InputMuted && details.OutputMuted
+	}
+	if !muted {
+		delete(mutedSince, c.ID)
+		return 0
+	}
+	since, ok := mutedSince[c.ID]
+	if !ok {
+		since = s.now
+		mutedSince[c.ID] = since
+	}
+	return s.now.Sub(since)
+}
+
+// scheduleCheck plans the next clientinfo query of an active client for when it could cross its limit at the earliest.
+func (s *sweep) scheduleCheck(c *clientInfo, status clientStatus, mutedFor time.Duration, overrideIdleMs int) {
+	at := s.now.Add(time.Duration(status.MaxIdleTimeMs-status.IdleTimeMs) * time.Millisecond)
+	if s.config.WarnBefore > 0 && !warnedClients[c.ID] {
+		// Wake up in time for the warning.
+		at = at.Add(-s.config.WarnBefore)
+	}
+	if next := nextConnectedRule(s.config.ConnectedIdleTimes, status.ConnectedTimeMs); next > 0 {
+		// A different limit applies once the client has been connected long enough.
+		if ruleAt := s.now.Add(next); ruleAt.Before(at) {
+			at = ruleAt
+		}
+	}
+	if s.config.MutedAfkTime > 0 {
+		if muteAt := s.now.Add(s.config.MutedAfkTime - mutedFor); muteAt.Before(at) {
+			at = muteAt
+		}
+	}
+	checks.schedule(&scheduledCheck{
+		clientID:       c.ID,
+		at:             at,
+		idleTimeMs:     status.IdleTimeMs,
+		maxIdleTimeMs:  status.MaxIdleTimeMs,
+		measuredAt:     s.now,
+		channelID:      c.ChannelID,
+		overrideIdleMs: overrideIdleMs,
+	})
+}
+
+// processClient decides whether c has to be moved and moves it.
+func (s *sweep) processClient(c *clientInfo) clientStatus {
+	config := s.config
+	status := clientStatus{
+		ID:            c.ID,
+		ChannelID:     c.ChannelID,
+		Nickname:      c.Nickname,
+		UID:           c.UniqueIdentifier,
+		IdleTimeMs:    -1,
+		MaxIdleTimeMs: config.MaxIdleTimeMs,
+	}
+	result := func(outcome string) clientStatus {
+		status.Status = outcome
+		return status
+	}
+
+	// In include-list mode everything outside the watched subtrees is left alone.
+	if len(config.WatchedChannels) > 0 && !s.tree.inSubtree(c.ChannelID, config.WatchedChannels) {
+		return result(statusUnwatched)
+	}
+
+	// The bot must not undo what a moderator just did.
+	if config.ManualMoveHold > 0 && s.forcedThresholdMs == 0 && manuallyMoved(c, config.ManualMoveHold, s.now) {
+		logDecision(c, "User %s was moved by somebody else less than %v ago", c.logName(), config.ManualMoveHold)
+		return result(statusManual)
+	}
+
+	// If the client is in a channel that had a recent join, ignore their idle time for the grace period.
+	if joinTime, ok := recentJoins[c.ChannelID]; ok && config.AllowGracePeriod && s.forcedThresholdMs == 0 {
+		if s.now.Sub(joinTime) <= config.GracePeriod {
+			logDecision(c, "User %s's idle time ignored for %v due to recent join", c.logName(), config.GracePeriod)
+			return result(statusGrace)
+		}
+	}
+
+	override, hasOverride := clientOverrides.get(c.UniqueIdentifier)
+	if hasOverride && override.Exempt {
+		return result(statusExempt)
+	}
+
+	if exemptNickname(config, c.Nickname) {
+		return result(statusExempt)
+	}
+
+	if exemptDatabaseIDs.contains(c.DatabaseID) {
+		return result(statusExempt)
+	}
+
+	if len(config.ExemptGroups) > 0 && groupMembers.inAny(s.client, config, c.DatabaseID, config.ExemptGroups) {
+		return result(statusExempt)
+	}
+
+	if name, ok := s.memberOfExemptSet(c); ok {
+		logDecision(c, "User %s is exempt as member of set %s", c.logName(), name)
+		return result(statusExempt)
+	}
+
+	if musicBots[c.UniqueIdentifier] {
+		return result(statusExempt)
+	}
+
+	// Clients the bot repeatedly lacked the permissions to move, e.g. higher-ranked admins, end up in the daily digest instead.
+	if permissionSkipped(c.UniqueIdentifier, s.now) {
+		return result(statusSkipped)
+	}
+
+	overrideIdleMs := 0
+	if hasOverride {
+		overrideIdleMs = override.MaxIdleTimeSec * 1000
+	}
+	away := config.MoveAway && c.Away
+	if check, ok := checks.get(c.ID); ok && !away && s.forcedThresholdMs == 0 && s.now.Before(check.at) && check.channelID == c.ChannelID && check.overrideIdleMs == overrideIdleMs && !s.musicBotCandidate(c) {
+		// The client cannot have reached its limit yet, so spare the clientinfo query.
+		status.IdleTimeMs = check.idleTimeMs + int(s.now.Sub(check.measuredAt)/time.Millisecond)
+		status.MaxIdleTimeMs = check.maxIdleTimeMs
+		return result(statusScheduled)
+	}
+	checks.remove(c.ID)
+
+	details, err := getClientDetails(s.client, c.ID)
+	if err != nil {
+		return result(s.queryFailed(c, "clientinfo", err))
+	}
+	if details.IdleTimeMs < 0 {
+		zap.S().Error("client_idle_time not found")
+		return result(statusError)
+	}
+	idleTime := details.IdleTimeMs
+	s.idleSamples++
+	if idleTime == 0 {
+		s.zeroIdleSamples++
+	}
+	// Clients that went idle while the bot was offline get the full idle limit from its start.
+	if config.StartupGrace && !startedAt.IsZero() && s.forcedThresholdMs == 0 {
+		if sinceStart := int(s.now.Sub(startedAt) / time.Millisecond); idleTime > sinceStart {
+			idleTime = sinceStart
+		}
+	}
+	status.IdleTimeMs = idleTime
+	status.Platform = details.Platform
+	status.Version = details.Version
+	status.ConnectedTimeMs = details.ConnectedTimeMs
+
+	// Some clients, e.g. mobile ones, report unreliable idle times.
+	if containsFold(config.ExemptPlatforms, details.Platform) {
+		return result(statusExempt)
+	}
+	if config.ExemptVersionRegex != nil && config.ExemptVersionRegex.MatchString(details.Version) {
+		return result(statusExempt)
+	}
+	// Communities that grant privileges to myTeamSpeak accounts rather than server groups.
+	if exemptAccount(config, details) {
+		return result(statusExempt)
+	}
+	if s.detectMusicBot(c, details) {
+		return result(statusExempt)
+	}
+
+	if platformIdleTime, ok := config.PlatformIdleTimeMs[strings.ToLower(details.Platform)]; ok {
+		status.MaxIdleTimeMs = platformIdleTime
+	}
+	if connectedIdleTime, ok := connectedIdleLimit(config.ConnectedIdleTimes, details.ConnectedTimeMs); ok {
+		status.MaxIdleTimeMs = connectedIdleTime
+	}
+	if hasOverride && override.MaxIdleTimeSec > 0 {
+		status.MaxIdleTimeMs = override.MaxIdleTimeSec * 1000
+	}
+	if s.forcedThresholdMs > 0 {
+		status.MaxIdleTimeMs = s.forcedThresholdMs
+	}
+	mutedFor := s.trackMute(c, details)
+	longMuted := config.MutedAfkTime > 0 && mutedFor >= config.MutedAfkTime
+	if idleTime <= status.MaxIdleTimeMs && !longMuted && !away {
+		delete(idleStreaks, c.ID)
+		if s.returnHome(c, &status) {
+			return status
+		}
+		s.warnBeforeMove(c, status, override)
+		if config.PredictiveChecks && s.forcedThresholdMs == 0 {
+			s.scheduleCheck(c, status, mutedFor, overrideIdleMs)
+		}
+		return result(statusActive)
+	}
+	if config.MinConnected > 0 && s.forcedThresholdMs == 0 && details.ConnectedTimeMs < int(config.MinConnected/time.Millisecond) {
+		logDecision(c, "User %s is idle for %d seconds, but only connected for %d seconds", c.logName(), idleTime/1000, details.ConnectedTimeMs/1000)
+		return result(statusNewClient)
+	}
+	switch {
+	case s.forcedThresholdMs > 0:
+		status.Reason = reasonAdminCommand
+	case idleTime > status.MaxIdleTimeMs:
+		status.Reason = reasonThreshold
+	case longMuted:
+		status.Reason = reasonMuted
+	default:
+		status.Reason = reasonAway
+	}
+	if s.forcedThresholdMs == 0 {
+		idleStreaks[c.ID]++
+		if idleStreaks[c.ID] == 1 {
+			publishClientAction("idle", c, status.Reason, fmt.Sprintf("Idle for %d seconds, over the limit of %d", idleTime/1000, status.MaxIdleTimeMs/1000))
+		}
+	}
+	if longMuted && idleTime <= status.MaxIdleTimeMs {
+		logClientDeduped(c, "User %s is only idle for %d seconds, but muted for %v", c.logName(), idleTime/1000, mutedFor.Truncate(time.Second))
+	}
+
+	if isChannelIgnored(s.allowedIdleChannels, c.ChannelID) {
+		logDecision(c, "User %s is idle for %d seconds, but in allowed channel", c.logName(), idleTime/1000)
+		return result(statusAllowed)
+	}
+	if len(config.WarnOnlyChannels) > 0 && s.tree.inSubtree(c.ChannelID, config.WarnOnlyChannels) {
+		s.warnOnly(c, status, override)
+		logDecision(c, "User %s is idle for %d seconds, but in a warn-only channel", c.logName(), idleTime/1000)
+		return result(statusWarnOnly)
+	}
+	canEnter := func(id int) bool { return s.canEnter(c, details, id) }
+	targetChannelId := s.pickAfkChannel(canEnter)
+	if config.SectionAfkRegex != nil {
+		if sectionAfk := s.tree.nearestMatchingChild(c.ChannelID, config.SectionAfkRegex); sectionAfk != nil && canEnter(sectionAfk.ID) {
+			targetChannelId = sectionAfk.ID
+		}
+	}
+	if hasOverride && override.TargetChannel != "" {
+		if target := s.tree.byName(override.TargetChannel); target == nil {
+			zap.S().Warnf("Target channel %q of user %s not found, using afk channel", override.TargetChannel, c.logName())
+		} else if canEnter(target.ID) {
+			targetChannelId = target.ID
+		}
+	}
+	if s.isAfkChannel(c.ChannelID) || c.ChannelID == targetChannelId {
+		logDecision(c, "User %s is idle for %d seconds, but already in afk channel", c.logName(), idleTime/1000)
+		return result(statusInAfk)
+	}
+	if targetChannelId == 0 {
+		logDecision(c, "User %s is idle for %d seconds, but cannot be moved into any AFK channel", c.logName(), idleTime/1000)
+		return result(statusNoTarget)
+	}
+
+	if s.forcedThresholdMs > 0 {
+		return s.move(c, status, targetChannelId)
+	}
+
+	// A single stale reading must not move anybody, so require several in a row.
+	if idleStreaks[c.ID] < config.IdleConfirmSamples {
+		logDecision(c, "User %s is idle for %d seconds, waiting for confirmation (%d/%d)", c.logName(), idleTime/1000, idleStreaks[c.ID], config.IdleConfirmSamples)
+		return result(statusConfirming)
+	}
+
+	// Check if a user is solo in a channel
+	isSolo := true
+	for _, c2 := range s.clients {
+		if c2.ChannelID == c.ChannelID && c2.ID != c.ID {
+			isSolo = false
+			break
+		}
+	}
+	if isSolo {
+		logDecision(c, "User %s is idle for %d seconds, but solo in channel", c.logName(), idleTime/1000)
+		return result(statusSolo)
+	}
+
+	// Pulling one side out of a 1-on-1 call is worse than leaving an idle listener alone.
+	if s.inConversation(c) {
+		logDecision(c, "User %s is idle for %d seconds, but in a conversation with one other user", c.logName(), idleTime/1000)
+		return result(statusConversation)
+	}
+
+	if s.pause.Paused {
+		logDecision(c, "User %s is idle for %d seconds, but moves are paused", c.logName(), idleTime/1000)
+		return result(statusPaused)
+	}
+
+	if s.calendarEvent != "" {
+		logDecision(c, "User %s is idle for %d seconds, but moves are suspended for %q", c.logName(), idleTime/1000, s.calendarEvent)
+		return result(statusEvent)
+	}
+
+	if inAnyWindow(config.QuietHours, s.now.In(config.Location)) {
+		logDecision(c, "User %s is idle for %d seconds, but it is quiet hours", c.logName(), idleTime/1000)
+		return result(statusQuiet)
+	}
+
+	// Spread moves over several sweeps, e.g. after downtime, instead of moving everybody at once.
+	if config.MaxMovesPerSweep > 0 && s.moves >= config.MaxMovesPerSweep {
+		logDecision(c, "User %s is idle for %d seconds, but the move is deferred, %d users were already moved in this sweep", c.logName(), idleTime/1000, s.moves)
+		return result(statusDeferred)
+	}
+
+	return s.move(c, status, targetChannelId)
+}
+
+// move moves c into the target channel and records the move.
+func (s *sweep) move(c *clientInfo, status clientStatus, targetChannelId int) clientStatus {
+	idleTime := status.IdleTimeMs
+	if s.dryRun {
+		status.Status = statusWouldMove
+		status.targetChannelID = targetChannelId
+		s.countAfkMove(targetChannelId)
+		return status
+	}
+	if s.config.ObserveOnly {
+		return s.observe(c, status, targetChannelId)
+	}
+
+	if s.pausedMidSweep() {
+		status.Status = statusPaused
+		return status
+	}
+	zap.S().Infof("User %s is idle for %d seconds", c.logName(), idleTime/1000)
+	zap.S().Infof("moving c to afk channel [%d], reason %s", targetChannelId, status.Reason)
+	if err := moveClient(s.client, c.ID, targetChannelId); err != nil {
+		if isPermissionError(err) {
+			s.permissionDenied(c, err)
+		}
+		if rejectsTarget(err) {
+			// Try another channel next time instead of failing every sweep.
+			rejectTarget(c.UniqueIdentifier, targetChannelId, s.now)
+		}
+		status.Status = s.queryFailed(c, "clientmove", err)
+		return status
+	}
+	return s.moved(c, status, targetChannelId)
+}
+
+// moved records that c was moved into the target channel.
+func (s *sweep) moved(c *clientInfo, status clientStatus, targetChannelId int) clientStatus {
+	idleTime := status.IdleTimeMs
+	permissionGranted(c.UniqueIdentifier)
+	publishClientAction("move", c, status.Reason, fmt.Sprintf("Moved to channel %d after %d seconds idle", targetChannelId, idleTime/1000))
+	if status.targetChannelID == 0 {
+		// Moves held back by a dry run were counted when they were planned.
+		s.countAfkMove(targetChannelId)
+	}
+	delete(idleStreaks, c.ID)
+	metrics.count("moves", 1, "reason:"+status.Reason)
+	s.moves++
+
+	err := storage.RecordMove(MoveRecord{
+		UID:           privacy.uid(c.UniqueIdentifier),
+		Nickname:      c.logName(),
+		FromChannel:   c.ChannelID,
+		ToChannel:     targetChannelId,
+		IdleTimeMs:    idleTime,
+		MaxIdleTimeMs: status.MaxIdleTimeMs,
+		Reason:        status.Reason,
+		MovedAt:       clock.Now(),
+	})
+	if err != nil {
+		zap.S().Errorf("Failed to record move of %s: %v", c.logName(), err)
+	}
+	if err = storage.SetHomeChannel(privacy.uid(c.UniqueIdentifier), c.ChannelID); err != nil {
+		zap.S().Errorf("Failed to record home channel of %s: %v", c.logName(), err)
+	}
+	s.rememberReturn(c, targetChannelId)
+	s.rememberUndo(c, targetChannelId)
+
+	status.Status = statusMoved
+	return status
+}
+
+// exemptNickname reports whether nickname matches TS3_EXEMPT_NICKNAMES.
+// Music and utility bots are idle all the time but have to stay in their channels.
+func exemptNickname(config Config, nickname string) bool {
+	for _, re := range config.ExemptNicknames {
+		if re.MatchString(nickname) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"strings"
+	"testing"
+	"time"
+)
+
+// moverTest drives sweeps against a fakeServer with a fake clock.
+type moverTest struct {
+	t      *testing.T
+	clock  *fakeClock
+	server *fakeServer
+	client *ts3.Client
+	config Config
+}
+
+// loadTestConfig loads the config from env on top of the required settings.
+func loadTestConfig(t *testing.T, env map[string]string) Config {
+	t.Helper()
+	t.Setenv("TS3_USER", "serveradmin")
+	t.Setenv("TS3_PASSWORD", "password")
+	t.Setenv("TS3_URL", "127.0.0.1:10011")
+	t.Setenv("TS3_SERVER_ID", "1")
+	t.Setenv("TS3_AFK_CHANNEL_NAME", "AFK")
+	t.Setenv("TS3_TIMEZONE", "UTC")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	return config
+}
+
+// newMoverTest resets the state sweeps keep between runs and loads the config from env.
+// The server has alice, idle for an hour, and bob and carol, who are active, in the Lobby.
+func newMoverTest(t *testing.T, env map[string]string) *moverTest {
+	config := loadTestConfig(t, env)
+	fake := &fakeClock{now: time.Date(2026, 10, 17, 14, 0, 0, 0, time.UTC)}
+	clock = fake
+	t.Cleanup(func() { clock = systemClock{} })
+	idleStreaks = make(map[int]int)
+	mutedSince = make(map[int]time.Time)
+	previousClients = make(map[int]*clientInfo)
+	recentJoins = make(map[int]time.Time)
+	pendingReturns = make(map[string]pendingReturn)
+	homeLookups = make(map[string]bool)
+	warnedClients = make(map[int]bool)
+	manualMoves = make(map[int]time.Time)
+	sharedHolds.byUID = make(map[string]time.Time)
+	checks = &checkQueue{byClient: make(map[int]*scheduledCheck)}
+	channelList = &channelCache{stale: true}
+	pause = &pauseState{changed: make(chan struct{}, 1)}
+	anomalies = anomalyBaseline{}
+	storage = newMemoryStorage()
+
+	server, client := newFakeServer(t)
+	server.add(&fakeClient{id: 1, channel: 1, nickname: "alice", uid: "alice=", idle: time.Hour})
+	server.add(&fakeClient{id: 2, channel: 1, nickname: "bob", uid: "bob="})
+	server.add(&fakeClient{id: 3, channel: 1, nickname: "carol", uid: "carol="})
+	return &moverTest{t: t, clock: fake, server: server, client: client, config: config}
+}
+
+// sweep runs a sweep and returns the status of each client by nickname.
+func (m *moverTest) sweep() map[string]string {
+	m.t.Helper()
+	processClients(m.client, m.config)
+	statuses := make(map[string]string)
+	for _, status := range latestSweep().Clients {
+		statuses[status.Nickname] = status.Status
+	}
+	return statuses
+}
+
+func (m *moverTest) expectMoves(moves ...string) {
+	m.t.Helper()
+	if got := m.server.movedClients(); strings.Join(got, " ") != strings.Join(moves, " ") {
+		m.t.Fatalf("moves = %v, want %v", got, moves)
+	}
+}
+
+func expectStatus(t *testing.T, statuses map[string]string, nickname string, want string) {
+	t.Helper()
+	if statuses[nickname] != want {
+		t.Fatalf("status of %s = %q, want %q (all: %v)", nickname, statuses[nickname], want, statuses)
+	}
+}
+
+func TestSweepMovesIdleClient(t *testing.T) {
+	m := newMoverTest(t, nil)
+	statuses := m.sweep()
+	expectStatus(t, statuses, "alice", statusMoved)
+	expectStatus(t, statuses, "bob", statusActive)
+	m.expectMoves("1->2")
+}
+
+func TestGracePeriod(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_GRACE_PERIOD_SEC": "60"})
+	recentJoins[1] = m.clock.Now().Add(-30 * time.Second)
+	expectStatus(t, m.sweep(), "alice", statusGrace)
+	m.expectMoves()
+
+	m.clock.advance(time.Minute)
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+	m.expectMoves("1->2")
+}
+
+func TestGracePeriodDisabled(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_ALLOW_GRACE_PERIOD": "false"})
+	recentJoins[1] = m.clock.Now()
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+}
+
+func TestIdleConfirmSamples(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_IDLE_CONFIRM_SAMPLES": "3"})
+	for i := 0; i < 2; i++ {
+		expectStatus(t, m.sweep(), "alice", statusConfirming)
+		m.expectMoves()
+		m.clock.advance(10 * time.Second)
+	}
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+	m.expectMoves("1->2")
+}
+
+func TestIdleConfirmSamplesResetWhenActive(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_IDLE_CONFIRM_SAMPLES": "2", "TS3_PREDICTIVE_CHECKS": "false"})
+	expectStatus(t, m.sweep(), "alice", statusConfirming)
+
+	m.server.setIdle(1, 0)
+	m.clock.advance(10 * time.Second)
+	expectStatus(t, m.sweep(), "alice", statusActive)
+
+	m.server.setIdle(1, time.Hour)
+	m.clock.advance(10 * time.Second)
+	expectStatus(t, m.sweep(), "alice", statusConfirming)
+	m.expectMoves()
+}
+
+func TestQuietHours(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_QUIET_HOURS": "13:00-15:00"})
+	expectStatus(t, m.sweep(), "alice", statusQuiet)
+	m.expectMoves()
+
+	m.clock.advance(90 * time.Minute)
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+	m.expectMoves("1->2")
+}
+
+func TestQuietHoursAroundMidnight(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_QUIET_HOURS": "22:00-06:00"})
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+
+	m = newMoverTest(t, map[string]string{"TS3_QUIET_HOURS": "22:00-06:00"})
+	m.clock.advance(12 * time.Hour)
+	expectStatus(t, m.sweep(), "alice", statusQuiet)
+}
+
+func TestPause(t *testing.T) {
+	m := newMoverTest(t, nil)
+	pause.set(time.Hour, "event night")
+	expectStatus(t, m.sweep(), "alice", statusPaused)
+	m.expectMoves()
+
+	// The pause runs out on its own.
+	m.clock.advance(time.Hour)
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+	m.expectMoves("1->2")
+}
+
+func TestPauseUntilResumed(t *testing.T) {
+	m := newMoverTest(t, nil)
+	pause.set(0, "")
+	m.clock.advance(24 * time.Hour)
+	expectStatus(t, m.sweep(), "alice", statusPaused)
+
+	pause.clear()
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+}
+
+func TestMaxMovesPerSweep(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_MAX_MOVES_PER_SWEEP": "1"})
+	m.server.add(&fakeClient{id: 4, channel: 1, nickname: "dave", uid: "dave=", idle: 2 * time.Hour})
+	statuses := m.sweep()
+	expectStatus(t, statuses, "alice", statusMoved)
+	expectStatus(t, statuses, "dave", statusDeferred)
+	m.expectMoves("1->2")
+
+	m.clock.advance(10 * time.Second)
+	statuses = m.sweep()
+	expectStatus(t, statuses, "alice", statusInAfk)
+	expectStatus(t, statuses, "dave", statusMoved)
+	m.expectMoves("1->2", "4->2")
+}
+
+func TestMaxMovesPerSweepWithoutDryRun(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_MAX_MOVES_PER_SWEEP": "1", "TS3_ANOMALY_DETECTION": "false"})
+	m.server.add(&fakeClient{id: 4, channel: 1, nickname: "dave", uid: "dave=", idle: 2 * time.Hour})
+	statuses := m.sweep()
+	expectStatus(t, statuses, "alice", statusMoved)
+	expectStatus(t, statuses, "dave", statusDeferred)
+	m.expectMoves("1->2")
+}
+
+// expectCooldown fails unless the stored cooldowns of kind are the given unique identifiers.
+func (m *moverTest) expectCooldown(kind string, uids ...string) {
+	m.t.Helper()
+	cooldowns, err := storage.Cooldowns(kind)
+	if err != nil {
+		m.t.Fatalf("Cooldowns(%s): %v", kind, err)
+	}
+	var got []string
+	for uid := range cooldowns {
+		got = append(got, uid)
+	}
+	if strings.Join(got, " ") != strings.Join(uids, " ") {
+		m.t.Fatalf("%s cooldowns = %v, want %v", kind, got, uids)
+	}
+}
+
+func TestManualMoveHold(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_MANUAL_MOVE_HOLD_SEC": "600", "TS3_PREDICTIVE_CHECKS": "false"})
+	m.server.setIdle(1, 0)
+	expectStatus(t, m.sweep(), "alice", statusActive)
+
+	// A moderator moves alice, who then goes idle.
+	trackManualMove(1, map[string]string{"reasonid": reasonMoved, "invokerid": "7"}, m.config.ManualMoveHold)
+	m.server.setIdle(1, time.Hour)
+	m.clock.advance(9 * time.Minute)
+	expectStatus(t, m.sweep(), "alice", statusManual)
+	m.expectMoves()
+	m.expectCooldown(cooldownManualMove, "alice=")
+
+	m.clock.advance(time.Minute)
+	m.expectCooldown(cooldownManualMove)
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+	m.expectMoves("1->2")
+}
+
+func TestManualMoveHoldOfOtherInstance(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_MANUAL_MOVE_HOLD_SEC": "600", "TS3_PREDICTIVE_CHECKS": "false"})
+	storage.SetCooldown(cooldownManualMove, "alice=", m.clock.Now().Add(10*time.Minute))
+	syncHolds := func() {
+		holds, err := storage.Cooldowns(cooldownManualMove)
+		if err != nil {
+			t.Fatalf("Cooldowns: %v", err)
+		}
+		sharedHolds.byUID = holds
+	}
+
+	syncHolds()
+	expectStatus(t, m.sweep(), "alice", statusManual)
+	m.expectMoves()
+
+	m.clock.advance(10 * time.Minute)
+	syncHolds()
+	if len(sharedHolds.byUID) != 0 {
+		t.Fatalf("expired hold still stored: %v", sharedHolds.byUID)
+	}
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+	m.expectMoves("1->2")
+}
+
+func TestReturnCooldown(t *testing.T) {
+	m := newMoverTest(t, map[string]string{"TS3_RETURN_HOME": "true", "TS3_RETURN_COOLDOWN_SEC": "60", "TS3_PREDICTIVE_CHECKS": "false"})
+	expectStatus(t, m.sweep(), "alice", statusMoved)
+	m.expectMoves("1->2")
+
+	// Alice twitches right after being moved.
+	m.server.setIdle(1, 0)
+	m.clock.advance(30 * time.Second)
+	m.sweep()
+	m.expectMoves("1->2")
+	if home, _ := storage.HomeChannel("alice="); home != 1 {
+		t.Fatalf("stored home channel = %d, want 1", home)
+	}
+
+	m.clock.advance(30 * time.Second)
+	expectStatus(t, m.sweep(), "alice", statusReturned)
+	m.expectMoves("1->2", "1->1")
+	if home, _ := storage.HomeChannel("alice="); home != 0 {
+		t.Fatalf("home channel still stored after the return: %d", home)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"time"
+)
+
+// serverInfo is the part of the serverlist response the bot uses.
+type serverInfo struct {
+	ID     int    `ms:"virtualserver_id"`
+	Port   int    `ms:"virtualserver_port"`
+	Status string `ms:"virtualserver_status"`
+}
+
+// listServers returns the virtual servers of the instance.
+func listServers(client *ts3.Client) ([]*serverInfo, error) {
+	var servers []*serverInfo
+	if err := execQuery(client, ts3.NewCmd("serverlist"), &servers); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// virtualServer holds what the bot remembers about one virtual server with TS3_ALL_SERVERS.
+// Client and channel IDs are only unique per virtual server, so each one gets its own state.
+type virtualServer struct {
+	id              int
+	channelList     *channelCache
+	idleStreaks     map[int]int
+	mutedSince      map[int]time.Time
+	lastTalked      map[int]time.Time
+	talkingStreaks  map[int]int
+	anomalies       anomalyBaseline
+	previousClients map[int]*clientInfo
+	checks          *checkQueue
+	recentJoins     map[int]time.Time
+	afkChannelID    int
+	groupMembers    *groupCache
+	channelVisits   map[int]channelVisit
+	warnedClients   map[int]bool
+	pendingReturns  map[string]pendingReturn
+	departedClients map[string]*departedClient
+
+	// skipped is set once it was logged that the server has no AFK channel.
+	skipped bool
+}
+
+// virtualServers holds the state of every virtual server seen by the last sweep, keyed by server ID.
+var virtualServers = make(map[int]*virtualServer)
+
+// activeServer is the virtual server whose state is currently in the globals the mover works on.
+var activeServer *virtualServer
+
+func newVirtualServer(id int) *virtualServer {
+	return &virtualServer{
+		id:              id,
+		channelList:     &channelCache{stale: true},
+		idleStreaks:     make(map[int]int),
+		mutedSince:      make(map[int]time.Time),
+		lastTalked:      make(map[int]time.Time),
+		talkingStreaks:  make(map[int]int),
+		previousClients: make(map[int]*clientInfo),
+		checks:          &checkQueue{byClient: make(map[int]*scheduledCheck)},
+		recentJoins:     make(map[int]time.Time),
+		groupMembers:    newGroupCache(),
+		channelVisits:   make(map[int]channelVisit),
+		warnedClients:   make(map[int]bool),
+		pendingReturns:  make(map[string]pendingReturn),
+		departedClients: make(map[string]*departedClient),
+	}
+}
+
+// activate saves the state of the active server and swaps in that of vs.
+func (vs *virtualServer) activate() {
+	if activeServer == vs {
+		return
+	}
+	if a := activeServer; a != nil {
+		a.channelList, a.idleStreaks, a.mutedSince = channelList, idleStreaks, mutedSince
+		a.previousClients, a.checks, a.recentJoins, a.afkChannelID = previousClients, checks, recentJoins, afkChannelID
+		a.groupMembers, a.channelVisits, a.warnedClients, a.pendingReturns = groupMembers, channelVisits, warnedClients, pendingReturns
+		a.departedClients, a.lastTalked, a.talkingStreaks, a.anomalies = departedClients, lastTalked, talkingStreaks, anomalies
+	}
+	channelList, idleStreaks, mutedSince = vs.channelList, vs.idleStreaks, vs.mutedSince
+	previousClients, checks, recentJoins, afkChannelID = vs.previousClients, vs.checks, vs.recentJoins, vs.afkChannelID
+	groupMembers, channelVisits, warnedClients, pendingReturns = vs.groupMembers, vs.channelVisits, vs.warnedClients, vs.pendingReturns
+	departedClients, lastTalked, talkingStreaks, anomalies = vs.departedClients, vs.lastTalked, vs.talkingStreaks, vs.anomalies
+	activeServer = vs
+}
+
+// forEachServer selects every online virtual server that has an AFK channel in turn and runs fn on it.
+func forEachServer(client *ts3.Client, config Config, fn func()) {
+	servers, err := listServers(client)
+	if err != nil {
+		zap.S().Errorf("Error getting server list: %v", err)
+		handleQueryError(client, config, "serverlist", err)
+		return
+	}
+
+	online := make(map[int]bool, len(servers))
+	for _, server := range servers {
+		if server.Status != "online" {
+			continue
+		}
+		online[server.ID] = true
+		vs, ok := virtualServers[server.ID]
+		if !ok {
+			vs = newVirtualServer(server.ID)
+			virtualServers[server.ID] = vs
+		}
+		vs.activate()
+
+		if err = client.Use(server.ID); err != nil {
+			zap.S().Errorf("Failed to select virtual server %d: %v", server.ID, err)
+			handleQueryError(client, config, "use", err)
+			continue
+		}
+		serverID, serverDown = server.ID, false
+		if !config.ObserveOnly {
+			if err = client.SetNick(config.Nickname); err != nil {
+				zap.S().Debugf("Failed to set nickname on virtual server %d: %v", server.ID, err)
+			}
+		}
+
+		// Channel notifications only arrive for one virtual server, so channels are always fetched.
+		channelList.invalidate()
+		channels, err := channelList.get(client)
+		if err != nil {
+			zap.S().Errorf("Error getting channel list of virtual server %d: %v", server.ID, err)
+			handleQueryError(client, config, "channellist", err)
+			continue
+		}
+		if newChannelTree(channels).byName(config.AfkChannelName) == nil {
+			if !vs.skipped {
+				zap.S().Infof("Skipping virtual server %d on port %d, it has no channel named %q", server.ID, server.Port, config.AfkChannelName)
+				vs.skipped = true
+			}
+			reportSweepProblem(healthNoAfkChannel, fmt.Sprintf("virtual server %d has no channel named %q", server.ID, config.AfkChannelName))
+			continue
+		}
+		vs.skipped = false
+		fn()
+		if clock.Now().Before(floodBackoffUntil) {
+			return
+		}
+	}
+
+	for id := range virtualServers {
+		if !online[id] {
+			delete(virtualServers, id)
+		}
+	}
+}
+
+// trackJoins records the channels clients joined since the last sweep, which the grace period needs when
+// join notifications are not available.
+func trackJoins(clients []*clientInfo, now time.Time) {
+	for _, c := range clients {
+		if previous, ok := previousClients[c.ID]; !ok || previous.ChannelID != c.ChannelID {
+			recentJoins[c.ChannelID] = now
+		}
+	}
+}
+
+// sweepServers sweeps the configured virtual server, or every virtual server with TS3_ALL_SERVERS.
+func sweepServers(client *ts3.Client, config Config) {
+	defer finishSweepHealth()
+	if !config.AllServers {
+		processClients(client, config)
+		return
+	}
+	forEachServer(client, config, func() {
+		processClients(client, config)
+	})
+}
+
+// massSweepServers runs a mass move on the configured virtual server, or on every virtual server with TS3_ALL_SERVERS.
+func massSweepServers(client *ts3.Client, config Config, req massSweepRequest) massSweepResult {
+	if !config.AllServers {
+		return runMassSweep(client, config, req)
+	}
+	var total massSweepResult
+	forEachServer(client, config, func() {
+		result := runMassSweep(client, config, req)
+		total.ThresholdSec = result.ThresholdSec
+		total.Candidates += result.Candidates
+		total.Moved += result.Moved
+		total.NeedsConfirmation = total.NeedsConfirmation || result.NeedsConfirmation
+		if result.Error != "" {
+			total.Error = result.Error
+		}
+	})
+	return total
+}
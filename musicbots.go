@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// talkingStreaks counts, per client ID, how many consecutive sweeps saw the client talking.
+var talkingStreaks = make(map[int]int)
+
+// musicBots holds the unique identifiers of the clients detected as music bots, which are exempt until the bot restarts.
+var musicBots = make(map[string]bool)
+
+// trackMusicBots counts the sweeps each client has been talking in a row for TS3_MUSIC_BOT_DETECTION.
+func (s *sweep) trackMusicBots() {
+	if !s.config.MusicBotDetection {
+		return
+	}
+	streaks := make(map[int]int, len(talkingStreaks))
+	for _, c := range s.clients {
+		if c.Talking {
+			streaks[c.ID] = talkingStreaks[c.ID] + 1
+		}
+	}
+	talkingStreaks = streaks
+}
+
+// musicBotCandidate reports whether c has been talking for TS3_MUSIC_BOT_SWEEPS sweeps in a row and is not known as a music bot yet,
+// so its idle time has to be looked at.
+func (s *sweep) musicBotCandidate(c *clientInfo) bool {
+	return s.config.MusicBotDetection && talkingStreaks[c.ID] >= s.config.MusicBotSweeps && !musicBots[c.UniqueIdentifier]
+}
+
+// detectMusicBot reports whether c is a music bot: people who talk are not idle, so a client that keeps
+// transmitting while its idle time grows past TS3_MUSIC_BOT_IDLE_SEC plays audio by itself.
+// Newly detected bots are logged, published and reported to the admins.
+func (s *sweep) detectMusicBot(c *clientInfo, details *clientDetails) bool {
+	if !s.musicBotCandidate(c) || time.Duration(details.IdleTimeMs)*time.Millisecond < s.config.MusicBotIdle {
+		return false
+	}
+	musicBots[c.UniqueIdentifier] = true
+	msg := fmt.Sprintf("Detected a music bot, talking in %d sweeps in a row while idle for %s; it is exempt until the bot restarts",
+		talkingStreaks[c.ID], shortDuration(time.Duration(details.IdleTimeMs)*time.Millisecond))
+	zap.S().Infof("User %s: %s", c.logName(), msg)
+	publishClientEvent("musicbot", c, msg)
+	metrics.count("musicbots.detected", 1)
+	notifyAdmins(s.client, s.config, fmt.Sprintf("AFK bot: %s - %s. Add it to an exemption set to keep it exempt.", c.logName(), msg))
+	return true
+}
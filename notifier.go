@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Retries of a failed notification: notifyRetries more attempts, waiting notifyBackoff, then twice as long, and so on.
+const (
+	notifyRetries = 3
+	notifyBackoff = 2 * time.Second
+)
+
+// notifyErrorBodyLimit bounds how much of an error response ends up in the log.
+const notifyErrorBodyLimit = 512
+
+// Notifier delivers bot events to a chat service or webhook. Fan-out, filtering by event type,
+// rate limiting, batching and retries are shared by all notifiers, see attachNotifier.
+type Notifier interface {
+	// Name identifies the notifier in logs and metrics, e.g. "discord".
+	Name() string
+	// Send delivers events, oldest first, in one message where the service allows it.
+	// Failed sends are retried with the same events unless the error is permanent.
+	Send(events []botEvent) error
+}
+
+// notifyOptions controls how events are handed to a Notifier.
+type notifyOptions struct {
+	// types are the event types delivered, all if empty.
+	types []string
+	// perMinute is the number of sends per minute the notifier is limited to.
+	perMinute int
+	// maxBatch is the number of events one send may carry; events arriving while the rate limit
+	// holds back a send are batched up to this many.
+	maxBatch int
+	// moveWindow is how long move events are collected into one, 0 if every move is sent on its own.
+	moveWindow time.Duration
+	// maxMoves is the number of moves one combined move event lists at most.
+	maxMoves int
+	// buffer is the number of undelivered events kept while the notifier fails, the oldest are dropped beyond it.
+	buffer int
+}
+
+// attachNotifier delivers events to n in the background. Events that could not be delivered are kept,
+// up to options.buffer of them, and sent again every subsystemRetryInterval until the service takes them.
+func (h *eventHub) attachNotifier(n Notifier, options notifyOptions) {
+	var ch <-chan botEvent = h.subscribe(n.Name()).ch
+	if options.moveWindow > 0 {
+		ch = batchMoveEvents(ch, options.moveWindow, options.maxMoves)
+	}
+	interval := time.Minute / time.Duration(options.perMinute)
+	go func() {
+		var next time.Time
+		var queued []botEvent
+		var retry <-chan time.Time
+		dropped := 0
+		enqueue := func(event botEvent) {
+			if !wantsEvent(options.types, event) {
+				return
+			}
+			queued = append(queued, event)
+			if len(queued) > options.buffer {
+				queued = queued[1:]
+				dropped++
+				metrics.count("notifications.dropped", 1, "sink:"+n.Name())
+			}
+		}
+		for {
+			// Wait for an event, or for the retry while the notifier is failing.
+			if len(queued) == 0 || retry != nil {
+				select {
+				case event, ok := <-ch:
+					if !ok {
+						return
+					}
+					enqueue(event)
+					subsystems.buffer(n.Name(), len(queued), dropped)
+					continue
+				case <-retry:
+					retry = nil
+				}
+			}
+			time.Sleep(time.Until(next))
+		collect:
+			for len(queued) < options.maxBatch {
+				select {
+				case event := <-ch:
+					enqueue(event)
+				default:
+					break collect
+				}
+			}
+			batch := queued
+			if len(batch) > options.maxBatch {
+				batch = batch[:options.maxBatch]
+			}
+			err := sendNotification(n, batch)
+			next = time.Now().Add(interval)
+			var permanent permanentError
+			if err != nil {
+				subsystems.degrade(n.Name(), err)
+				if !errors.As(err, &permanent) {
+					subsystems.buffer(n.Name(), len(queued), dropped)
+					retry = time.After(subsystemRetryInterval)
+					continue
+				}
+			}
+			// Events a service rejects for good would be rejected again, so they are dropped.
+			queued = queued[len(batch):]
+			if err == nil && len(queued) == 0 {
+				dropped = 0
+				subsystems.recover(n.Name())
+			}
+		}
+	}()
+}
+
+// batchMoveEvents combines the move events arriving within window after the first one into a single event
+// listing the moved clients, so a large sweep does not flood a channel with one message per user.
+// A combined event is sent early once it lists max clients. Other events pass through right away.
+func batchMoveEvents(in <-chan botEvent, window time.Duration, max int) <-chan botEvent {
+	out := make(chan botEvent, eventSubscriberBuffer)
+	go func() {
+		defer close(out)
+		var moves []botEvent
+		var timeout <-chan time.Time
+		flush := func() {
+			if len(moves) > 0 {
+				out <- combineMoveEvents(moves)
+			}
+			moves, timeout = nil, nil
+		}
+		for {
+			select {
+			case event, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if event.Type != "move" {
+					out <- event
+					continue
+				}
+				moves = append(moves, event)
+				if len(moves) == 1 {
+					timeout = time.After(window)
+				}
+				if len(moves) >= max {
+					flush()
+				}
+			case <-timeout:
+				flush()
+			}
+		}
+	}()
+	return out
+}
+
+// combineMoveEvents turns several move events into one. The reason is kept if all moves share it.
+func combineMoveEvents(moves []botEvent) botEvent {
+	if len(moves) == 1 {
+		return moves[0]
+	}
+	nicknames := make([]string, len(moves))
+	reason := moves[0].Reason
+	for i, move := range moves {
+		nicknames[i] = move.Nickname
+		if move.Reason != reason {
+			reason = ""
+		}
+	}
+	return botEvent{
+		Time:      moves[len(moves)-1].Time,
+		Type:      "move",
+		Message:   fmt.Sprintf("Moved %d users: %s", len(moves), strings.Join(nicknames, ", ")),
+		Reason:    reason,
+		Nicknames: nicknames,
+	}
+}
+
+func wantsEvent(types []string, event botEvent) bool {
+	return len(types) == 0 || containsString(types, event.Type)
+}
+
+// sendNotification sends batch, retrying with exponential backoff, and counts the outcome.
+// It returns the last error if all attempts failed.
+func sendNotification(n Notifier, batch []botEvent) error {
+	backoff := notifyBackoff
+	for attempt := 0; ; attempt++ {
+		err := n.Send(batch)
+		if err == nil {
+			metrics.count("notifications.sent", int64(len(batch)), "sink:"+n.Name())
+			return nil
+		}
+		var permanent permanentError
+		if attempt == notifyRetries || errors.As(err, &permanent) {
+			zap.S().Errorf("Failed to send %d events to %s: %v", len(batch), n.Name(), err)
+			metrics.count("notifications.failed", int64(len(batch)), "sink:"+n.Name())
+			return err
+		}
+		zap.S().Warnf("Failed to send events to %s, retrying in %s: %v", n.Name(), backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// permanentError is a failed send that fails the same way when retried, e.g. a rejected token.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// postJSON sends body to url and returns the response body. Errors never contain the URL, which
+// often holds credentials. Client errors other than 429 Too Many Requests are permanent.
+func postJSON(client *http.Client, method string, url string, body []byte, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, permanentError{redactURLError(err)}
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, redactURLError(err)
+	}
+	defer res.Body.Close()
+	response, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		if len(response) > notifyErrorBodyLimit {
+			response = response[:notifyErrorBodyLimit]
+		}
+		err = fmt.Errorf("server answered %s: %s", res.Status, strings.TrimSpace(string(response)))
+		if res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusTooManyRequests {
+			return nil, permanentError{err}
+		}
+		return nil, err
+	}
+	return response, nil
+}
+
+// eventLines formats events as one line each, for chat services. If the text would be longer than
+// limit characters, the oldest events are summarized.
+func eventLines(events []botEvent, limit int) string {
+	lines := make([]string, len(events))
+	for i, event := range events {
+		lines[i] = event.text()
+	}
+	text := strings.Join(lines, "\n")
+	for dropped := 1; len(text) > limit && dropped < len(lines); dropped++ {
+		text = fmt.Sprintf("(%d earlier events left out)\n%s", dropped, strings.Join(lines[dropped:], "\n"))
+	}
+	if len(text) > limit {
+		text = text[:limit]
+	}
+	return text
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// observeOnly is set with TS3_OBSERVE_ONLY, the bot then changes nothing on the server.
+var observeOnly bool
+
+// errObserveOnly is returned for commands that would change something on the server in observer mode.
+var errObserveOnly = errors.New("not sent in observer mode")
+
+// silentCommands are the commands observer mode drops without an error. They only show something to
+// clients, so their callers can go on as if nobody had been online to see it.
+var silentCommands = map[string]bool{"sendtextmessage": true, "clientpoke": true}
+
+// writeCommands are the commands that change something on the server; observer mode refuses them.
+var writeCommands = map[string]bool{"clientmove": true, "clientupdate": true, "clientkick": true, "clientedit": true, "channeledit": true}
+
+// observeCommand reports whether observer mode keeps cmd from being sent, and the error its caller gets then.
+func observeCommand(cmd string) (bool, error) {
+	if !observeOnly {
+		return false, nil
+	}
+	name, _, _ := strings.Cut(cmd, " ")
+	switch {
+	case silentCommands[name]:
+		zap.S().Debugf("Observer mode, not sent: %s", name)
+		return true, nil
+	case writeCommands[name]:
+		return true, errObserveOnly
+	}
+	return false, nil
+}
+
+// observe records that c would be moved into the target channel if the bot was not in observer mode.
+func (s *sweep) observe(c *clientInfo, status clientStatus, targetChannelId int) clientStatus {
+	logClientDeduped(c, "Observer mode: would move user %s to afk channel [%d] after %d seconds idle, reason %s", c.logName(), targetChannelId, status.IdleTimeMs/1000, status.Reason)
+	metrics.count("moves.observed", 1, "reason:"+status.Reason)
+	if status.targetChannelID == 0 {
+		// Moves held back by a dry run were counted when they were planned.
+		s.countAfkMove(targetChannelId)
+	}
+	status.Status = statusObserved
+	status.targetChannelID = targetChannelId
+	return status
+}
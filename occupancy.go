@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// channelVisit is the channel a client was seen in and since when.
+type channelVisit struct {
+	channelID int
+	uid       string
+	since     time.Time
+}
+
+// channelVisits holds the channel each client was in during the last sweep, keyed by client ID.
+var channelVisits = make(map[int]channelVisit)
+
+// channelOccupancy aggregates how many clients a channel has and how long they stay in it.
+type channelOccupancy struct {
+	ServerID       int     `json:"server_id"`
+	Channel        string  `json:"channel"`
+	AFK            bool    `json:"afk"`
+	Clients        int     `json:"clients"`
+	Stays          int64   `json:"stays"`
+	TotalStaySec   float64 `json:"total_stay_sec"`
+	AverageStaySec float64 `json:"average_stay_sec"`
+	LongestStaySec float64 `json:"longest_stay_sec"`
+}
+
+// occupancyKey identifies a channel across restarts of the bot and recreations of the channel.
+type occupancyKey struct {
+	serverID int
+	channel  string
+}
+
+var (
+	occupancyMu sync.Mutex
+	occupancy   = make(map[occupancyKey]*channelOccupancy)
+)
+
+// trackOccupancy records which channel each client of the sweep is in, ends the stays of clients
+// that switched channels or left, and emits the number of clients per channel.
+// Stays are measured between sweeps, so they are only as precise as the sweep interval.
+func (s *sweep) trackOccupancy() {
+	seen := make(map[int]bool, len(s.clients))
+	perChannel := make(map[int]int)
+	for _, c := range s.clients {
+		if c.Type != 0 {
+			// ServerQuery clients such as the bot itself.
+			continue
+		}
+		seen[c.ID] = true
+		perChannel[c.ChannelID]++
+		visit, ok := channelVisits[c.ID]
+		if ok && visit.channelID == c.ChannelID && visit.uid == c.UniqueIdentifier {
+			continue
+		}
+		if ok {
+			s.endVisit(visit)
+		}
+		channelVisits[c.ID] = channelVisit{channelID: c.ChannelID, uid: c.UniqueIdentifier, since: s.now}
+	}
+	for id, visit := range channelVisits {
+		if !seen[id] {
+			s.endVisit(visit)
+			delete(channelVisits, id)
+		}
+	}
+
+	occupancyMu.Lock()
+	defer occupancyMu.Unlock()
+	for key, entry := range occupancy {
+		if key.serverID == serverID {
+			entry.Clients = 0
+		}
+	}
+	for _, channel := range s.tree.channels {
+		if channel.isSpacer() {
+			continue
+		}
+		clients := perChannel[channel.ID]
+		afk := s.isAfkChannel(channel.ID)
+		entry := occupancyEntry(channel.ChannelName, afk)
+		entry.Clients = clients
+		metrics.gauge("channel.clients", float64(clients), "channel:"+channel.ChannelName, "afk:"+strconv.FormatBool(afk))
+	}
+	s.snapshotOccupancy(perChannel)
+	afkClients := 0
+	for _, channel := range s.afkChannels {
+		afkClients += perChannel[channel.id]
+	}
+	metrics.gauge("clients.afk", float64(afkClients))
+}
+
+// endVisit records a stay that ended with this sweep.
+func (s *sweep) endVisit(visit channelVisit) {
+	name := "(deleted)"
+	if channel, ok := s.tree.byID[visit.channelID]; ok {
+		name = channel.ChannelName
+	}
+	afk := s.isAfkChannel(visit.channelID)
+	stay := s.now.Sub(visit.since)
+	metrics.timing("channel.stay", stay, "channel:"+name, "afk:"+strconv.FormatBool(afk))
+
+	occupancyMu.Lock()
+	defer occupancyMu.Unlock()
+	entry := occupancyEntry(name, afk)
+	entry.Stays++
+	entry.TotalStaySec += stay.Seconds()
+	entry.AverageStaySec = entry.TotalStaySec / float64(entry.Stays)
+	if stay.Seconds() > entry.LongestStaySec {
+		entry.LongestStaySec = stay.Seconds()
+	}
+}
+
+// occupancyEntry returns the aggregate of the named channel on the active virtual server.
+// occupancyMu must be held.
+func occupancyEntry(channel string, afk bool) *channelOccupancy {
+	key := occupancyKey{serverID: serverID, channel: channel}
+	entry, ok := occupancy[key]
+	if !ok {
+		entry = &channelOccupancy{ServerID: serverID, Channel: channel}
+		occupancy[key] = entry
+	}
+	entry.AFK = afk
+	return entry
+}
+
+// currentOccupancy returns the aggregates of all channels ordered by server and channel name.
+func currentOccupancy() []channelOccupancy {
+	occupancyMu.Lock()
+	list := make([]channelOccupancy, 0, len(occupancy))
+	for _, entry := range occupancy {
+		list = append(list, *entry)
+	}
+	occupancyMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ServerID != list[j].ServerID {
+			return list[i].ServerID < list[j].ServerID
+		}
+		return list[i].Channel < list[j].Channel
+	})
+	return list
+}
+
+// handleOccupancy serves GET /occupancy.
+func handleOccupancy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, currentOccupancy())
+}
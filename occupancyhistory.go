@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/csv"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// occupancyDefaultDays is how far back GET /stats/occupancy looks without a days parameter.
+const occupancyDefaultDays = 7
+
+// occupancySnapshotAt holds when occupancy was last stored, per virtual server ID.
+var occupancySnapshotAt = make(map[int]time.Time)
+
+// snapshotOccupancy stores the number of clients per channel every TS3_OCCUPANCY_SNAPSHOT_SEC
+// and drops snapshots older than TS3_OCCUPANCY_RETENTION_DAYS.
+func (s *sweep) snapshotOccupancy(perChannel map[int]int) {
+	interval := s.config.OccupancySnapshot
+	if interval <= 0 || s.now.Sub(occupancySnapshotAt[serverID]) < interval {
+		return
+	}
+	occupancySnapshotAt[serverID] = s.now
+
+	snapshots := make([]OccupancySnapshot, 0, len(s.tree.channels))
+	for _, channel := range s.tree.channels {
+		if channel.isSpacer() {
+			continue
+		}
+		snapshots = append(snapshots, OccupancySnapshot{
+			ServerID: serverID,
+			Channel:  channel.ChannelName,
+			AFK:      s.isAfkChannel(channel.ID),
+			Clients:  perChannel[channel.ID],
+			TakenAt:  s.now,
+		})
+	}
+	if err := storage.RecordOccupancy(snapshots); err != nil {
+		zap.S().Errorf("Failed to record channel occupancy: %v", err)
+		return
+	}
+	if err := storage.DeleteOccupancy(s.now.Add(-s.config.OccupancyRetention)); err != nil {
+		zap.S().Errorf("Failed to delete old channel occupancy: %v", err)
+	}
+}
+
+// occupancyHour aggregates the snapshots of a channel taken within one hour.
+type occupancyHour struct {
+	ServerID       int       `json:"server_id"`
+	Channel        string    `json:"channel"`
+	AFK            bool      `json:"afk"`
+	Hour           time.Time `json:"hour"`
+	Samples        int       `json:"samples"`
+	AverageClients float64   `json:"average_clients"`
+	MaxClients     int       `json:"max_clients"`
+}
+
+// hourlyOccupancy aggregates snapshots per channel and hour in the given location,
+// ordered by hour, server and channel.
+func hourlyOccupancy(snapshots []OccupancySnapshot, location *time.Location) []occupancyHour {
+	type hourKey struct {
+		serverID int
+		channel  string
+		hour     time.Time
+	}
+	hours := make(map[hourKey]*occupancyHour)
+	for _, snapshot := range snapshots {
+		t := snapshot.TakenAt.In(location)
+		key := hourKey{snapshot.ServerID, snapshot.Channel, time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, location)}
+		entry, ok := hours[key]
+		if !ok {
+			entry = &occupancyHour{ServerID: key.serverID, Channel: key.channel, Hour: key.hour}
+			hours[key] = entry
+		}
+		entry.AFK = snapshot.AFK
+		entry.AverageClients = (entry.AverageClients*float64(entry.Samples) + float64(snapshot.Clients)) / float64(entry.Samples+1)
+		entry.Samples++
+		if snapshot.Clients > entry.MaxClients {
+			entry.MaxClients = snapshot.Clients
+		}
+	}
+
+	list := make([]occupancyHour, 0, len(hours))
+	for _, entry := range hours {
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Hour.Equal(list[j].Hour) {
+			return list[i].Hour.Before(list[j].Hour)
+		}
+		if list[i].ServerID != list[j].ServerID {
+			return list[i].ServerID < list[j].ServerID
+		}
+		return list[i].Channel < list[j].Channel
+	})
+	return list
+}
+
+// handleOccupancyStats serves GET /stats/occupancy with the hourly aggregates of the last days,
+// as JSON or, with format=csv, as CSV.
+func handleOccupancyStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	days := occupancyDefaultDays
+	if value := query.Get("days"); value != "" {
+		var err error
+		if days, err = strconv.Atoi(value); err != nil || days < 1 {
+			writeError(w, http.StatusBadRequest, "days must be a positive number")
+			return
+		}
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
+	config := currentConfig()
+	snapshots, err := storage.OccupancyHistory(clock.Now().AddDate(0, 0, -days))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if channel := query.Get("channel"); channel != "" {
+		filtered := snapshots[:0]
+		for _, snapshot := range snapshots {
+			if snapshot.Channel == channel {
+				filtered = append(filtered, snapshot)
+			}
+		}
+		snapshots = filtered
+	}
+	hours := hourlyOccupancy(snapshots, config.Location)
+
+	if format != "csv" {
+		writeJSON(w, http.StatusOK, hours)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="occupancy.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"hour", "server_id", "channel", "afk", "samples", "average_clients", "max_clients"})
+	for _, hour := range hours {
+		out.Write([]string{
+			hour.Hour.Format(time.RFC3339),
+			strconv.Itoa(hour.ServerID),
+			hour.Channel,
+			strconv.FormatBool(hour.AFK),
+			strconv.Itoa(hour.Samples),
+			strconv.FormatFloat(hour.AverageClients, 'f', 2, 64),
+			strconv.Itoa(hour.MaxClients),
+		})
+	}
+	out.Flush()
+	if err = out.Error(); err != nil {
+		zap.S().Warnf("Failed to write API response: %v", err)
+	}
+}
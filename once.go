@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"sort"
+	"strings"
+	"time"
+)
+
+var onceFlag = flag.Bool("once", false, "run a single sweep, print a summary and exit, e.g. from cron")
+
+// onceDrainTimeout bounds how long --once waits for the event outputs before exiting.
+const onceDrainTimeout = 5 * time.Second
+
+// onceSweeps collects the snapshots of the sweeps a --once run made, one per virtual server.
+var onceSweeps []sweepSnapshot
+
+// onceSummary is the result of a --once run, printed as text or, with --output=json, in this schema.
+type onceSummary struct {
+	Servers int `json:"servers"`
+	Clients int `json:"clients"`
+	Moved   int `json:"moved"`
+	// Statuses counts the clients by the status the sweep gave them, e.g. "active" or "moved".
+	Statuses map[string]int `json:"statuses"`
+	// Complete is false if a virtual server was not swept or a client could not be checked or moved.
+	Complete bool `json:"complete"`
+	ExitCode int  `json:"exit_code"`
+}
+
+// runOnce sweeps once, prints a summary to stdout and returns the exit code: 0 if every virtual server was
+// swept without errors, exitIncomplete if a sweep was skipped or a client could not be checked or moved.
+func runOnce(client *ts3.Client, config Config) int {
+	sweepServers(client, config)
+	events.drain(onceDrainTimeout)
+
+	summary := onceSummary{Servers: len(onceSweeps), Statuses: make(map[string]int)}
+	for _, snapshot := range onceSweeps {
+		summary.Clients += len(snapshot.Clients)
+		for _, status := range snapshot.Clients {
+			summary.Statuses[status.Status]++
+		}
+	}
+	summary.Moved = summary.Statuses[statusMoved]
+	expected := 1
+	if config.AllServers {
+		// Every online virtual server, unless listing them failed.
+		expected = len(virtualServers)
+	}
+	summary.Complete = summary.Servers > 0 && summary.Servers >= expected && summary.Statuses[statusError] == 0
+	if !summary.Complete {
+		summary.ExitCode = exitIncomplete
+	}
+
+	if *outputFlag == outputJSON {
+		printJSON(summary)
+		return summary.ExitCode
+	}
+	statuses := make([]string, 0, len(summary.Statuses))
+	for status := range summary.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	parts := make([]string, len(statuses))
+	for i, status := range statuses {
+		parts[i] = fmt.Sprintf("%s %d", status, summary.Statuses[status])
+	}
+	fmt.Printf("Swept %d virtual servers: %d clients, %d moved", summary.Servers, summary.Clients, summary.Moved)
+	if len(parts) > 0 {
+		fmt.Printf(" (%s)", strings.Join(parts, ", "))
+	}
+	fmt.Println()
+	if !summary.Complete {
+		fmt.Println("The sweep is incomplete, see the log")
+	}
+	return summary.ExitCode
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Output formats of --output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var outputFlag = flag.String("output", outputText, "output format of --once, clients, channels and validate: text or json")
+
+// printJSON writes v to stdout as indented JSON, the format of --output=json.
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write output:", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ClientOverride adjusts the AFK rules for a single client, identified by its unique identifier.
+type ClientOverride struct {
+	// MaxIdleTimeSec replaces the global idle threshold if positive.
+	MaxIdleTimeSec int `json:"max_idle_time_sec,omitempty"`
+	// Exempt clients are never moved.
+	Exempt bool `json:"exempt,omitempty"`
+	// TargetChannel is the name of the channel the client is moved to instead of the AFK channel.
+	TargetChannel string `json:"target_channel,omitempty"`
+	// Notify is how the client is warned before a move, poke, msg or none. Clients set it with !notify.
+	Notify string `json:"notify,omitempty"`
+	// Language is the language code of messages to the client. Clients set it with !language.
+	Language string `json:"language,omitempty"`
+}
+
+// overrideStore holds the per-client overrides.
+// It is shared between the mover loop and the admin API and therefore guarded by a mutex.
+type overrideStore struct {
+	mu        sync.RWMutex
+	path      string
+	overrides map[string]ClientOverride
+	// file holds the overrides read from the overrides file, which those from the storage are merged into.
+	file map[string]ClientOverride
+}
+
+var clientOverrides = &overrideStore{overrides: make(map[string]ClientOverride)}
+
+// loadOverrides reads the overrides from path and the storage backend, the latter taking precedence.
+// A missing file is treated as empty, it is created on the first change made through the admin API.
+func loadOverrides(path string) (*overrideStore, error) {
+	store := &overrideStore{path: path, overrides: make(map[string]ClientOverride)}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			if err = json.Unmarshal(data, &store.overrides); err != nil {
+				return nil, fmt.Errorf("%s is not a valid overrides file: %v", path, err)
+			}
+		}
+	}
+	store.file = make(map[string]ClientOverride, len(store.overrides))
+	for uid, override := range store.overrides {
+		store.file[uid] = override
+	}
+
+	stored, err := storage.Overrides()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load overrides from storage: %v", err)
+	}
+	for uid, override := range stored {
+		store.overrides[uid] = override
+	}
+	return store, nil
+}
+
+func (s *overrideStore) get(uid string) (ClientOverride, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	override, ok := s.overrides[uid]
+	return override, ok
+}
+
+func (s *overrideStore) all() map[string]ClientOverride {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make(map[string]ClientOverride, len(s.overrides))
+	for uid, override := range s.overrides {
+		all[uid] = override
+	}
+	return all
+}
+
+func (s *overrideStore) set(uid string, override ClientOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[uid] = override
+	if err := storage.SaveOverride(uid, override); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *overrideStore) delete(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.overrides, uid)
+	delete(s.file, uid)
+	if err := storage.DeleteOverride(uid); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// refresh merges the overrides from the storage into those from the file again,
+// picking up changes other instances made to a shared storage.
+func (s *overrideStore) refresh() error {
+	stored, err := storage.Overrides()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	overrides := make(map[string]ClientOverride, len(s.file)+len(stored))
+	for uid, override := range s.file {
+		overrides[uid] = override
+	}
+	for uid, override := range stored {
+		overrides[uid] = override
+	}
+	s.overrides = overrides
+	return nil
+}
+
+// save writes the overrides back to the overrides file so runtime changes survive a restart.
+// The caller must hold the write lock.
+func (s *overrideStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".overrides-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// pauseState is the runtime switch that suspends all moves, e.g. for an event night.
+type pauseState struct {
+	mu     sync.Mutex
+	paused bool
+	until  time.Time
+	reason string
+
+	// defaultDuration is used when a pause is requested without a duration.
+	defaultDuration time.Duration
+
+	// changed is signalled whenever the bot is paused or resumed, so the main loop can announce it.
+	changed chan struct{}
+}
+
+// pauseStatus is a snapshot of the pause switch.
+type pauseStatus struct {
+	Paused bool       `json:"paused"`
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+var pause = &pauseState{changed: make(chan struct{}, 1)}
+
+// announcedPause is the pause status last announced in the server chat.
+var announcedPause pauseStatus
+
+// set pauses the bot for d, or until it is resumed if d is 0.
+func (p *pauseState) set(d time.Duration, reason string) pauseStatus {
+	p.mu.Lock()
+	p.paused = true
+	p.reason = reason
+	p.until = time.Time{}
+	if d > 0 {
+		p.until = clock.Now().Add(d)
+	}
+	p.mu.Unlock()
+	p.notify()
+	return p.status(clock.Now())
+}
+
+// clear resumes the bot and reports whether it was paused.
+func (p *pauseState) clear() bool {
+	p.mu.Lock()
+	wasPaused := p.paused
+	p.paused = false
+	p.mu.Unlock()
+	if wasPaused {
+		p.notify()
+	}
+	return wasPaused
+}
+
+// status returns the pause switch at now, resuming the bot if the pause has run out.
+func (p *pauseState) status(now time.Time) pauseStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused && !p.until.IsZero() && !now.Before(p.until) {
+		message := fmt.Sprintf("Pause ran out at %s, resuming moves", p.until.Format(time.RFC3339))
+		zap.S().Info(message)
+		events.publish(botEvent{Type: "resume", Message: message})
+		p.paused = false
+	}
+	if !p.paused {
+		return pauseStatus{}
+	}
+	status := pauseStatus{Paused: true, Reason: p.reason}
+	if !p.until.IsZero() {
+		until := p.until
+		status.Until = &until
+	}
+	return status
+}
+
+func (p *pauseState) notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// pauseBot pauses moves and records who did it.
+func pauseBot(d time.Duration, reason string, source string) pauseStatus {
+	status := pause.set(d, reason)
+	message := fmt.Sprintf("Moves paused by %s", source)
+	if status.Until != nil {
+		message += fmt.Sprintf(" until %s", status.Until.Format(time.RFC3339))
+	}
+	if reason != "" {
+		message += fmt.Sprintf(": %s", reason)
+	}
+	zap.S().Info(message)
+	events.publish(botEvent{Type: "pause", Message: message})
+	return status
+}
+
+// resumeBot resumes moves and reports whether the bot was paused.
+func resumeBot(source string) bool {
+	if !pause.clear() {
+		return false
+	}
+	message := fmt.Sprintf("Moves resumed by %s", source)
+	zap.S().Info(message)
+	events.publish(botEvent{Type: "resume", Message: message})
+	return true
+}
+
+// announcePause posts in the server chat when the bot was paused or resumed since the last announcement.
+func announcePause(client *ts3.Client, config Config) {
+	status := pause.status(clock.Now())
+	if status.Paused == announcedPause.Paused && status.Reason == announcedPause.Reason {
+		return
+	}
+	announcedPause = status
+	if !config.PauseAnnounce {
+		return
+	}
+
+	msg := "AFK bot resumed"
+	if status.Paused {
+		msg = "AFK bot paused"
+		if status.Reason != "" {
+			msg += " for " + status.Reason
+		}
+		if status.Until != nil {
+			msg += " until " + status.Until.In(config.Location).Format("15:04")
+		}
+	}
+	if err := sendServerMessage(client, serverID, msg); err != nil {
+		zap.S().Errorf("Failed to announce pause: %v", err)
+	}
+}
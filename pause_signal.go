@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchPauseSignal toggles the pause switch whenever the process receives SIGUSR2.
+func watchPauseSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	go func() {
+		for range signals {
+			if !resumeBot("SIGUSR2") {
+				pauseBot(pause.defaultDuration, "", "SIGUSR2")
+			}
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// watchPauseSignal does nothing on Windows, which has no SIGUSR2.
+func watchPauseSignal() {}
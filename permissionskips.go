@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+	"time"
+)
+
+// permissionDigestLimit is the number of clients the digest lists by name, so it fits into one chat message.
+const permissionDigestLimit = 10
+
+// permissionFailure is what the bot remembers about a client it could not move for lack of permissions.
+type permissionFailure struct {
+	nickname string
+	// failures counts the failed moves in a row, it is reset by a successful move.
+	failures int
+	// total counts the failed moves since the last digest.
+	total        int
+	reason       string
+	skippedUntil time.Time
+}
+
+// permissionFailures holds the clients moves failed for, keyed by unique identifier.
+var permissionFailures = make(map[string]*permissionFailure)
+
+// permissionDigests tells the main loop that the daily digest is due.
+var permissionDigests = make(chan struct{}, 1)
+
+// isPermissionError reports whether err means the query account may not do something, e.g. move a higher-ranked admin.
+func isPermissionError(err error) bool {
+	kind, _ := classifyQueryError(err)
+	return kind == queryErrorPermission
+}
+
+// permissionDenied counts a move of c that failed for lack of permissions. After TS3_PERMISSION_SKIP_AFTER
+// failures in a row the client is skipped for TS3_PERMISSION_SKIP_HOURS instead of failing every sweep.
+func (s *sweep) permissionDenied(c *clientInfo, err error) {
+	failure, ok := permissionFailures[c.UniqueIdentifier]
+	if !ok {
+		failure = &permissionFailure{}
+		permissionFailures[c.UniqueIdentifier] = failure
+	}
+	failure.nickname = c.logName()
+	failure.failures++
+	failure.total++
+	failure.reason = permissionReason(err)
+	if s.config.PermissionSkipAfter == 0 || failure.failures < s.config.PermissionSkipAfter {
+		return
+	}
+	failure.failures = 0
+	failure.skippedUntil = s.now.Add(s.config.PermissionSkip)
+	msg := fmt.Sprintf("Could not be moved %d times in a row (%s), skipped until %s", s.config.PermissionSkipAfter, failure.reason, failure.skippedUntil.In(s.config.Location).Format("2006-01-02 15:04"))
+	zap.S().Warnf("User %s: %s", c.logName(), msg)
+	publishClientEvent("error", c, msg)
+}
+
+// permissionGranted forgets the failed moves of a client that was moved after all.
+func permissionGranted(uid string) {
+	if failure, ok := permissionFailures[uid]; ok {
+		failure.failures = 0
+	}
+}
+
+// permissionSkipped reports whether moves of the client are skipped after repeated permission errors.
+func permissionSkipped(uid string, now time.Time) bool {
+	failure, ok := permissionFailures[uid]
+	return ok && now.Before(failure.skippedUntil)
+}
+
+// prunePermissionFailures forgets clients that are no longer skipped and have no failures in a row.
+// With the digest enabled, they are kept until it reported them.
+func prunePermissionFailures(now time.Time, digest bool) {
+	for uid, failure := range permissionFailures {
+		if failure.failures == 0 && !now.Before(failure.skippedUntil) && (!digest || failure.total == 0) {
+			delete(permissionFailures, uid)
+		}
+	}
+}
+
+// permissionReason describes the permission a failed query lacked.
+func permissionReason(err error) string {
+	_, tsErr := classifyQueryError(err)
+	if tsErr == nil {
+		return err.Error()
+	}
+	if permId, ok := tsErr.Details["failed_permid"].(int); ok {
+		return fmt.Sprintf("missing permission %d", permId)
+	}
+	return tsErr.Msg
+}
+
+// runPermissionDigest asks the main loop for the digest every day at the given minute after midnight.
+func runPermissionDigest(at int, location *time.Location) {
+	for {
+		time.Sleep(time.Until(nextDailyTime(time.Now().In(location), at)))
+		select {
+		case permissionDigests <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// permissionDigest lists the clients moves failed for since the last digest and starts counting anew.
+// It returns an empty string if there were none.
+func permissionDigest(now time.Time, location *time.Location) string {
+	var uids []string
+	for uid, failure := range permissionFailures {
+		if failure.total > 0 || now.Before(failure.skippedUntil) {
+			uids = append(uids, uid)
+		}
+	}
+	if len(uids) == 0 {
+		return ""
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		return permissionFailures[uids[i]].nickname < permissionFailures[uids[j]].nickname
+	})
+
+	lines := []string{fmt.Sprintf("AFK bot: %d users could not be moved since the last digest:", len(uids))}
+	for i, uid := range uids {
+		if i == permissionDigestLimit {
+			lines = append(lines, fmt.Sprintf("... and %d more", len(uids)-permissionDigestLimit))
+			break
+		}
+		failure := permissionFailures[uid]
+		line := fmt.Sprintf("%s: %d failed moves, %s", failure.nickname, failure.total, failure.reason)
+		if now.Before(failure.skippedUntil) {
+			line += ", skipped until " + failure.skippedUntil.In(location).Format("2006-01-02 15:04")
+		}
+		lines = append(lines, line)
+	}
+
+	for _, failure := range permissionFailures {
+		failure.total = 0
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sendPermissionDigest sends the digest to the admins on the server, if there is anything to report.
+func sendPermissionDigest(client *ts3.Client, config Config) {
+	msg := permissionDigest(clock.Now(), config.Location)
+	if msg == "" {
+		return
+	}
+	zap.S().Info(msg)
+	notifyAdmins(client, config, msg)
+}
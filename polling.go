@@ -0,0 +1,48 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"time"
+)
+
+// nextSweepInterval returns how long to wait before the next sweep after snapshot and logs it.
+func nextSweepInterval(config Config, snapshot sweepSnapshot) time.Duration {
+	next := sweepIntervalAfter(config, snapshot)
+	zap.S().Debugf("Next sweep in %v", next)
+	return next
+}
+
+// sweepIntervalAfter returns how long to wait before the next sweep after snapshot.
+// With adaptive polling the bot sleeps until the first client could reach its limit,
+// bounded by TS3_POLL_MIN_SEC and TS3_POLL_MAX_SEC, instead of polling at a fixed rate.
+// Scheduled checks are taken from the check queue, other active clients from the last sweep.
+func sweepIntervalAfter(config Config, snapshot sweepSnapshot) time.Duration {
+	if !config.AdaptivePolling {
+		return sweepInterval
+	}
+
+	next := config.PollMax
+	for _, c := range snapshot.Clients {
+		switch c.Status {
+		case statusConfirming, statusDeferred:
+			// Confirmation samples and deferred moves should follow quickly.
+			next = config.PollMin
+		case statusActive, statusScheduled:
+			if c.IdleTimeMs < 0 {
+				continue
+			}
+			if remaining := time.Duration(c.MaxIdleTimeMs-c.IdleTimeMs) * time.Millisecond; remaining < next {
+				next = remaining
+			}
+		}
+	}
+	if at, ok := checks.earliest(); ok {
+		if remaining := at.Sub(clock.Now()); remaining < next {
+			next = remaining
+		}
+	}
+	if next < config.PollMin {
+		next = config.PollMin
+	}
+	return next
+}
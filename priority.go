@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// priorityCommands are the chat commands run as soon as they arrive, even in the middle of a long sweep.
+// They may only touch state that is safe to use outside the main loop.
+var priorityCommands = map[string]bool{"!pause": true, "!resume": true}
+
+// routeNotifications reads the notifications of a connection on its own goroutine. Priority commands
+// are run right away, everything else is handed to the main loop in order. The returned channel is
+// closed when the connection's notifications end; stop ends the routing after a reconnect.
+func routeNotifications(client *ts3.Client) (routed <-chan ts3.Notification, stop func()) {
+	in := notifications(client)
+	out := make(chan ts3.Notification, notificationBufferSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(out)
+		for {
+			var n ts3.Notification
+			var ok bool
+			select {
+			case n, ok = <-in:
+				if !ok {
+					return
+				}
+			case <-done:
+				return
+			}
+			traffic.notification(n)
+			if queryTrace.Load() {
+				zap.S().Infof("[n] notify%s %v", n.Type, n.Data)
+			}
+			if isPriorityCommand(n) {
+				// The client list belongs to the main loop, so replies use the language chosen with
+				// !language or TS3_LANGUAGE without looking up the client's country.
+				handleTextMessage(client, runtimeSettings.apply(profiles.apply(currentConfig(), clock.Now())), n, "")
+				continue
+			}
+			select {
+			case out <- n:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out, func() { close(done) }
+}
+
+func isPriorityCommand(n ts3.Notification) bool {
+	if n.Type != "textmessage" {
+		return false
+	}
+	fields := strings.Fields(n.Data["msg"])
+	return len(fields) > 0 && priorityCommands[strings.ToLower(fields[0])]
+}
+
+// pausedMidSweep reports whether moves are paused, checking again if they were not when the sweep started,
+// so that a pause sent during a long sweep stops the moves still ahead. Mass moves ignore pauses.
+func (s *sweep) pausedMidSweep() bool {
+	if s.forcedThresholdMs > 0 {
+		return false
+	}
+	if !s.pause.Paused {
+		s.pause = pause.status(clock.Now())
+		if s.pause.Paused {
+			zap.S().Info("Moves were paused during the sweep, skipping the remaining moves")
+		}
+	}
+	return s.pause.Paused
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Privacy modes, see TS3_PRIVACY_MODE.
+const (
+	privacyOff      = "off"
+	privacyHash     = "hash"
+	privacyTruncate = "truncate"
+)
+
+// privacyFilter replaces client nicknames and unique identifiers in logs, events and stored history.
+// The runtime state in memory keeps the full identifiers, the bot needs them to work.
+type privacyFilter struct {
+	mode string
+	salt []byte
+}
+
+var privacy = privacyFilter{mode: privacyOff}
+
+// nickname returns what may be logged or stored of a nickname.
+func (p privacyFilter) nickname(nickname string) string {
+	switch p.mode {
+	case privacyHash:
+		return "user-" + p.hash(nickname)[:8]
+	case privacyTruncate:
+		return truncateIdentifier(nickname, 2)
+	default:
+		return nickname
+	}
+}
+
+// uid returns what may be logged or stored of a unique identifier.
+// Hashes are stable for a salt, so stored history of a client can still be correlated.
+func (p privacyFilter) uid(uid string) string {
+	switch p.mode {
+	case privacyHash:
+		return p.hash(uid)[:16]
+	case privacyTruncate:
+		return truncateIdentifier(uid, 6)
+	default:
+		return uid
+	}
+}
+
+func (p privacyFilter) hash(value string) string {
+	mac := hmac.New(sha256.New, p.salt)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// truncateIdentifier keeps the first n characters of value.
+func truncateIdentifier(value string, n int) string {
+	runes := []rune(value)
+	if len(runes) <= n {
+		return value
+	}
+	return string(runes[:n]) + "…"
+}
+
+// logName is the nickname of c as it may appear in logs and events.
+func (c *clientInfo) logName() string {
+	return privacy.nickname(c.Nickname)
+}
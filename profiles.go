@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// profileAuto selects the profile by TS3_PROFILE_SCHEDULE and TS3_PROFILE again after a manual switch.
+const profileAuto = "auto"
+
+// profileWindow activates a policy profile during a daily time range.
+type profileWindow struct {
+	profile string
+	window  dailyWindow
+}
+
+// policyProfiles tracks which named set of runtime settings from TS3_PROFILES applies.
+// Profiles sit between the environment and settings changed with !set.
+type policyProfiles struct {
+	mu sync.Mutex
+	// selected is the profile an admin switched to, empty while the schedule decides.
+	selected string
+	// active is the profile applied last, to log and publish switches.
+	active string
+}
+
+var profiles = &policyProfiles{}
+
+// parseProfiles reads TS3_PROFILES, a JSON object of profile names to runtime settings, e.g.
+// {"night": {"max_idle_time_sec": 300, "ignored_channels": ["Music"]}}. Every value is checked like !set does.
+func parseProfiles(value string) (map[string]map[string]string, error) {
+	var raw map[string]map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("not a JSON object of profiles: %v", err)
+	}
+	defined := make(map[string]map[string]string, len(raw))
+	for name, settings := range raw {
+		if name == "" || name == profileAuto {
+			return nil, fmt.Errorf("%q cannot be used as profile name", name)
+		}
+		defined[name] = make(map[string]string, len(settings))
+		for setting, rawValue := range settings {
+			definition, ok := runtimeSettingDefinitions[setting]
+			if !ok {
+				return nil, fmt.Errorf("profile %s: unknown setting %s", name, setting)
+			}
+			// Strings are taken as they are, numbers, booleans and arrays in their JSON form.
+			value := string(rawValue)
+			var text string
+			if json.Unmarshal(rawValue, &text) == nil {
+				value = text
+			}
+			canonical, err := definition.apply(&Config{}, value)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: invalid value for %s: %v", name, setting, err)
+			}
+			defined[name][setting] = canonical
+		}
+	}
+	return defined, nil
+}
+
+// current returns the profile that applies at now: the one an admin selected, the first scheduled one
+// whose window contains now, or TS3_PROFILE. It returns an empty name if none applies.
+func (p *policyProfiles) current(config Config, now time.Time) (string, bool) {
+	p.mu.Lock()
+	selected := p.selected
+	p.mu.Unlock()
+	if _, ok := config.Profiles[selected]; ok {
+		return selected, true
+	}
+	for _, scheduled := range config.ProfileSchedule {
+		if scheduled.window.contains(now.In(config.Location)) {
+			return scheduled.profile, false
+		}
+	}
+	return config.DefaultProfile, false
+}
+
+// apply returns config with the settings of the current profile applied, and logs and publishes switches.
+func (p *policyProfiles) apply(config Config, now time.Time) Config {
+	name, manual := p.current(config, now)
+	p.mu.Lock()
+	switched := name != p.active
+	p.active = name
+	p.mu.Unlock()
+	if switched {
+		how := "by schedule"
+		if manual {
+			how = "by an admin"
+		}
+		displayName := name
+		if name == "" {
+			displayName = "none"
+		}
+		zap.S().Infof("Policy profile %s is active, selected %s", displayName, how)
+		events.publish(botEvent{Type: "profile", Message: fmt.Sprintf("Policy profile %s is active, selected %s", displayName, how)})
+	}
+	return withProfile(withWeekend(config, now), name)
+}
+
+// withProfile returns config with the settings of the named profile applied.
+func withProfile(config Config, name string) Config {
+	for setting, value := range config.Profiles[name] {
+		// Values were validated when the configuration was loaded.
+		_, _ = runtimeSettingDefinitions[setting].apply(&config, value)
+	}
+	return config
+}
+
+// withCurrentProfile returns config with the settings of the profile that applies now, without
+// logging a switch, for checks outside the main loop.
+func withCurrentProfile(config Config) Config {
+	now := clock.Now()
+	name, _ := profiles.current(config, now)
+	return withProfile(withWeekend(config, now), name)
+}
+
+// selectProfile switches to the named profile until another one is selected, or back to the
+// schedule if name is "auto".
+func (p *policyProfiles) selectProfile(config Config, name string, source string) error {
+	if name == profileAuto {
+		name = ""
+	} else if _, ok := config.Profiles[name]; !ok {
+		return fmt.Errorf("unknown profile %q, use one of %s or %s", name, strings.Join(profileNames(config), ", "), profileAuto)
+	}
+	p.mu.Lock()
+	p.selected = name
+	p.mu.Unlock()
+	if name == "" {
+		zap.S().Infof("%s returned to the scheduled policy profile", source)
+	} else {
+		zap.S().Infof("%s selected policy profile %s", source, name)
+	}
+	return nil
+}
+
+// profileStatus is the body of GET /profile.
+type profileStatus struct {
+	Active   string   `json:"active"`
+	Manual   bool     `json:"manual"`
+	Profiles []string `json:"profiles"`
+}
+
+func (p *policyProfiles) status(config Config, now time.Time) profileStatus {
+	name, manual := p.current(config, now)
+	return profileStatus{Active: name, Manual: manual, Profiles: profileNames(config)}
+}
+
+// profileNames returns the names of the profiles in alphabetical order.
+func profileNames(config Config) []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleProfile serves GET /profile and PUT /profile, which switches profiles with a body like {"name": "event"}.
+func handleProfile(w http.ResponseWriter, r *http.Request) {
+	config := currentConfig()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Name string `json:"name"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid profile request: %v", err))
+			return
+		}
+		if err := profiles.selectProfile(config, body.Name, "API"); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, profiles.status(config, clock.Now()))
+}
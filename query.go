@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/multiplay/go-ts3"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queryMu serializes ServerQuery commands: go-ts3 matches responses to commands in order and mixes them up
+// if commands are sent concurrently, e.g. a reply to a priority command during a sweep.
+var queryMu sync.Mutex
+
+// execCmd runs cmd and records how long the server took to answer it, tagged with the command name.
+func execCmd(client *ts3.Client, cmd *ts3.Cmd) ([]string, error) {
+	if skip, err := observeCommand(cmd.String()); skip {
+		return nil, err
+	}
+	queryMu.Lock()
+	queryPace.wait()
+	trace := traceCommand(cmd.String())
+	start := time.Now()
+	var lines []string
+	err := chaos.inject(client, cmd.String())
+	if err == nil {
+		lines, err = client.ExecCmd(cmd)
+	}
+	took := time.Since(start)
+	queryMu.Unlock()
+	traceResponse(trace, lines, err, took)
+	name, _, _ := strings.Cut(cmd.String(), " ")
+	metrics.timing("query.duration", took, "cmd:"+name)
+	traffic.command(cmd.String(), lines, err)
+	return lines, err
+}
+
+// execQuery runs cmd and decodes its response into response.
+// Unlike ts3.Cmd.WithResponse it keeps the raw response, so the traffic recorder has it even if decoding fails.
+func execQuery(client *ts3.Client, cmd *ts3.Cmd, response interface{}) error {
+	lines, err := execCmd(client, cmd)
+	if err != nil {
+		return err
+	}
+	return ts3.DecodeResponse(lines, response)
+}
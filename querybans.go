@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queryErrBanned is the error a server greets a banned address with instead of the ServerQuery header.
+const queryErrBanned = 3329
+
+// defaultBanWait is how long the bot waits out a ban that does not say when it ends.
+const defaultBanWait = 10 * time.Minute
+
+// Bans are repeated if there were at least repeatedBans within repeatedBanWindow; they get the admins' attention.
+const (
+	repeatedBans      = 2
+	repeatedBanWindow = 24 * time.Hour
+)
+
+// banTimes are the times of the bans within repeatedBanWindow.
+var banTimes []time.Time
+
+// banAlertPending is set after a repeated ban until the admins were alerted on the next connection.
+var banAlertPending bool
+
+// Every ban or flood error doubles the time between ServerQuery commands, starting at minSlowdownInterval
+// and up to maxQueryInterval, so the bot stays below the server's flood limits afterwards.
+const (
+	minSlowdownInterval = 100 * time.Millisecond
+	maxQueryInterval    = 2 * time.Second
+)
+
+// queryPacer spaces out the ServerQuery commands of all connections.
+type queryPacer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+var queryPace = &queryPacer{}
+
+// wait blocks until the interval since the previous command has passed.
+func (p *queryPacer) wait() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.interval > 0 {
+		if d := p.interval - time.Since(p.last); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	p.last = time.Now()
+}
+
+// setInterval sets the time between commands, e.g. to TS3_QUERY_INTERVAL_MS on startup.
+func (p *queryPacer) setInterval(interval time.Duration) {
+	p.mu.Lock()
+	p.interval = interval
+	p.mu.Unlock()
+	metrics.gauge("query.interval_ms", float64(interval.Milliseconds()))
+}
+
+// slowDown doubles the time between commands after the server complained about their rate.
+func (p *queryPacer) slowDown() {
+	p.mu.Lock()
+	interval := p.interval * 2
+	if interval < minSlowdownInterval {
+		interval = minSlowdownInterval
+	}
+	if interval > maxQueryInterval {
+		interval = maxQueryInterval
+	}
+	changed := interval != p.interval
+	p.mu.Unlock()
+	if changed {
+		p.setInterval(interval)
+		zap.S().Warnf("Lowering the ServerQuery rate to one command every %v", interval)
+	}
+}
+
+// queryBan reports whether err means the server banned the bot's address, usually for flooding,
+// and how long the ban lasts if the server said so.
+func queryBan(err error) (time.Duration, bool) {
+	msg := err.Error()
+	var tsErr *ts3.Error
+	if errors.As(err, &tsErr) && (tsErr.ID == queryErrBanned || tsErr.ID == queryErrFloodBan) {
+		msg, _ = tsErr.Details["extra_msg"].(string)
+	} else if !strings.Contains(msg, "invalid connection header") || !strings.Contains(msg, fmt.Sprintf("error id=%d", queryErrBanned)) {
+		// A banned address gets the error as greeting, which go-ts3 reports as an invalid connection header,
+		// e.g. "error id=3329 msg=connection\sfailed,\syou\sare\sbanned extra_msg=you\smay\sretry\sin\s600\sseconds".
+		return 0, false
+	}
+	if wait, ok := retryAfter(strings.ReplaceAll(msg, `\s`, " ")); ok {
+		return wait, true
+	}
+	return defaultBanWait, true
+}
+
+// waitOutBan sleeps until a ban of the bot's address has ended, then lowers the query rate
+// so the ban is not triggered again.
+func waitOutBan(wait time.Duration, err error) {
+	until := time.Now().Add(wait)
+	health.set(healthBanned, "the server banned this address until "+until.Format(time.RFC3339))
+	zap.S().Errorf("The server banned this address, probably for flooding. Waiting %v until %s before connecting again; "+
+		"adding the bot's address to the server's query_ip_allowlist.txt prevents this: %v", wait, until.Format(time.RFC3339), err)
+	events.publish(botEvent{Type: "error", Message: fmt.Sprintf("ServerQuery ban, reconnecting at %s", until.Format(time.RFC3339))})
+	metrics.count("errors", 1, "op:ban")
+	recordBan(time.Now())
+	for d := time.Until(until); d > 0; d = time.Until(until) {
+		// Keep the systemd watchdog quiet, the bot is waiting on purpose.
+		watchdogPing()
+		if d > reconnectDelay {
+			d = reconnectDelay
+		}
+		time.Sleep(d)
+	}
+	zap.S().Infof("The ban should have ended, connecting again")
+	health.set(healthReconnecting, "the ban ended")
+	queryPace.slowDown()
+}
+
+// recordBan counts a ban and marks the admins for an alert if bans keep happening.
+func recordBan(now time.Time) {
+	recent := banTimes[:0]
+	for _, at := range banTimes {
+		if now.Sub(at) < repeatedBanWindow {
+			recent = append(recent, at)
+		}
+	}
+	banTimes = append(recent, now)
+	metrics.count("query.bans", 1)
+	if len(banTimes) >= repeatedBans {
+		banAlertPending = true
+	}
+}
+
+// alertRepeatedBans tells the admins, once connected again, how to keep the server from banning the bot:
+// in the log, as an error event for the webhooks and in a private message to the online admins.
+func alertRepeatedBans(client *ts3.Client, config Config, address string) {
+	if !banAlertPending {
+		return
+	}
+	banAlertPending = false
+	ip := sourceIP(client, address)
+	if ip == "" {
+		ip = "the bot's IP address"
+	}
+	msg := fmt.Sprintf("The server banned the bot for flooding %d times in the last %v. Add %s to query_ip_allowlist.txt "+
+		"(query_ip_whitelist.txt before server 3.13) in the server's directory and restart the server to stop this.",
+		len(banTimes), shortDuration(repeatedBanWindow), ip)
+	zap.S().Warn(msg)
+	events.publish(botEvent{Type: "error", Message: msg})
+	if !config.AllServers {
+		notifyAdmins(client, config, "AFK bot: "+msg)
+	}
+}
+
+// sourceIP returns the address the server sees the bot's connection from. If the server does not tell,
+// it falls back to the local address used to reach it, which is wrong behind NAT, or returns an empty string.
+func sourceIP(client *ts3.Client, address string) string {
+	var info struct {
+		IP string `ms:"connection_client_ip"`
+	}
+	if botClientID != 0 {
+		if err := execQuery(client, ts3.NewCmd("clientinfo").WithArgs(ts3.NewArg("clid", botClientID)), &info); err == nil && info.IP != "" {
+			return info.IP
+		}
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	return host
+}
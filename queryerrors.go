@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ServerQuery error codes the bot reacts to.
+const (
+	queryErrInvalidClientID        = 512
+	queryErrInvalidLogin           = 520
+	queryErrClientFlooding         = 524
+	queryErrInvalidChannelID       = 768
+	queryErrInvalidServerID        = 1024
+	queryErrServerShuttingDown     = 1026
+	queryErrServerNotRunning       = 1033
+	queryErrInsufficientPermission = 2568
+	queryErrFloodBan               = 3331
+)
+
+// defaultFloodBackoff is how long sweeps pause after a flood error that does not say when to retry.
+const defaultFloodBackoff = time.Minute
+
+// queryErrorKind groups ServerQuery errors by how the bot reacts to them.
+type queryErrorKind int
+
+const (
+	queryErrorOther queryErrorKind = iota
+	queryErrorFlood
+	queryErrorInvalidClient
+	queryErrorInvalidChannel
+	queryErrorServerDown
+	queryErrorPermission
+)
+
+var retrySecondsRegex = regexp.MustCompile(`(\d+) seconds`)
+
+// floodBackoffUntil is the time until which no sweeps run after the server reported flooding.
+var floodBackoffUntil time.Time
+
+// serverDown is set while the virtual server is stopped or restarting and has to be selected again.
+var serverDown bool
+
+// permissionAlerts remembers the permissions admins were already alerted about, keyed by permission ID.
+var permissionAlerts = make(map[int]bool)
+
+// classifyQueryError returns how err has to be handled and the server error, if err came from the server.
+func classifyQueryError(err error) (queryErrorKind, *ts3.Error) {
+	var tsErr *ts3.Error
+	if !errors.As(err, &tsErr) {
+		return queryErrorOther, nil
+	}
+	switch tsErr.ID {
+	case queryErrClientFlooding, queryErrFloodBan:
+		return queryErrorFlood, tsErr
+	case queryErrInvalidClientID:
+		return queryErrorInvalidClient, tsErr
+	case queryErrInvalidChannelID:
+		return queryErrorInvalidChannel, tsErr
+	case queryErrInvalidServerID, queryErrServerShuttingDown, queryErrServerNotRunning:
+		return queryErrorServerDown, tsErr
+	case queryErrInsufficientPermission:
+		return queryErrorPermission, tsErr
+	default:
+		return queryErrorOther, tsErr
+	}
+}
+
+// handleQueryError counts a failed query of op and reacts to its error code.
+// Logging other errors and refreshing after invalid client IDs is left to the caller.
+func handleQueryError(client *ts3.Client, config Config, op string, err error) queryErrorKind {
+	kind, tsErr := classifyQueryError(err)
+	metrics.count("errors", 1, "op:"+op)
+	switch kind {
+	case queryErrorFlood:
+		backoff := floodRetryAfter(tsErr)
+		floodBackoffUntil = clock.Now().Add(backoff)
+		zap.S().Errorf("Server reported flooding on %s, pausing sweeps for %v: %v", op, backoff, err)
+		health.set(healthRateLimited, fmt.Sprintf("the server reported flooding on %s, sweeps resume at %s", op, floodBackoffUntil.Format(time.RFC3339)))
+		queryPace.slowDown()
+	case queryErrorServerDown:
+		if !serverDown {
+			zap.S().Errorf("Virtual server %d is not running, waiting for it to come back: %v", serverID, err)
+			events.publish(botEvent{Type: "error", Message: fmt.Sprintf("Virtual server %d is not running", serverID)})
+		}
+		serverDown = true
+	case queryErrorInvalidChannel:
+		// A channel was deleted without the bot noticing, resolve channel names again.
+		channelList.invalidate()
+	case queryErrorPermission:
+		alertMissingPermission(client, config, op, tsErr)
+		accountPermissionLost(config, op)
+	}
+	return kind
+}
+
+// floodRetryAfter reads the retry delay from the extra message of a flood error.
+func floodRetryAfter(tsErr *ts3.Error) time.Duration {
+	if extra, ok := tsErr.Details["extra_msg"].(string); ok {
+		if retry, ok := retryAfter(extra); ok {
+			return retry
+		}
+	}
+	return defaultFloodBackoff
+}
+
+// retryAfter reads a delay like "please wait 10 seconds" from a server message.
+func retryAfter(msg string) (time.Duration, bool) {
+	if matches := retrySecondsRegex.FindStringSubmatch(msg); len(matches) == 2 {
+		if seconds, err := strconv.Atoi(matches[1]); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// alertMissingPermission tells the admins once per permission that the query account lacks it.
+func alertMissingPermission(client *ts3.Client, config Config, op string, tsErr *ts3.Error) {
+	permId, _ := tsErr.Details["failed_permid"].(int)
+	msg := fmt.Sprintf("The query account lacks permission %d needed for %s", permId, op)
+	zap.S().Error(msg)
+	if permissionAlerts[permId] {
+		return
+	}
+	permissionAlerts[permId] = true
+	events.publish(botEvent{Type: "error", Message: msg})
+	notifyAdmins(client, config, "AFK bot: "+msg)
+}
@@ -0,0 +1,19 @@
+package main
+
+// Machine-readable reasons of warnings and moves, attached to statuses, events, logs and metrics.
+const (
+	// reasonThreshold is an idle time over the client's limit.
+	reasonThreshold = "threshold_exceeded"
+	// reasonThresholdNear is an idle time within TS3_WARN_BEFORE_SEC of the limit.
+	reasonThresholdNear = "threshold_near"
+	// reasonMuted is a client muted for longer than TS3_MUTED_AFK_SEC.
+	reasonMuted = "muted_too_long"
+	// reasonAway is a client that set itself away, with TS3_MOVE_AWAY.
+	reasonAway = "away_flag"
+	// reasonAdminCommand is a mass move ordered by an admin.
+	reasonAdminCommand = "admin_command"
+	// reasonActiveAgain is a client moved back after it became active again.
+	reasonActiveAgain = "active_again"
+	// reasonUndo is a client moved back by an admin's !undo.
+	reasonUndo = "undo"
+)
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialRegex matches the values of command arguments that carry credentials.
+var credentialRegex = regexp.MustCompile(`(?i)\b((?:client_login_name|client_login_password|password|serveradmin_password)=)\S*`)
+
+// trafficRecord is one line of a traffic recording.
+type trafficRecord struct {
+	Time         time.Time            `json:"time"`
+	Command      string               `json:"command,omitempty"`
+	Response     []string             `json:"response,omitempty"`
+	Error        string               `json:"error,omitempty"`
+	Notification *trafficNotification `json:"notification,omitempty"`
+}
+
+// trafficNotification is a notification as the client library decoded it; its raw line is not available.
+type trafficNotification struct {
+	Type string            `json:"type"`
+	Data map[string]string `json:"data"`
+}
+
+// trafficRecorder writes the ServerQuery commands the bot sends and the responses and notifications
+// it receives to a file, one JSON record per line, to reproduce parsing bugs with replay.
+type trafficRecorder struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// traffic is the recorder enabled with --record, nil otherwise.
+var traffic *trafficRecorder
+
+func newTrafficRecorder(path string) (*trafficRecorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open traffic recording: %w", err)
+	}
+	return &trafficRecorder{encoder: json.NewEncoder(file)}, nil
+}
+
+func (r *trafficRecorder) write(record trafficRecord) {
+	if r == nil {
+		return
+	}
+	record.Time = time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encoder.Encode(record); err != nil {
+		zap.S().Errorf("Failed to write traffic recording: %v", err)
+	}
+}
+
+// command records cmd with its raw response lines, redacting credentials.
+func (r *trafficRecorder) command(cmd string, lines []string, err error) {
+	record := trafficRecord{Command: credentialRegex.ReplaceAllString(cmd, "${1}<redacted>"), Response: lines}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	r.write(record)
+}
+
+func (r *trafficRecorder) notification(n ts3.Notification) {
+	r.write(trafficRecord{Notification: &trafficNotification{Type: n.Type, Data: n.Data}})
+}
+
+// replayResponses creates the value the bot decodes the response of each command into.
+var replayResponses = map[string]func() interface{}{
+	"channellist":           func() interface{} { return &[]*channelInfo{} },
+	"clientlist":            func() interface{} { return &[]*clientInfo{} },
+	"clientinfo":            func() interface{} { return &clientDetails{} },
+	"clientgetdbidfromuid":  func() interface{} { return &databaseIDLookup{} },
+	"servergroupclientlist": func() interface{} { return &[]*groupMember{} },
+	"serverlist":            func() interface{} { return &[]*serverInfo{} },
+}
+
+// replayTraffic feeds the responses of a recording through the same decoding as the bot and prints the
+// result of each, for the replay command. It returns an error if any response failed to decode.
+func replayTraffic(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	failed := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), ts3.MaxParseTokenSize)
+	for line := 1; scanner.Scan(); line++ {
+		var record trafficRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if record.Notification != nil {
+			fmt.Printf("%d: notify%s %v\n", line, record.Notification.Type, record.Notification.Data)
+			continue
+		}
+		name, _, _ := strings.Cut(record.Command, " ")
+		newResponse, ok := replayResponses[name]
+		if !ok || record.Error != "" {
+			continue
+		}
+		response := newResponse()
+		if err = ts3.DecodeResponse(record.Response, response); err != nil {
+			failed++
+			fmt.Printf("%d: %s: decoding failed: %v\n", line, name, err)
+			for _, raw := range record.Response {
+				fmt.Printf("    %s\n", raw)
+			}
+			continue
+		}
+		decoded, _ := json.Marshal(response)
+		fmt.Printf("%d: %s: %s\n", line, name, decoded)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d responses failed to decode", failed)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"time"
+)
+
+// departedClient is the state of a client that left, kept to carry it over if the client reconnects.
+type departedClient struct {
+	leftAt       time.Time
+	idleStreak   int
+	mutedSince   time.Time
+	warned       bool
+	manualMoveAt time.Time
+	logs         map[string]*repeatedLog
+}
+
+// departedClients holds the clients that left within TS3_RECONNECT_WINDOW_SEC, keyed by unique identifier.
+var departedClients = make(map[string]*departedClient)
+
+// clientLeft forgets the state of the client with the given ID, keeping it for a reconnect of the same identity.
+func clientLeft(id int, reconnectWindow time.Duration) {
+	if c, ok := previousClients[id]; ok && reconnectWindow > 0 {
+		d := &departedClient{
+			leftAt:       clock.Now(),
+			idleStreak:   idleStreaks[id],
+			mutedSince:   mutedSince[id],
+			warned:       warnedClients[id],
+			manualMoveAt: manualMoves[id],
+			logs:         repeatedLogs[id],
+		}
+		// The client may already have been forgotten when a query noticed it left.
+		if d.idleStreak > 0 || !d.mutedSince.IsZero() || d.warned || !d.manualMoveAt.IsZero() || d.logs != nil {
+			departedClients[c.UniqueIdentifier] = d
+		}
+	}
+	forgetClient(id)
+}
+
+// reconnecting reports whether a client with the given unique identifier left within the reconnect window.
+func reconnecting(uid string, reconnectWindow time.Duration) bool {
+	d, ok := departedClients[uid]
+	return ok && clock.Now().Sub(d.leftAt) <= reconnectWindow
+}
+
+// correlateReconnects gives clients that reconnected with a new client ID the state they had before.
+func (s *sweep) correlateReconnects() {
+	window := s.config.ReconnectWindow
+	for uid, d := range departedClients {
+		if s.now.Sub(d.leftAt) > window {
+			delete(departedClients, uid)
+		}
+	}
+	if len(departedClients) == 0 {
+		return
+	}
+	for _, c := range s.clients {
+		if _, known := previousClients[c.ID]; known {
+			continue
+		}
+		d, ok := departedClients[c.UniqueIdentifier]
+		if !ok {
+			continue
+		}
+		delete(departedClients, c.UniqueIdentifier)
+		if d.idleStreak > 0 {
+			idleStreaks[c.ID] = d.idleStreak
+		}
+		if !d.mutedSince.IsZero() {
+			mutedSince[c.ID] = d.mutedSince
+		}
+		if d.warned {
+			warnedClients[c.ID] = true
+		}
+		if !d.manualMoveAt.IsZero() {
+			manualMoves[c.ID] = d.manualMoveAt
+		}
+		if d.logs != nil {
+			repeatedLogs[c.ID] = d.logs
+		}
+		zap.S().Debugf("User %s reconnected after %v, keeping their state", c.logName(), s.now.Sub(d.leftAt).Truncate(time.Second))
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var configFileFlag = flag.String("config", "", "read KEY=VALUE settings from this file, the environment takes precedence; POST /config/reload applies changes")
+
+// restartSettings are the Config fields only read on startup. A reload reports their changes but keeps the running values.
+var restartSettings = map[string]bool{
+	"UserName": true, "Password": true, "FallbackAccounts": true, "Nickname": true, "ServerId": true, "ServerPort": true,
+	"AllServers": true, "NotificationConnection": true, "ObserveOnly": true, "Urls": true, "Location": true,
+	"CalendarURL": true, "CalendarRefresh": true, "OverridesFile": true, "Storage": true, "StorageDSN": true, "StorageSync": true, "StorageBuffer": true, "CounterFlush": true,
+	"HTTPAddr": true, "AdminToken": true, "LogProfile": true, "LogFields": true, "PrivacyMode": true, "PrivacySalt": true,
+	"StatsdAddr": true, "StatsdPrefix": true, "StatsdTags": true, "DogStatsD": true,
+	"UpdateCheck": true, "UpdateWebhook": true, "AuditLog": true, "EventWebhook": true, "EventWebhookTypes": true,
+	"DiscordWebhook": true, "DiscordEventTypes": true, "MatrixHomeserver": true, "MatrixToken": true, "MatrixRoom": true,
+	"MatrixEventTypes": true, "SlackWebhook": true, "SlackToken": true, "SlackChannel": true, "SlackEventTypes": true,
+	"SlackDigestAt": true, "PermissionDigestAt": true, "QueryInterval": true, "NotifyRatePerMin": true, "NotifyMoveWindow": true, "NotifyMaxMoves": true, "NotifyBuffer": true, "EventBuffer": true, "EventOverflow": true, "ChaosPercent": true, "ChaosMaxDelay": true, "PauseDefault": true,
+}
+
+// configChange is a setting that differs between the running configuration and the one reloading would apply.
+type configChange struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	// RestartRequired is set for settings that only take effect after a restart.
+	RestartRequired bool `json:"restart_required"`
+}
+
+// configReloadRequest asks the main loop, which owns the configuration, to switch to config.
+type configReloadRequest struct {
+	config Config
+	result chan []configChange
+}
+
+// configReloads carries reloads requested through the API to the main loop.
+var configReloads = make(chan configReloadRequest)
+
+// runningConfig is the configuration from the environment the main loop runs with, for GET /config/diff.
+var runningConfig struct {
+	mu     sync.Mutex
+	config Config
+}
+
+func setRunningConfig(config Config) {
+	runningConfig.mu.Lock()
+	defer runningConfig.mu.Unlock()
+	runningConfig.config = config
+}
+
+func currentConfig() Config {
+	runningConfig.mu.Lock()
+	defer runningConfig.mu.Unlock()
+	return runningConfig.config
+}
+
+// readEnvFile reads KEY=VALUE lines. Empty lines and lines starting with # are skipped,
+// and values may be wrapped in quotes.
+func readEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: %q is not KEY=VALUE", line, text)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, scanner.Err()
+}
+
+// diffConfig lists the settings that differ between old and new. Secrets are compared, but shown redacted.
+func diffConfig(old Config, new Config) []configChange {
+	var changes []configChange
+	oldValue, newValue := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := oldValue.Type().Field(i).Name
+		a, b := oldValue.Field(i).Interface(), newValue.Field(i).Interface()
+		if s, ok := a.(secret); ok {
+			if s == b.(secret) {
+				continue
+			}
+		} else if fmt.Sprint(a) == fmt.Sprint(b) {
+			continue
+		}
+		changes = append(changes, configChange{Setting: name, Old: fmt.Sprint(a), New: fmt.Sprint(b), RestartRequired: restartSettings[name]})
+	}
+	return changes
+}
+
+// reloadConfig returns next with the settings that require a restart kept at their running values.
+func reloadConfig(running Config, next Config) Config {
+	runningValue, nextValue := reflect.ValueOf(running), reflect.ValueOf(&next).Elem()
+	for i := 0; i < nextValue.NumField(); i++ {
+		if restartSettings[nextValue.Type().Field(i).Name] {
+			nextValue.Field(i).Set(runningValue.Field(i))
+		}
+	}
+	return next
+}
+
+// handleConfigDiff serves GET /config/diff, listing what POST /config/reload would change.
+func handleConfigDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	next, err := loadConfigFromEnv()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNilChanges(diffConfig(currentConfig(), next)))
+}
+
+// handleConfigReload serves POST /config/reload, which applies the changed settings of the -config file
+// and returns them. An invalid configuration is rejected as a whole.
+func handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	next, err := loadConfigFromEnv()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if problems := validateConfig(runtimeSettings.apply(withCurrentProfile(reloadConfig(currentConfig(), next)))); len(problems) > 0 {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("the runtime settings make the new configuration invalid: %v", errors.Join(problems...)))
+		return
+	}
+	req := configReloadRequest{config: next, result: make(chan []configChange, 1)}
+	select {
+	case configReloads <- req:
+	case <-r.Context().Done():
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNilChanges(<-req.result))
+}
+
+// nonNilChanges makes an empty diff encode as [] instead of null.
+func nonNilChanges(changes []configChange) []configChange {
+	if changes == nil {
+		return []configChange{}
+	}
+	return changes
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// pendingReturn is a client the bot moved into an AFK channel and moves back once it is active again.
+type pendingReturn struct {
+	home     int
+	expected int
+	movedAt  time.Time
+	// leftAt is set while the client is offline, it may reconnect into the channel it was in.
+	leftAt time.Time
+}
+
+// pendingReturns holds the clients waiting to be moved back, keyed by unique identifier.
+var pendingReturns = make(map[string]pendingReturn)
+
+// homeLookups holds the unique identifiers of clients in an AFK channel whose home channel was looked up in the storage.
+var homeLookups = make(map[string]bool)
+
+// rememberReturn records that c was moved into target and has to go back to the channel it is in now.
+func (s *sweep) rememberReturn(c *clientInfo, target int) {
+	if !s.config.ReturnHome {
+		return
+	}
+	pendingReturns[c.UniqueIdentifier] = pendingReturn{home: c.ChannelID, expected: target, movedAt: s.now}
+}
+
+// checkReturns drops the pending returns of clients that left for longer than TS3_RECONNECT_WINDOW_SEC,
+// and of clients that are no longer in the channel the bot moved them to. Somebody else moved those,
+// and the bot must not undo a moderator's move.
+func (s *sweep) checkReturns() {
+	if len(pendingReturns) == 0 {
+		return
+	}
+	online := make(map[string]*clientInfo, len(s.clients))
+	for _, c := range s.clients {
+		online[c.UniqueIdentifier] = c
+	}
+	for uid, pending := range pendingReturns {
+		c, ok := online[uid]
+		if !ok {
+			if pending.leftAt.IsZero() {
+				pending.leftAt = s.now
+				pendingReturns[uid] = pending
+			} else if s.now.Sub(pending.leftAt) > s.config.ReconnectWindow {
+				delete(pendingReturns, uid)
+			}
+			continue
+		}
+		if !pending.leftAt.IsZero() {
+			pending.leftAt = time.Time{}
+			pendingReturns[uid] = pending
+		}
+		if c.ChannelID != pending.expected {
+			zap.S().Infof("User %s was moved out of channel %d by somebody else, not moving them back", c.logName(), pending.expected)
+			delete(pendingReturns, uid)
+		}
+	}
+}
+
+// restoreReturns looks up the stored home channel of clients found in an AFK channel without a pending
+// return, e.g. after a restart or when another instance sharing the storage moved them.
+func (s *sweep) restoreReturns() {
+	if !s.config.ReturnHome {
+		return
+	}
+	inAfkChannel := make(map[string]bool)
+	for _, c := range s.clients {
+		uid := c.UniqueIdentifier
+		if !s.isAfkChannel(c.ChannelID) {
+			continue
+		}
+		inAfkChannel[uid] = true
+		if _, ok := pendingReturns[uid]; ok || homeLookups[uid] {
+			continue
+		}
+		homeLookups[uid] = true
+		home, err := storage.HomeChannel(privacy.uid(uid))
+		if err != nil {
+			zap.S().Errorf("Failed to look up the home channel of %s: %v", c.logName(), err)
+			continue
+		}
+		if home != 0 && home != c.ChannelID {
+			zap.S().Infof("User %s came from channel [%d] according to the storage, moving them back once they are active", c.logName(), home)
+			pendingReturns[uid] = pendingReturn{home: home, expected: c.ChannelID, movedAt: s.now}
+		}
+	}
+	for uid := range homeLookups {
+		if !inAfkChannel[uid] {
+			delete(homeLookups, uid)
+		}
+	}
+}
+
+// returnHome moves an active client that the bot moved into an AFK channel back to where it came from.
+// It reports whether the client was moved, or in a dry run, whether it would be.
+func (s *sweep) returnHome(c *clientInfo, status *clientStatus) bool {
+	pending, ok := pendingReturns[c.UniqueIdentifier]
+	if !ok || s.forcedThresholdMs > 0 || c.ChannelID != pending.expected || s.config.ObserveOnly {
+		return false
+	}
+	// Do not bounce clients that twitch right after being moved.
+	if s.now.Sub(pending.movedAt) < s.config.ReturnCooldown {
+		logDecision(c, "User %s is active again, but was moved less than %v ago", c.logName(), s.config.ReturnCooldown)
+		return false
+	}
+	if s.pausedMidSweep() {
+		return false
+	}
+	if s.config.MaxMovesPerSweep > 0 && s.moves >= s.config.MaxMovesPerSweep {
+		logDecision(c, "User %s is active again, but moving them back is deferred, %d users were already moved in this sweep", c.logName(), s.moves)
+		return false
+	}
+	if _, exists := s.tree.byID[pending.home]; !exists {
+		zap.S().Infof("Home channel %d of user %s was deleted, not moving them back", pending.home, c.logName())
+		delete(pendingReturns, c.UniqueIdentifier)
+		return false
+	}
+	if s.dryRun {
+		// The return waits for the end of the sweep, like the moves, so anomalies and large sweeps hold it back too.
+		status.Status = statusWouldReturn
+		status.targetChannelID = pending.home
+		return true
+	}
+	s.moveBack(c, status, pending.home)
+	return true
+}
+
+// moveBack moves c back to its home channel and forgets the pending return.
+func (s *sweep) moveBack(c *clientInfo, status *clientStatus, home int) {
+	if err := moveClient(s.client, c.ID, home); err != nil {
+		status.Status = s.queryFailed(c, "clientmove", err)
+		return
+	}
+	zap.S().Infof("User %s is active again, moved back to channel [%d], reason %s", c.logName(), home, reasonActiveAgain)
+	publishClientAction("move", c, reasonActiveAgain, fmt.Sprintf("Moved back to channel %d", home))
+	metrics.count("returns", 1, "reason:"+reasonActiveAgain)
+	s.moves++
+	delete(pendingReturns, c.UniqueIdentifier)
+	if err := storage.DeleteHomeChannel(privacy.uid(c.UniqueIdentifier)); err != nil {
+		zap.S().Errorf("Failed to delete home channel of %s: %v", c.logName(), err)
+	}
+	status.Status = statusReturned
+	status.Reason = reasonActiveAgain
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// statsRecorder keeps the totals of all counters and the latest gauges and timings in memory for the
+// statistics dump, and forwards everything to the configured sink.
+type statsRecorder struct {
+	next    metricsSink
+	mu      sync.Mutex
+	counts  map[string]int64
+	gauges  map[string]float64
+	timings map[string]time.Duration
+}
+
+func newStatsRecorder(next metricsSink) *statsRecorder {
+	return &statsRecorder{
+		next:    next,
+		counts:  make(map[string]int64),
+		gauges:  make(map[string]float64),
+		timings: make(map[string]time.Duration),
+	}
+}
+
+func (r *statsRecorder) count(name string, value int64, tags ...string) {
+	r.mu.Lock()
+	r.counts[name] += value
+	r.mu.Unlock()
+	r.next.count(name, value, tags...)
+}
+
+func (r *statsRecorder) gauge(name string, value float64, tags ...string) {
+	r.mu.Lock()
+	r.gauges[name] = value
+	r.mu.Unlock()
+	r.next.gauge(name, value, tags...)
+}
+
+func (r *statsRecorder) timing(name string, d time.Duration, tags ...string) {
+	r.mu.Lock()
+	r.timings[name] = d
+	r.mu.Unlock()
+	r.next.timing(name, d, tags...)
+}
+
+// stats is the statsRecorder in front of the metrics sink.
+var stats = newStatsRecorder(noopMetrics{})
+
+// statsRequests asks the main loop, which owns the internal maps, to log the runtime statistics.
+var statsRequests = make(chan struct{}, 1)
+
+// logRuntimeStats logs the counters, internal map sizes, goroutine count and the duration of the last sweep.
+func logRuntimeStats() {
+	stats.mu.Lock()
+	counts := make(map[string]int64, len(stats.counts))
+	for name, value := range stats.counts {
+		counts[name] = value
+	}
+	gauges := make(map[string]float64, len(stats.gauges))
+	for name, value := range stats.gauges {
+		gauges[name] = value
+	}
+	lastSweep := stats.timings["sweep.duration"]
+	stats.mu.Unlock()
+
+	totals, err := counters.totals()
+	if err != nil {
+		zap.S().Errorf("Failed to read the stored counters: %v", err)
+	}
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	zap.S().Infow("Runtime statistics",
+		"version", currentBuild().Version,
+		"counters", counts,
+		"totals", totals,
+		"gauges", gauges,
+		"last_sweep", latestSweep().Time,
+		"last_sweep_duration", lastSweep.String(),
+		"goroutines", runtime.NumGoroutine(),
+		"heap_bytes", memory.HeapAlloc,
+		"maps", map[string]int{
+			"idle_streaks":      len(idleStreaks),
+			"muted_since":       len(mutedSince),
+			"last_talked":       len(lastTalked),
+			"previous_clients":  len(previousClients),
+			"recent_joins":      len(recentJoins),
+			"scheduled_checks":  len(checks.byClient),
+			"repeated_logs":     len(repeatedLogs),
+			"channel_visits":    len(channelVisits),
+			"warned_clients":    len(warnedClients),
+			"pending_returns":   len(pendingReturns),
+			"home_lookups":      len(homeLookups),
+			"manual_moves":      len(manualMoves),
+			"rejected_targets":  len(rejectedTargets),
+			"departed_clients":  len(departedClients),
+			"virtual_servers":   len(virtualServers),
+			"permission_alerts": len(permissionAlerts),
+			"mass_sweeps":       len(pendingMassSweeps),
+		},
+	)
+}
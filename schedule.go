@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // The alpine image ships without zoneinfo.
+)
+
+// dailyWindow is a range of wall-clock time that repeats every day, e.g. 22:00-07:00.
+// Windows are compared against the local time in the configured timezone, so they follow
+// DST transitions: on the day clocks change, a 22:00-07:00 window is an hour shorter or longer.
+type dailyWindow struct {
+	// start and end are minutes after midnight. A window with end <= start wraps around midnight.
+	start, end int
+}
+
+func parseDailyWindow(value string) (dailyWindow, error) {
+	from, to, found := strings.Cut(value, "-")
+	if !found {
+		return dailyWindow{}, fmt.Errorf("%q is not a time range like 22:00-07:00", value)
+	}
+	start, err := parseClock(strings.TrimSpace(from))
+	if err != nil {
+		return dailyWindow{}, err
+	}
+	end, err := parseClock(strings.TrimSpace(to))
+	if err != nil {
+		return dailyWindow{}, err
+	}
+	return dailyWindow{start: start, end: end}, nil
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day like 07:30", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether t, already converted to the configured timezone, lies within the window.
+func (w dailyWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+func (w dailyWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+}
+
+func inAnyWindow(windows []dailyWindow, t time.Time) bool {
+	for _, window := range windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
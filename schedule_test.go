@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// windowLength returns how long the windows are active between from and to in real time, sampled by the minute.
+func windowLength(windows []dailyWindow, location *time.Location, from time.Time, to time.Time) time.Duration {
+	var length time.Duration
+	for t := from; t.Before(to); t = t.Add(time.Minute) {
+		if inAnyWindow(windows, t.In(location)) {
+			length += time.Minute
+		}
+	}
+	return length
+}
+
+func TestQuietHoursAcrossDSTTransitions(t *testing.T) {
+	config := loadTestConfig(t, map[string]string{"TS3_TIMEZONE": "Europe/Berlin", "TS3_QUIET_HOURS": "22:00-07:00"})
+	berlin := config.Location
+
+	// Clocks go from 02:00 CET to 03:00 CEST on 2026-03-29, so the night is an hour shorter.
+	spring := time.Date(2026, 3, 28, 12, 0, 0, 0, time.UTC)
+	if got := windowLength(config.QuietHours, berlin, spring, spring.Add(24*time.Hour)); got != 8*time.Hour {
+		t.Errorf("quiet hours in the spring-forward night last %v, want 8h", got)
+	}
+	for instant, want := range map[time.Time]bool{
+		time.Date(2026, 3, 28, 20, 59, 0, 0, time.UTC): false, // 21:59 CET
+		time.Date(2026, 3, 28, 21, 0, 0, 0, time.UTC):  true,  // 22:00 CET
+		time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC):   true,  // 03:00 CEST, right after the skipped hour
+		time.Date(2026, 3, 29, 4, 59, 0, 0, time.UTC):  true,  // 06:59 CEST
+		time.Date(2026, 3, 29, 5, 0, 0, 0, time.UTC):   false, // 07:00 CEST
+	} {
+		if got := inAnyWindow(config.QuietHours, instant.In(berlin)); got != want {
+			t.Errorf("quiet hours at %s = %v, want %v", instant.In(berlin).Format(time.RFC3339), got, want)
+		}
+	}
+
+	// Clocks go from 03:00 CEST back to 02:00 CET on 2026-10-25, so the night is an hour longer.
+	fall := time.Date(2026, 10, 24, 12, 0, 0, 0, time.UTC)
+	if got := windowLength(config.QuietHours, berlin, fall, fall.Add(24*time.Hour)); got != 10*time.Hour {
+		t.Errorf("quiet hours in the fall-back night last %v, want 10h", got)
+	}
+	for instant, want := range map[time.Time]bool{
+		time.Date(2026, 10, 24, 19, 59, 0, 0, time.UTC): false, // 21:59 CEST
+		time.Date(2026, 10, 24, 20, 0, 0, 0, time.UTC):  true,  // 22:00 CEST
+		time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC):  true,  // 02:30 CEST
+		time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC):  true,  // 02:30 CET, the repeated hour
+		time.Date(2026, 10, 25, 5, 59, 0, 0, time.UTC):  true,  // 06:59 CET
+		time.Date(2026, 10, 25, 6, 0, 0, 0, time.UTC):   false, // 07:00 CET
+	} {
+		if got := inAnyWindow(config.QuietHours, instant.In(berlin)); got != want {
+			t.Errorf("quiet hours at %s = %v, want %v", instant.In(berlin).Format(time.RFC3339), got, want)
+		}
+	}
+}
+
+func TestWindowWithinDSTTransitionHour(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	window, err := parseDailyWindow("02:00-03:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows := []dailyWindow{window}
+
+	// The skipped hour never happens, the repeated one happens twice.
+	spring := time.Date(2026, 3, 28, 12, 0, 0, 0, time.UTC)
+	if got := windowLength(windows, berlin, spring, spring.Add(24*time.Hour)); got != 0 {
+		t.Errorf("02:00-03:00 on the spring-forward day lasts %v, want 0", got)
+	}
+	fall := time.Date(2026, 10, 24, 12, 0, 0, 0, time.UTC)
+	if got := windowLength(windows, berlin, fall, fall.Add(24*time.Hour)); got != 2*time.Hour {
+		t.Errorf("02:00-03:00 on the fall-back day lasts %v, want 2h", got)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+// scheduledCheck is the earliest time a client could cross its idle limit, so there is no need to ask
+// the server for its idle time before. Idle time never grows faster than the clock.
+type scheduledCheck struct {
+	clientID int
+	at       time.Time
+	// Idle time measured at measuredAt, used to estimate the idle time until the check.
+	idleTimeMs    int
+	maxIdleTimeMs int
+	measuredAt    time.Time
+	// The check is only valid while the client stays in channelID and keeps its override limit.
+	channelID      int
+	overrideIdleMs int
+	index          int
+}
+
+// checkQueue is a priority queue of scheduled checks, earliest first.
+type checkQueue struct {
+	items    checkHeap
+	byClient map[int]*scheduledCheck
+}
+
+var checks = &checkQueue{byClient: make(map[int]*scheduledCheck)}
+
+// schedule replaces the scheduled check of check.clientID.
+func (q *checkQueue) schedule(check *scheduledCheck) {
+	q.remove(check.clientID)
+	heap.Push(&q.items, check)
+	q.byClient[check.clientID] = check
+}
+
+func (q *checkQueue) remove(clientID int) {
+	if check, ok := q.byClient[clientID]; ok {
+		heap.Remove(&q.items, check.index)
+		delete(q.byClient, clientID)
+	}
+}
+
+func (q *checkQueue) get(clientID int) (*scheduledCheck, bool) {
+	check, ok := q.byClient[clientID]
+	return check, ok
+}
+
+// earliest returns the time of the next scheduled check.
+func (q *checkQueue) earliest() (time.Time, bool) {
+	if len(q.items) == 0 {
+		return time.Time{}, false
+	}
+	return q.items[0].at, true
+}
+
+type checkHeap []*scheduledCheck
+
+func (h checkHeap) Len() int           { return len(h) }
+func (h checkHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h checkHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *checkHeap) Push(x interface{}) {
+	check := x.(*scheduledCheck)
+	check.index = len(*h)
+	*h = append(*h, check)
+}
+
+func (h *checkHeap) Pop() interface{} {
+	old := *h
+	check := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return check
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends state, e.g. "READY=1", to systemd if the bot runs as a Type=notify service,
+// and does nothing otherwise.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		// Abstract socket namespace.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		zap.S().Warnf("Failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		zap.S().Warnf("Failed to notify systemd: %v", err)
+	}
+}
+
+// watchdogTimeout is the WatchdogSec of the systemd service, 0 if the watchdog is not enabled.
+var watchdogTimeout = systemdWatchdog()
+
+// systemdWatchdog reads the watchdog timeout systemd passes to the service.
+func systemdWatchdog() time.Duration {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdogPing tells systemd that a sweep finished, so the main loop is not stuck.
+func watchdogPing() {
+	if watchdogTimeout > 0 {
+		sdNotify("WATCHDOG=1")
+	}
+}
+
+// withinWatchdog shortens the time until the next sweep so that the watchdog is pinged at least twice per timeout.
+func withinWatchdog(next time.Duration) time.Duration {
+	if watchdogTimeout > 0 && next > watchdogTimeout/2 {
+		return watchdogTimeout / 2
+	}
+	return next
+}
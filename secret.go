@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+)
+
+// redacted replaces secrets in all output.
+const redacted = "<redacted>"
+
+// secret is a configuration value such as a password, token or URL with credentials that must never
+// appear in logs, dumps, error messages or API responses. All of its string forms are redacted,
+// only value returns the real value to pass it where it is used.
+type secret string
+
+func (s secret) value() string {
+	return string(s)
+}
+
+// String redacts the secret for all fmt verbs that print strings, including %v of a Config.
+func (s secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// redactURLError removes the URL from err if it is an HTTP client error, which quotes the full URL
+// including credentials or tokens in its path or query.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	redactedErr := *urlErr
+	if u, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+		redactedErr.URL = u.Scheme + "://" + u.Host + "/" + redacted
+	} else {
+		redactedErr.URL = redacted
+	}
+	return &redactedErr
+}
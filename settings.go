@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runtimeSetting is a configuration value admins can change while the bot is running.
+type runtimeSetting struct {
+	description string
+	// apply parses value into config and returns the value in its canonical form.
+	apply func(config *Config, value string) (string, error)
+}
+
+var runtimeSettingDefinitions = map[string]runtimeSetting{
+	"max_idle_time_sec": {
+		description: "idle time in seconds after which a user is moved",
+		apply: func(config *Config, value string) (string, error) {
+			seconds, err := parseSeconds(value, 1)
+			config.MaxIdleTimeMs = seconds * 1000
+			return strconv.Itoa(seconds), err
+		},
+	},
+	"grace_period_sec": {
+		description: "seconds idle times are ignored in a channel after somebody joined it",
+		apply: func(config *Config, value string) (string, error) {
+			seconds, err := parseSeconds(value, 0)
+			config.GracePeriod = time.Duration(seconds) * time.Second
+			return strconv.Itoa(seconds), err
+		},
+	},
+	"ignored_channels": {
+		description: "channel names in which users may idle, as JSON array or comma-separated list",
+		apply: func(config *Config, value string) (string, error) {
+			list, err := parseStringList(value)
+			if err != nil {
+				return "", fmt.Errorf("not a valid %v", err)
+			}
+			if list == nil {
+				list = []string{}
+			}
+			config.IgnoredChannels = list
+			canonical, err := json.Marshal(list)
+			return string(canonical), err
+		},
+	},
+	"warn_before_sec": {
+		description: "seconds before the idle limit users are warned, 0 disables warnings",
+		apply: func(config *Config, value string) (string, error) {
+			seconds, err := parseSeconds(value, 0)
+			config.WarnBefore = time.Duration(seconds) * time.Second
+			return strconv.Itoa(seconds), err
+		},
+	},
+	"warn_method": {
+		description: "how users are warned unless they chose otherwise, poke, msg or none",
+		apply: func(config *Config, value string) (string, error) {
+			if !isNotifyMethod(value) {
+				return "", fmt.Errorf("%q is not poke, msg or none", value)
+			}
+			config.WarnMethod = value
+			return value, nil
+		},
+	},
+	"move_away": {
+		description: "whether users who set themselves away are moved regardless of their idle time",
+		apply: func(config *Config, value string) (string, error) {
+			moveAway, err := strconv.ParseBool(value)
+			if err != nil {
+				return "", fmt.Errorf("%q is not a boolean", value)
+			}
+			config.MoveAway = moveAway
+			return strconv.FormatBool(moveAway), nil
+		},
+	},
+	"exempt_server_groups": {
+		description: "server group IDs whose members are never moved, as JSON array or comma-separated list",
+		apply: func(config *Config, value string) (string, error) {
+			list, err := parseStringList(value)
+			if err != nil {
+				return "", fmt.Errorf("not a valid %v", err)
+			}
+			groups := []int{}
+			for _, item := range list {
+				group, err := strconv.Atoi(strings.TrimSpace(item))
+				if err != nil {
+					return "", fmt.Errorf("%q is not a number", item)
+				}
+				groups = append(groups, group)
+			}
+			config.ExemptGroups = groups
+			canonical, err := json.Marshal(groups)
+			return string(canonical), err
+		},
+	},
+	"exempt_sets": {
+		description: "names of TS3_EXEMPTION_SETS whose members are never moved, as JSON array or comma-separated list",
+		apply: func(config *Config, value string) (string, error) {
+			list, err := parseStringList(value)
+			if err != nil {
+				return "", fmt.Errorf("not a valid %v", err)
+			}
+			sets := []string{}
+			for _, item := range list {
+				sets = append(sets, strings.TrimSpace(item))
+			}
+			config.ExemptSets = sets
+			canonical, err := json.Marshal(sets)
+			return string(canonical), err
+		},
+	},
+}
+
+func parseSeconds(value string, min int) (int, error) {
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	if seconds < min {
+		return 0, fmt.Errorf("must be at least %d", min)
+	}
+	return seconds, nil
+}
+
+// settingsStore holds the settings changed at runtime, which take precedence over the environment.
+type settingsStore struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+var runtimeSettings = &settingsStore{values: make(map[string]string)}
+
+// loadSettings reads the settings changed at runtime from storage. Values that are no longer valid are ignored.
+func loadSettings() (*settingsStore, error) {
+	stored, err := storage.Settings()
+	if err != nil {
+		return nil, err
+	}
+	store := &settingsStore{values: make(map[string]string)}
+	for name, value := range stored {
+		definition, ok := runtimeSettingDefinitions[name]
+		if !ok {
+			zap.S().Warnf("Ignoring unknown stored setting %s", name)
+			continue
+		}
+		if _, err = definition.apply(&Config{}, value); err != nil {
+			zap.S().Warnf("Ignoring stored setting %s=%q: %v", name, value, err)
+			continue
+		}
+		zap.S().Infof("Using stored setting %s=%s", name, value)
+		store.values[name] = value
+	}
+	return store, nil
+}
+
+// set validates and persists a setting and returns its canonical value.
+// If only saving fails, the setting is applied anyway and returned along with the error.
+func (s *settingsStore) set(name string, value string) (string, error) {
+	definition, ok := runtimeSettingDefinitions[name]
+	if !ok {
+		return "", fmt.Errorf("unknown setting %s", name)
+	}
+	canonical, err := definition.apply(&Config{}, value)
+	if err != nil {
+		return "", fmt.Errorf("invalid value for %s: %v", name, err)
+	}
+	if err = s.validate(name, canonical); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.values[name] = canonical
+	s.mu.Unlock()
+	zap.S().Infof("Setting %s changed to %s", name, canonical)
+	return canonical, storage.SaveSetting(name, canonical)
+}
+
+// reset drops a setting changed at runtime, so the value from the environment applies again.
+func (s *settingsStore) reset(name string) error {
+	if _, ok := runtimeSettingDefinitions[name]; !ok {
+		return fmt.Errorf("unknown setting %s", name)
+	}
+	if err := s.validate(name, ""); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.values, name)
+	s.mu.Unlock()
+	zap.S().Infof("Setting %s reset", name)
+	return storage.DeleteSetting(name)
+}
+
+// validate checks the configuration that results from changing a setting to value, or resetting it if value is empty,
+// so a change cannot break a combination like the warning time being shorter than the idle limit.
+func (s *settingsStore) validate(name string, value string) error {
+	s.mu.RLock()
+	candidate := &settingsStore{values: make(map[string]string, len(s.values))}
+	for n, v := range s.values {
+		candidate.values[n] = v
+	}
+	s.mu.RUnlock()
+	if value == "" {
+		delete(candidate.values, name)
+	} else {
+		candidate.values[name] = value
+	}
+	if problems := validateConfig(candidate.apply(withCurrentProfile(currentConfig()))); len(problems) > 0 {
+		return fmt.Errorf("changing %s would make the configuration invalid: %w", name, errors.Join(problems...))
+	}
+	return nil
+}
+
+func (s *settingsStore) all() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := make(map[string]string, len(s.values))
+	for name, value := range s.values {
+		values[name] = value
+	}
+	return values
+}
+
+// apply returns config with the settings changed at runtime applied.
+func (s *settingsStore) apply(config Config) Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for name, value := range s.values {
+		// Values were validated when they were set.
+		_, _ = runtimeSettingDefinitions[name].apply(&config, value)
+	}
+	return config
+}
+
+// settingNames returns the names of all runtime settings in alphabetical order.
+func settingNames() []string {
+	names := make([]string, 0, len(runtimeSettingDefinitions))
+	for name := range runtimeSettingDefinitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
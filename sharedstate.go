@@ -0,0 +1,47 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// cooldownManualMove is the cooldown kind of clients moved by a moderator, see TS3_MANUAL_MOVE_HOLD_SEC.
+const cooldownManualMove = "manual_move"
+
+// sharedStorages are the storage backends several bot instances can use at the same time.
+var sharedStorages = []string{"postgres", "redis"}
+
+// sharedHolds mirrors the manual move holds recorded by all instances sharing the storage, keyed by
+// unique identifier as stored. It is refreshed in the background and read by the sweeps.
+var sharedHolds = struct {
+	mu    sync.RWMutex
+	byUID map[string]time.Time
+}{byUID: make(map[string]time.Time)}
+
+// runSharedStateSync picks up the overrides and cooldowns other instances wrote to the shared storage,
+// every interval until the process exits.
+func runSharedStateSync(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := clientOverrides.refresh(); err != nil {
+			zap.S().Warnf("Failed to refresh overrides from storage: %v", err)
+		}
+		holds, err := storage.Cooldowns(cooldownManualMove)
+		if err != nil {
+			zap.S().Warnf("Failed to refresh manual move holds from storage: %v", err)
+			continue
+		}
+		sharedHolds.mu.Lock()
+		sharedHolds.byUID = holds
+		sharedHolds.mu.Unlock()
+	}
+}
+
+// sharedHold reports whether another instance recorded a manual move hold for uid that lasts beyond now.
+func sharedHold(uid string, now time.Time) bool {
+	sharedHolds.mu.RLock()
+	defer sharedHolds.mu.RUnlock()
+	until, ok := sharedHolds.byUID[privacy.uid(uid)]
+	return ok && now.Before(until)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slackPostMessageURL is the Slack Web API method a Slack app posts with.
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// slackMessageLimit keeps messages below the 40000 characters Slack accepts.
+const slackMessageLimit = 30000
+
+// slackNotifier posts events to Slack, through an incoming webhook or as a Slack app with a bot token.
+type slackNotifier struct {
+	webhook string
+	token   string
+	channel string
+	client  http.Client
+}
+
+func newSlackNotifier(config Config) *slackNotifier {
+	return &slackNotifier{
+		webhook: config.SlackWebhook.value(),
+		token:   config.SlackToken.value(),
+		channel: config.SlackChannel,
+		client:  http.Client{Timeout: eventSinkTimeout},
+	}
+}
+
+func (n *slackNotifier) Name() string {
+	return "slack"
+}
+
+func (n *slackNotifier) Send(events []botEvent) error {
+	return n.post(eventLines(events, slackMessageLimit))
+}
+
+// post sends text to the incoming webhook, or to the channel through the Web API if a token is configured.
+func (n *slackNotifier) post(text string) error {
+	if n.token == "" {
+		body, err := json.Marshal(map[string]string{"text": text})
+		if err != nil {
+			return permanentError{err}
+		}
+		_, err = postJSON(&n.client, http.MethodPost, n.webhook, body, nil)
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{"channel": n.channel, "text": text})
+	if err != nil {
+		return permanentError{err}
+	}
+	response, err := postJSON(&n.client, http.MethodPost, slackPostMessageURL, body, http.Header{"Authorization": {"Bearer " + n.token}})
+	if err != nil {
+		return err
+	}
+	// The Web API answers errors such as an unknown channel with 200 and ok set to false.
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err = json.Unmarshal(response, &result); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if !result.OK {
+		if result.Error == "ratelimited" {
+			return errors.New(result.Error)
+		}
+		return permanentError{errors.New(result.Error)}
+	}
+	return nil
+}
+
+// slackDigest counts all events and posts a summary to Slack once a day.
+type slackDigest struct {
+	slack *slackNotifier
+
+	mu sync.Mutex
+	// counts holds the number of events per type since the last digest, moves additionally per reason as "move:<reason>".
+	counts map[string]int
+	since  time.Time
+}
+
+func (d *slackDigest) handle(event botEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counts[event.Type]++
+	if event.Type == "move" && event.Reason != "" {
+		d.counts["move:"+event.Reason]++
+	}
+}
+
+// run posts a digest every day at the given minute after midnight in location.
+func (d *slackDigest) run(at int, location *time.Location) {
+	for {
+		time.Sleep(time.Until(nextDailyTime(time.Now().In(location), at)))
+		if err := d.slack.post(d.text()); err != nil {
+			zap.S().Errorf("Failed to send the Slack digest: %v", err)
+		}
+	}
+}
+
+// nextDailyTime returns the next time after now at the given minute after midnight, in the location of now.
+func nextDailyTime(now time.Time, at int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), at/60, at%60, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// text summarizes the events since the last digest and starts counting anew.
+func (d *slackDigest) text() string {
+	d.mu.Lock()
+	counts, since := d.counts, d.since
+	d.counts, d.since = make(map[string]int), time.Now()
+	d.mu.Unlock()
+
+	header := fmt.Sprintf("Daily digest since %s:", since.Format("2006-01-02 15:04"))
+	if len(counts) == 0 {
+		return header + " nothing happened."
+	}
+	var reasons []string
+	for key, n := range counts {
+		if reason, ok := strings.CutPrefix(key, "move:"); ok {
+			reasons = append(reasons, fmt.Sprintf("%s %d", reason, n))
+		}
+	}
+	sort.Strings(reasons)
+
+	lines := []string{header}
+	for _, eventType := range eventTypes {
+		n, ok := counts[eventType]
+		if !ok {
+			continue
+		}
+		line := fmt.Sprintf("• %s: %d", eventType, n)
+		if eventType == "move" && len(reasons) > 0 {
+			line += " (" + strings.Join(reasons, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
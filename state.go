@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepSnapshot is the view of the server the last completed sweep had.
+type sweepSnapshot struct {
+	Time         time.Time      `json:"time"`
+	ServerID     int            `json:"server_id"`
+	AfkChannelID int            `json:"afk_channel_id"`
+	Channels     []*channelInfo `json:"channels"`
+	Clients      []clientStatus `json:"clients"`
+}
+
+var (
+	lastSweepMu sync.RWMutex
+	lastSweep   sweepSnapshot
+)
+
+func recordSweep(snapshot sweepSnapshot) {
+	lastSweepMu.Lock()
+	defer lastSweepMu.Unlock()
+	lastSweep = snapshot
+	if *onceFlag {
+		onceSweeps = append(onceSweeps, snapshot)
+	}
+}
+
+// latestSweep returns the snapshot of the last completed sweep.
+// The snapshot must not be modified.
+func latestSweep() sweepSnapshot {
+	lastSweepMu.RLock()
+	defer lastSweepMu.RUnlock()
+	return lastSweep
+}
+
+// worldState is the body of GET /state, everything the bot knows for scripts and dashboards.
+type worldState struct {
+	Build             buildInfo                 `json:"build"`
+	Pause             pauseStatus               `json:"pause"`
+	LargeSweepPending bool                      `json:"large_sweep_pending"`
+	Sweep             sweepSnapshot             `json:"sweep"`
+	Exemptions        exemptionList             `json:"exemptions"`
+	Overrides         map[string]ClientOverride `json:"overrides"`
+	Settings          map[string]string         `json:"settings"`
+}
+
+// currentState collects the world state. Clients are ordered by ID so the output is stable.
+func currentState() worldState {
+	sweep := latestSweep()
+	clients := make([]clientStatus, len(sweep.Clients))
+	copy(clients, sweep.Clients)
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	sweep.Clients = clients
+	return worldState{
+		Build:             currentBuild(),
+		Pause:             pause.status(clock.Now()),
+		LargeSweepPending: largeSweepPending.Load(),
+		Sweep:             sweep,
+		Exemptions:        currentExemptions(),
+		Overrides:         clientOverrides.all(),
+		Settings:          runtimeSettings.all(),
+	}
+}
+
+// handleState serves GET /state.
+func handleState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, currentState())
+}
+
+// dumpState prints the state of the bot serving the HTTP API at TS3_HTTP_ADDR, for the dump-state command.
+func dumpState() error {
+	env, err := newEnvReader()
+	if err != nil {
+		return err
+	}
+	addr := env.optional("TS3_HTTP_ADDR", "")
+	if addr == "" {
+		return errors.New("TS3_HTTP_ADDR not set")
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/state", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+env.optional("TS3_ADMIN_TOKEN", ""))
+	client := http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bot answered %s", res.Status)
+	}
+	_, err = io.Copy(os.Stdout, res.Body)
+	return err
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchStatsSignal logs the runtime statistics whenever the process receives SIGUSR1.
+func watchStatsSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			select {
+			case statsRequests <- struct{}{}:
+			default:
+			}
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// watchStatsSignal does nothing on Windows, which has no SIGUSR1.
+func watchStatsSignal() {}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdMetrics pushes metrics to a StatsD server over UDP.
+// With dogStatsD enabled, tags are sent using the DogStatsD extension, otherwise they are dropped.
+type statsdMetrics struct {
+	addr      string
+	prefix    string
+	tags      []string
+	dogStatsD bool
+
+	mu   sync.Mutex
+	conn net.Conn
+	// failedAt is the time of the last failed send, the subsystem recovers once sends work for subsystemRetryInterval.
+	failedAt time.Time
+}
+
+// newStatsdMetrics sends to the StatsD server at addr. If its name cannot be resolved yet, metrics
+// are dropped and resolving is retried in the background; only a malformed address is an error.
+func newStatsdMetrics(addr string, prefix string, tags []string, dogStatsD bool) (*statsdMetrics, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	s := &statsdMetrics{addr: addr, prefix: prefix, tags: tags, dogStatsD: dogStatsD}
+	if err := s.dial(); err != nil {
+		go s.redial()
+	}
+	return s, nil
+}
+
+func (s *statsdMetrics) dial() error {
+	conn, err := net.Dial("udp", s.addr)
+	if err != nil {
+		subsystems.degrade(subsystemStatsd, err)
+		return err
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	subsystems.recover(subsystemStatsd)
+	return nil
+}
+
+// redial resolves the StatsD server again until it succeeds.
+func (s *statsdMetrics) redial() {
+	for {
+		time.Sleep(subsystemRetryInterval)
+		if s.dial() == nil {
+			return
+		}
+	}
+}
+
+func (s *statsdMetrics) count(name string, value int64, tags ...string) {
+	s.send(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+func (s *statsdMetrics) gauge(name string, value float64, tags ...string) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+func (s *statsdMetrics) timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", tags)
+}
+
+func (s *statsdMetrics) send(name string, value string, kind string, tags []string) {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if s.dogStatsD && len(s.tags)+len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(append(append([]string{}, s.tags...), tags...), ","))
+	}
+
+	// Metrics are best effort, a missing StatsD server only degrades them.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+	if _, err := s.conn.Write([]byte(b.String())); err != nil {
+		s.failedAt = time.Now()
+		subsystems.degrade(subsystemStatsd, err)
+		return
+	}
+	if !s.failedAt.IsZero() && time.Since(s.failedAt) >= subsystemRetryInterval {
+		// A UDP send fails only after an earlier one was refused, so single successes do not count as recovery.
+		s.failedAt = time.Time{}
+		subsystems.recover(subsystemStatsd)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MoveRecord describes a single move of a client into an AFK channel.
+type MoveRecord struct {
+	UID         string `json:"uid"`
+	Nickname    string `json:"nickname"`
+	FromChannel int    `json:"from_channel"`
+	ToChannel   int    `json:"to_channel"`
+	IdleTimeMs  int    `json:"idle_time_ms"`
+	// MaxIdleTimeMs is the limit that applied to the client when it was moved.
+	MaxIdleTimeMs int `json:"max_idle_time_ms"`
+	// Reason is the reason code of the move, see reasons.go.
+	Reason  string    `json:"reason"`
+	MovedAt time.Time `json:"moved_at"`
+}
+
+// OccupancySnapshot is the number of clients in a channel at one point in time.
+type OccupancySnapshot struct {
+	ServerID int       `json:"server_id"`
+	Channel  string    `json:"channel"`
+	AFK      bool      `json:"afk"`
+	Clients  int       `json:"clients"`
+	TakenAt  time.Time `json:"taken_at"`
+}
+
+// Storage persists the data the bot needs across restarts: move history,
+// per-client overrides (exemptions), the channel each moved client came from,
+// cooldowns, settings changed at runtime and channel occupancy snapshots.
+// Implementations must be safe for concurrent use.
+type Storage interface {
+	RecordMove(record MoveRecord) error
+	// MoveHistory returns up to limit moves of the client, newest first.
+	MoveHistory(uid string, limit int) ([]MoveRecord, error)
+	// Moves returns the moves of all clients, oldest first.
+	Moves() ([]MoveRecord, error)
+
+	Overrides() (map[string]ClientOverride, error)
+	SaveOverride(uid string, override ClientOverride) error
+	DeleteOverride(uid string) error
+
+	SetHomeChannel(uid string, channelId int) error
+	// HomeChannel returns the channel the client was in before the bot moved it, or 0 if unknown.
+	HomeChannel(uid string) (int, error)
+	// HomeChannels returns the home channels of all clients, by unique identifier.
+	HomeChannels() (map[string]int, error)
+	DeleteHomeChannel(uid string) error
+
+	// SetCooldown records that a cooldown of the given kind applies to the client until the given time.
+	SetCooldown(kind string, uid string, until time.Time) error
+	// Cooldowns returns the clients a cooldown of the given kind currently applies to, with the time it ends.
+	Cooldowns(kind string) (map[string]time.Time, error)
+
+	// Settings returns the settings changed at runtime, by name.
+	Settings() (map[string]string, error)
+	SaveSetting(name string, value string) error
+	DeleteSetting(name string) error
+
+	RecordOccupancy(snapshots []OccupancySnapshot) error
+	// OccupancyHistory returns the snapshots taken at or after since, oldest first.
+	OccupancyHistory(since time.Time) ([]OccupancySnapshot, error)
+	// DeleteOccupancy drops the snapshots taken before the given time.
+	DeleteOccupancy(before time.Time) error
+
+	// AddCounters adds to the named cumulative counters, starting those missing at 0.
+	AddCounters(deltas map[string]int64) error
+	// Counters returns the cumulative counters by name.
+	Counters() (map[string]int64, error)
+
+	Close() error
+}
+
+var storage Storage = newMemoryStorage()
+
+// sortMoves orders moves from oldest to newest, keeping the order of moves made at the same time.
+func sortMoves(moves []MoveRecord) {
+	sort.SliceStable(moves, func(i, j int) bool { return moves[i].MovedAt.Before(moves[j].MovedAt) })
+}
+
+// openStorage opens the storage backend selected by kind.
+// dsn is a file path for the embedded backends and a connection string for postgres and redis.
+func openStorage(kind string, dsn string) (Storage, error) {
+	switch kind {
+	case "", "memory":
+		return newMemoryStorage(), nil
+	case "bbolt":
+		return openBoltStorage(dsn)
+	case "sqlite":
+		return openSQLStorage(sqliteDialect, dsn)
+	case "postgres":
+		return openSQLStorage(postgresDialect, dsn)
+	case "redis":
+		return openRedisStorage(dsn)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", kind)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"strconv"
+	"time"
+)
+
+var (
+	boltMovesBucket        = []byte("moves")
+	boltOverridesBucket    = []byte("overrides")
+	boltHomeChannelsBucket = []byte("home_channels")
+	boltSettingsBucket     = []byte("settings")
+	boltCooldownsBucket    = []byte("cooldowns")
+	boltOccupancyBucket    = []byte("occupancy")
+	boltCountersBucket     = []byte("counters")
+)
+
+// boltStorage stores everything in a single bbolt file.
+// Moves are kept in one nested bucket per client, keyed by a sequence number.
+// Occupancy snapshots are keyed by the time they were taken followed by a sequence number, so they sort by time.
+type boltStorage struct {
+	db *bbolt.DB
+}
+
+func openBoltStorage(path string) (*boltStorage, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{boltMovesBucket, boltOverridesBucket, boltHomeChannelsBucket, boltSettingsBucket, boltCooldownsBucket, boltOccupancyBucket, boltCountersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStorage{db: db}, nil
+}
+
+func (s *boltStorage) RecordMove(record MoveRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.Bucket(boltMovesBucket).CreateBucketIfNotExists([]byte(record.UID))
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bucket.Put(key, data)
+	})
+}
+
+func (s *boltStorage) MoveHistory(uid string, limit int) ([]MoveRecord, error) {
+	var history []MoveRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltMovesBucket).Bucket([]byte(uid))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil && len(history) < limit; k, v = cursor.Prev() {
+			var record MoveRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			history = append(history, record)
+		}
+		return nil
+	})
+	return history, err
+}
+
+func (s *boltStorage) Moves() ([]MoveRecord, error) {
+	var moves []MoveRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(boltMovesBucket)
+		return root.ForEach(func(uid, _ []byte) error {
+			return root.Bucket(uid).ForEach(func(_, v []byte) error {
+				var record MoveRecord
+				if err := json.Unmarshal(v, &record); err != nil {
+					return err
+				}
+				moves = append(moves, record)
+				return nil
+			})
+		})
+	})
+	sortMoves(moves)
+	return moves, err
+}
+
+func (s *boltStorage) Overrides() (map[string]ClientOverride, error) {
+	overrides := make(map[string]ClientOverride)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltOverridesBucket).ForEach(func(k, v []byte) error {
+			var override ClientOverride
+			if err := json.Unmarshal(v, &override); err != nil {
+				return err
+			}
+			overrides[string(k)] = override
+			return nil
+		})
+	})
+	return overrides, err
+}
+
+func (s *boltStorage) SaveOverride(uid string, override ClientOverride) error {
+	data, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltOverridesBucket).Put([]byte(uid), data)
+	})
+}
+
+func (s *boltStorage) DeleteOverride(uid string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltOverridesBucket).Delete([]byte(uid))
+	})
+}
+
+func (s *boltStorage) SetHomeChannel(uid string, channelId int) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltHomeChannelsBucket).Put([]byte(uid), []byte(strconv.Itoa(channelId)))
+	})
+}
+
+func (s *boltStorage) HomeChannel(uid string) (int, error) {
+	var channelId int
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(boltHomeChannelsBucket).Get([]byte(uid))
+		if value == nil {
+			return nil
+		}
+		var err error
+		channelId, err = strconv.Atoi(string(value))
+		return err
+	})
+	return channelId, err
+}
+
+func (s *boltStorage) HomeChannels() (map[string]int, error) {
+	homeChannels := make(map[string]int)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltHomeChannelsBucket).ForEach(func(k, v []byte) error {
+			channelId, err := strconv.Atoi(string(v))
+			if err != nil {
+				return err
+			}
+			homeChannels[string(k)] = channelId
+			return nil
+		})
+	})
+	return homeChannels, err
+}
+
+func (s *boltStorage) DeleteHomeChannel(uid string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltHomeChannelsBucket).Delete([]byte(uid))
+	})
+}
+
+// SetCooldown keeps the cooldowns in one nested bucket per kind, with the end as Unix milliseconds.
+func (s *boltStorage) SetCooldown(kind string, uid string, until time.Time) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.Bucket(boltCooldownsBucket).CreateBucketIfNotExists([]byte(kind))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(uid), []byte(strconv.FormatInt(until.UnixMilli(), 10)))
+	})
+}
+
+func (s *boltStorage) Cooldowns(kind string) (map[string]time.Time, error) {
+	now := clock.Now()
+	cooldowns := make(map[string]time.Time)
+	var expired [][]byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltCooldownsBucket).Bucket([]byte(kind))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			ms, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return err
+			}
+			if until := time.UnixMilli(ms); until.After(now) {
+				cooldowns[string(k)] = until
+			} else {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+	})
+	if err != nil || len(expired) == 0 {
+		return cooldowns, err
+	}
+	return cooldowns, s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltCooldownsBucket).Bucket([]byte(kind))
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) Settings() (map[string]string, error) {
+	settings := make(map[string]string)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltSettingsBucket).ForEach(func(k, v []byte) error {
+			settings[string(k)] = string(v)
+			return nil
+		})
+	})
+	return settings, err
+}
+
+func (s *boltStorage) SaveSetting(name string, value string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltSettingsBucket).Put([]byte(name), []byte(value))
+	})
+}
+
+func (s *boltStorage) DeleteSetting(name string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltSettingsBucket).Delete([]byte(name))
+	})
+}
+
+func (s *boltStorage) RecordOccupancy(snapshots []OccupancySnapshot) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltOccupancyBucket)
+		for _, snapshot := range snapshots {
+			data, err := json.Marshal(snapshot)
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := append(boltTimeKey(snapshot.TakenAt), make([]byte, 8)...)
+			binary.BigEndian.PutUint64(key[8:], seq)
+			if err = bucket.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// boltTimeKey returns the key prefix of the snapshots taken at t. Times before 1970 sort first.
+func boltTimeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	if ms := t.UnixMilli(); ms > 0 {
+		binary.BigEndian.PutUint64(key, uint64(ms))
+	}
+	return key
+}
+
+func (s *boltStorage) OccupancyHistory(since time.Time) ([]OccupancySnapshot, error) {
+	var history []OccupancySnapshot
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(boltOccupancyBucket).Cursor()
+		for k, v := cursor.Seek(boltTimeKey(since)); k != nil; k, v = cursor.Next() {
+			var snapshot OccupancySnapshot
+			if err := json.Unmarshal(v, &snapshot); err != nil {
+				return err
+			}
+			history = append(history, snapshot)
+		}
+		return nil
+	})
+	return history, err
+}
+
+func (s *boltStorage) DeleteOccupancy(before time.Time) error {
+	end := boltTimeKey(before)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(boltOccupancyBucket).Cursor()
+		for k, _ := cursor.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = cursor.First() {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) AddCounters(deltas map[string]int64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltCountersBucket)
+		for name, delta := range deltas {
+			var value int64
+			if stored := bucket.Get([]byte(name)); stored != nil {
+				var err error
+				if value, err = strconv.ParseInt(string(stored), 10, 64); err != nil {
+					return err
+				}
+			}
+			if err := bucket.Put([]byte(name), []byte(strconv.FormatInt(value+delta, 10))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) Counters() (map[string]int64, error) {
+	counters := make(map[string]int64)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltCountersBucket).ForEach(func(k, v []byte) error {
+			value, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return err
+			}
+			counters[string(k)] = value
+			return nil
+		})
+	})
+	return counters, err
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
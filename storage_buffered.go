@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// storageWriteTimeout is how long a write may take before the backend counts as degraded.
+const storageWriteTimeout = 10 * time.Second
+
+// bufferedStorage makes writes in the background, in order, so neither a failing nor a hanging storage
+// backend, e.g. PostgreSQL or Redis, holds up sweeps. Writes the backend rejects are held back, up to
+// limit of them, and made once it works again, so an outage does not lose moves or settings. Reads go
+// to the backend and fail while it does; otherwise writes still waiting to be made are returned as made.
+type bufferedStorage struct {
+	Storage
+	limit int
+
+	mu      sync.Mutex
+	pending []pendingWrite
+	// applying is set while the first pending write is being made, it is not dropped then.
+	applying bool
+	dropped  int
+	// queued is signalled when a write was queued.
+	queued chan struct{}
+
+	// flushMu serializes flush, which run and Close both call.
+	flushMu sync.Mutex
+	// inFlight receives the result of the first pending write while it is being made. A write that
+	// took longer than storageWriteTimeout is waited for rather than sent again, so it is not made twice.
+	inFlight chan error
+}
+
+// pendingWrite is a write that has not been made yet.
+type pendingWrite struct {
+	what  string
+	apply func(Storage) error
+	// kind and key identify the writes reads return before they are made. value is what was written,
+	// nil for a deletion.
+	kind  string
+	key   string
+	value interface{}
+}
+
+// Kinds of pending writes that reads return. Cooldowns are kept apart by their kind, e.g. "cooldown/manual_move".
+const (
+	pendingMove     = "move"
+	pendingHome     = "home"
+	pendingOverride = "override"
+	pendingSetting  = "setting"
+	pendingCooldown = "cooldown/"
+	pendingCounters = "counters"
+)
+
+func newBufferedStorage(backend Storage, limit int) *bufferedStorage {
+	return &bufferedStorage{Storage: backend, limit: limit, queued: make(chan struct{}, 1)}
+}
+
+// write queues a write for run and returns right away; queued writes count as made.
+func (b *bufferedStorage) write(w pendingWrite) error {
+	b.mu.Lock()
+	b.pending = append(b.pending, w)
+	if len(b.pending) > b.limit {
+		oldest := 0
+		if b.applying {
+			oldest = 1
+		}
+		zap.S().Errorf("Storage write buffer is full, dropping %s", b.pending[oldest].what)
+		b.pending = append(b.pending[:oldest], b.pending[oldest+1:]...)
+		b.dropped++
+		metrics.count("storage.dropped", 1)
+	}
+	subsystems.buffer(subsystemStorage, len(b.pending), b.dropped)
+	b.mu.Unlock()
+	select {
+	case b.queued <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pendingValues returns the values of the pending writes of kind by key, the last one for each key.
+// Reads have to take them before asking the backend, a write made in between is then in both.
+func (b *bufferedStorage) pendingValues(kind string) map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	values := make(map[string]interface{})
+	for _, w := range b.pending {
+		if w.kind == kind {
+			values[w.key] = w.value
+		}
+	}
+	return values
+}
+
+// pendingList returns the values of all pending writes of kind, oldest first.
+func (b *bufferedStorage) pendingList(kind string) []interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var values []interface{}
+	for _, w := range b.pending {
+		if w.kind == kind {
+			values = append(values, w.value)
+		}
+	}
+	return values
+}
+
+// unstoredMoves returns the pending moves that are not in stored yet, dropping those written while
+// stored was read.
+func unstoredMoves(pending []interface{}, stored []MoveRecord) []MoveRecord {
+	if len(pending) == 0 {
+		return nil
+	}
+	type moveKey struct {
+		uid string
+		at  int64
+	}
+	seen := make(map[moveKey]bool, len(stored))
+	for _, record := range stored {
+		seen[moveKey{record.UID, record.MovedAt.UnixNano()}] = true
+	}
+	var moves []MoveRecord
+	for _, value := range pending {
+		record := value.(MoveRecord)
+		if !seen[moveKey{record.UID, record.MovedAt.UnixNano()}] {
+			moves = append(moves, record)
+		}
+	}
+	return moves
+}
+
+// flush makes the pending writes in order without holding up write, until the backend rejects one
+// or does not answer in time. It reports whether all of them were made.
+func (b *bufferedStorage) flush() bool {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	for {
+		b.mu.Lock()
+		if len(b.pending) == 0 {
+			b.dropped = 0
+			b.mu.Unlock()
+			subsystems.recover(subsystemStorage)
+			return true
+		}
+		next := b.pending[0]
+		b.applying = true
+		b.mu.Unlock()
+
+		if b.inFlight == nil {
+			b.inFlight = make(chan error, 1)
+			go func(done chan<- error) { done <- next.apply(b.Storage) }(b.inFlight)
+		}
+		var err error
+		select {
+		case err = <-b.inFlight:
+			b.inFlight = nil
+		case <-time.After(storageWriteTimeout):
+			err = fmt.Errorf("%s took longer than %v", next.what, storageWriteTimeout)
+		}
+
+		b.mu.Lock()
+		if err == nil {
+			b.pending = b.pending[1:]
+		}
+		b.applying = b.inFlight != nil
+		pending, dropped := len(b.pending), b.dropped
+		b.mu.Unlock()
+		if err != nil {
+			subsystems.degrade(subsystemStorage, err)
+			subsystems.buffer(subsystemStorage, pending, dropped)
+			return false
+		}
+	}
+}
+
+// run makes the queued writes as they come in, and retries every subsystemRetryInterval while the backend fails.
+func (b *bufferedStorage) run() {
+	for {
+		if b.flush() {
+			<-b.queued
+			continue
+		}
+		time.Sleep(subsystemRetryInterval)
+	}
+}
+
+// Close makes a last attempt at the pending writes before closing the backend.
+func (b *bufferedStorage) Close() error {
+	b.flush()
+	b.mu.Lock()
+	lost := len(b.pending)
+	b.mu.Unlock()
+	if lost > 0 {
+		zap.S().Errorf("Storage backend is still failing, %d writes are lost", lost)
+	}
+	return b.Storage.Close()
+}
+
+func (b *bufferedStorage) RecordMove(record MoveRecord) error {
+	return b.write(pendingWrite{what: "move of " + record.UID, kind: pendingMove, key: record.UID, value: record,
+		apply: func(s Storage) error { return s.RecordMove(record) }})
+}
+
+func (b *bufferedStorage) MoveHistory(uid string, limit int) ([]MoveRecord, error) {
+	pending := b.pendingList(pendingMove)
+	history, err := b.Storage.MoveHistory(uid, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range unstoredMoves(pending, history) {
+		if record.UID == uid {
+			history = append([]MoveRecord{record}, history...)
+		}
+	}
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return history, nil
+}
+
+func (b *bufferedStorage) Moves() ([]MoveRecord, error) {
+	pending := b.pendingList(pendingMove)
+	moves, err := b.Storage.Moves()
+	if err != nil {
+		return nil, err
+	}
+	moves = append(moves, unstoredMoves(pending, moves)...)
+	sortMoves(moves)
+	return moves, nil
+}
+
+func (b *bufferedStorage) SaveOverride(uid string, override ClientOverride) error {
+	return b.write(pendingWrite{what: "override of " + uid, kind: pendingOverride, key: uid, value: override,
+		apply: func(s Storage) error { return s.SaveOverride(uid, override) }})
+}
+
+func (b *bufferedStorage) DeleteOverride(uid string) error {
+	return b.write(pendingWrite{what: "override deletion of " + uid, kind: pendingOverride, key: uid,
+		apply: func(s Storage) error { return s.DeleteOverride(uid) }})
+}
+
+func (b *bufferedStorage) Overrides() (map[string]ClientOverride, error) {
+	pending := b.pendingValues(pendingOverride)
+	overrides, err := b.Storage.Overrides()
+	if err != nil {
+		return nil, err
+	}
+	for uid, value := range pending {
+		if value == nil {
+			delete(overrides, uid)
+		} else {
+			overrides[uid] = value.(ClientOverride)
+		}
+	}
+	return overrides, nil
+}
+
+func (b *bufferedStorage) SetHomeChannel(uid string, channelId int) error {
+	return b.write(pendingWrite{what: "home channel of " + uid, kind: pendingHome, key: uid, value: channelId,
+		apply: func(s Storage) error { return s.SetHomeChannel(uid, channelId) }})
+}
+
+func (b *bufferedStorage) DeleteHomeChannel(uid string) error {
+	return b.write(pendingWrite{what: "home channel deletion of " + uid, kind: pendingHome, key: uid,
+		apply: func(s Storage) error { return s.DeleteHomeChannel(uid) }})
+}
+
+func (b *bufferedStorage) HomeChannel(uid string) (int, error) {
+	if value, ok := b.pendingValues(pendingHome)[uid]; ok {
+		if value == nil {
+			return 0, nil
+		}
+		return value.(int), nil
+	}
+	return b.Storage.HomeChannel(uid)
+}
+
+func (b *bufferedStorage) HomeChannels() (map[string]int, error) {
+	pending := b.pendingValues(pendingHome)
+	homes, err := b.Storage.HomeChannels()
+	if err != nil {
+		return nil, err
+	}
+	for uid, value := range pending {
+		if value == nil {
+			delete(homes, uid)
+		} else {
+			homes[uid] = value.(int)
+		}
+	}
+	return homes, nil
+}
+
+func (b *bufferedStorage) SetCooldown(kind string, uid string, until time.Time) error {
+	return b.write(pendingWrite{what: fmt.Sprintf("%s cooldown of %s", kind, uid), kind: pendingCooldown + kind, key: uid, value: until,
+		apply: func(s Storage) error { return s.SetCooldown(kind, uid, until) }})
+}
+
+func (b *bufferedStorage) Cooldowns(kind string) (map[string]time.Time, error) {
+	pending := b.pendingValues(pendingCooldown + kind)
+	cooldowns, err := b.Storage.Cooldowns(kind)
+	if err != nil {
+		return nil, err
+	}
+	now := clock.Now()
+	for uid, value := range pending {
+		if until := value.(time.Time); until.After(now) {
+			cooldowns[uid] = until
+		} else {
+			delete(cooldowns, uid)
+		}
+	}
+	return cooldowns, nil
+}
+
+func (b *bufferedStorage) SaveSetting(name string, value string) error {
+	return b.write(pendingWrite{what: "setting " + name, kind: pendingSetting, key: name, value: value,
+		apply: func(s Storage) error { return s.SaveSetting(name, value) }})
+}
+
+func (b *bufferedStorage) DeleteSetting(name string) error {
+	return b.write(pendingWrite{what: "setting deletion of " + name, kind: pendingSetting, key: name,
+		apply: func(s Storage) error { return s.DeleteSetting(name) }})
+}
+
+func (b *bufferedStorage) Settings() (map[string]string, error) {
+	pending := b.pendingValues(pendingSetting)
+	settings, err := b.Storage.Settings()
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range pending {
+		if value == nil {
+			delete(settings, name)
+		} else {
+			settings[name] = value.(string)
+		}
+	}
+	return settings, nil
+}
+
+func (b *bufferedStorage) RecordOccupancy(snapshots []OccupancySnapshot) error {
+	return b.write(pendingWrite{what: fmt.Sprintf("%d occupancy snapshots", len(snapshots)), apply: func(s Storage) error { return s.RecordOccupancy(snapshots) }})
+}
+
+func (b *bufferedStorage) DeleteOccupancy(before time.Time) error {
+	return b.write(pendingWrite{what: "occupancy cleanup", apply: func(s Storage) error { return s.DeleteOccupancy(before) }})
+}
+
+func (b *bufferedStorage) AddCounters(deltas map[string]int64) error {
+	return b.write(pendingWrite{what: "counters", kind: pendingCounters, value: deltas,
+		apply: func(s Storage) error { return s.AddCounters(deltas) }})
+}
+
+// Counters adds the pending deltas to the stored counters. Deltas written while the counters are read
+// are counted twice until the next read.
+func (b *bufferedStorage) Counters() (map[string]int64, error) {
+	pending := b.pendingList(pendingCounters)
+	counters, err := b.Storage.Counters()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range pending {
+		for name, delta := range value.(map[string]int64) {
+			counters[name] += delta
+		}
+	}
+	return counters, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stuckStorage is a backend whose move writes hang until release is closed and whose home channel writes fail while fail is set.
+type stuckStorage struct {
+	*memoryStorage
+	release chan struct{}
+	fail    error
+}
+
+func (s *stuckStorage) RecordMove(record MoveRecord) error {
+	<-s.release
+	return s.memoryStorage.RecordMove(record)
+}
+
+func (s *stuckStorage) SetHomeChannel(uid string, channelId int) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	return s.memoryStorage.SetHomeChannel(uid, channelId)
+}
+
+func newStuckStorage(t *testing.T) *stuckStorage {
+	subsystems.recover(subsystemStorage)
+	t.Cleanup(func() { subsystems.recover(subsystemStorage) })
+	return &stuckStorage{memoryStorage: newMemoryStorage(), release: make(chan struct{})}
+}
+
+func TestBufferedStorageDoesNotWaitForHangingBackend(t *testing.T) {
+	backend := newStuckStorage(t)
+	buffered := newBufferedStorage(backend, 10)
+	go buffered.run()
+
+	start := time.Now()
+	for _, uid := range []string{"a", "b", "c"} {
+		if err := buffered.RecordMove(MoveRecord{UID: uid, MovedAt: time.Now()}); err != nil {
+			t.Fatalf("RecordMove: %v", err)
+		}
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("writes waited %v for the backend", took)
+	}
+
+	close(backend.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		moves, _ := backend.Moves()
+		if len(moves) == 3 {
+			if moves[0].UID != "a" || moves[1].UID != "b" || moves[2].UID != "c" {
+				t.Fatalf("moves made out of order: %v", moves)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of 3 moves were made", len(moves))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBufferedStorageReportsFailures(t *testing.T) {
+	backend := newStuckStorage(t)
+	backend.fail = errors.New("connection refused")
+	buffered := newBufferedStorage(backend, 10)
+
+	// Without run, flush makes the writes.
+	if err := buffered.SetHomeChannel("a", 1); err != nil {
+		t.Fatalf("SetHomeChannel: %v", err)
+	}
+	if buffered.flush() {
+		t.Fatal("flush made a write the backend rejected")
+	}
+	if list := subsystems.list(); len(list) != 1 || list[0].Name != subsystemStorage {
+		t.Fatalf("degraded subsystems = %v, want storage", list)
+	}
+
+	backend.fail = nil
+	if !buffered.flush() {
+		t.Fatal("flush failed after the backend recovered")
+	}
+	if list := subsystems.list(); len(list) != 0 {
+		t.Fatalf("storage still degraded after a write went through: %v", list)
+	}
+	if stored, _ := backend.memoryStorage.HomeChannel("a"); stored != 1 {
+		t.Errorf("held back write was not made")
+	}
+}
+
+func TestStorageBufferMustNotBeZero(t *testing.T) {
+	loadTestConfig(t, nil)
+	t.Setenv("TS3_STORAGE_BUFFER", "0")
+	if _, err := loadConfigFromEnv(); err == nil || !strings.Contains(err.Error(), "TS3_STORAGE_BUFFER") {
+		t.Fatalf("loading config with TS3_STORAGE_BUFFER=0: %v", err)
+	}
+}
+
+func TestBufferedStorageReadsPendingWrites(t *testing.T) {
+	backend := newStuckStorage(t)
+	backend.memoryStorage.SetHomeChannel("a", 1)
+	backend.memoryStorage.SetHomeChannel("b", 2)
+	backend.memoryStorage.RecordMove(MoveRecord{UID: "a", MovedAt: time.Now().Add(-time.Hour)})
+	backend.memoryStorage.AddCounters(map[string]int64{"moves": 1})
+	buffered := newBufferedStorage(backend, 10)
+
+	// Without run, the writes stay pending.
+	buffered.SetHomeChannel("a", 5)
+	buffered.SetHomeChannel("c", 7)
+	buffered.DeleteHomeChannel("b")
+	buffered.SetCooldown(cooldownManualMove, "a", time.Now().Add(time.Hour))
+	buffered.SaveSetting("max_idle_time", "600")
+	buffered.RecordMove(MoveRecord{UID: "a", MovedAt: time.Now()})
+	buffered.RecordMove(MoveRecord{UID: "b", MovedAt: time.Now()})
+	buffered.AddCounters(map[string]int64{"moves": 2})
+
+	for uid, want := range map[string]int{"a": 5, "b": 0, "c": 7} {
+		if home, err := buffered.HomeChannel(uid); err != nil || home != want {
+			t.Errorf("HomeChannel(%s) = %d, %v, want %d", uid, home, err, want)
+		}
+	}
+	homes, err := buffered.HomeChannels()
+	if err != nil || len(homes) != 2 || homes["a"] != 5 || homes["c"] != 7 {
+		t.Errorf("HomeChannels() = %v, %v", homes, err)
+	}
+	if cooldowns, err := buffered.Cooldowns(cooldownManualMove); err != nil || len(cooldowns) != 1 {
+		t.Errorf("Cooldowns() = %v, %v", cooldowns, err)
+	}
+	if settings, err := buffered.Settings(); err != nil || settings["max_idle_time"] != "600" {
+		t.Errorf("Settings() = %v, %v", settings, err)
+	}
+	if history, err := buffered.MoveHistory("a", 10); err != nil || len(history) != 2 || !history[0].MovedAt.After(history[1].MovedAt) {
+		t.Errorf("MoveHistory() = %v, %v, want the pending move first", history, err)
+	}
+	if history, err := buffered.MoveHistory("a", 1); err != nil || len(history) != 1 {
+		t.Errorf("MoveHistory() with limit 1 = %v, %v", history, err)
+	}
+	if moves, err := buffered.Moves(); err != nil || len(moves) != 3 {
+		t.Errorf("Moves() = %v, %v", moves, err)
+	}
+	if counters, err := buffered.Counters(); err != nil || counters["moves"] != 3 {
+		t.Errorf("Counters() = %v, %v", counters, err)
+	}
+
+	// A pending move written while the moves are read is returned once.
+	record := MoveRecord{UID: "c", MovedAt: time.Now()}
+	buffered.RecordMove(record)
+	backend.memoryStorage.RecordMove(record)
+	if moves, err := buffered.Moves(); err != nil || len(moves) != 4 {
+		t.Errorf("Moves() = %v, %v, want the move written meanwhile once", moves, err)
+	}
+	if stored, _ := backend.memoryStorage.HomeChannel("a"); stored != 1 {
+		t.Errorf("pending write reached the backend early")
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryHistoryLimit caps the number of moves kept per client by the memory storage.
+const memoryHistoryLimit = 100
+
+// memoryOccupancyLimit caps the number of occupancy snapshots kept by the memory storage.
+const memoryOccupancyLimit = 100000
+
+// memoryStorage keeps everything in memory and loses it on restart.
+// It is used when no storage backend is configured.
+type memoryStorage struct {
+	mu           sync.Mutex
+	moves        map[string][]MoveRecord
+	overrides    map[string]ClientOverride
+	homeChannels map[string]int
+	settings     map[string]string
+	cooldowns    map[string]map[string]time.Time
+	occupancy    []OccupancySnapshot
+	counters     map[string]int64
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		moves:        make(map[string][]MoveRecord),
+		overrides:    make(map[string]ClientOverride),
+		homeChannels: make(map[string]int),
+		settings:     make(map[string]string),
+		cooldowns:    make(map[string]map[string]time.Time),
+		counters:     make(map[string]int64),
+	}
+}
+
+func (s *memoryStorage) RecordMove(record MoveRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moves := append(s.moves[record.UID], record)
+	if len(moves) > memoryHistoryLimit {
+		moves = moves[len(moves)-memoryHistoryLimit:]
+	}
+	s.moves[record.UID] = moves
+	return nil
+}
+
+func (s *memoryStorage) MoveHistory(uid string, limit int) ([]MoveRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moves := s.moves[uid]
+	var history []MoveRecord
+	for i := len(moves) - 1; i >= 0 && len(history) < limit; i-- {
+		history = append(history, moves[i])
+	}
+	return history, nil
+}
+
+func (s *memoryStorage) Moves() ([]MoveRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var moves []MoveRecord
+	for _, history := range s.moves {
+		moves = append(moves, history...)
+	}
+	sortMoves(moves)
+	return moves, nil
+}
+
+func (s *memoryStorage) Overrides() (map[string]ClientOverride, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	overrides := make(map[string]ClientOverride, len(s.overrides))
+	for uid, override := range s.overrides {
+		overrides[uid] = override
+	}
+	return overrides, nil
+}
+
+func (s *memoryStorage) SaveOverride(uid string, override ClientOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[uid] = override
+	return nil
+}
+
+func (s *memoryStorage) DeleteOverride(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.overrides, uid)
+	return nil
+}
+
+func (s *memoryStorage) SetHomeChannel(uid string, channelId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.homeChannels[uid] = channelId
+	return nil
+}
+
+func (s *memoryStorage) HomeChannel(uid string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.homeChannels[uid], nil
+}
+
+func (s *memoryStorage) HomeChannels() (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	homeChannels := make(map[string]int, len(s.homeChannels))
+	for uid, channelId := range s.homeChannels {
+		homeChannels[uid] = channelId
+	}
+	return homeChannels, nil
+}
+
+func (s *memoryStorage) DeleteHomeChannel(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.homeChannels, uid)
+	return nil
+}
+
+func (s *memoryStorage) SetCooldown(kind string, uid string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cooldowns[kind] == nil {
+		s.cooldowns[kind] = make(map[string]time.Time)
+	}
+	s.cooldowns[kind][uid] = until
+	return nil
+}
+
+func (s *memoryStorage) Cooldowns(kind string) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := clock.Now()
+	cooldowns := make(map[string]time.Time)
+	for uid, until := range s.cooldowns[kind] {
+		if !until.After(now) {
+			delete(s.cooldowns[kind], uid)
+			continue
+		}
+		cooldowns[uid] = until
+	}
+	return cooldowns, nil
+}
+
+func (s *memoryStorage) Settings() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings := make(map[string]string, len(s.settings))
+	for name, value := range s.settings {
+		settings[name] = value
+	}
+	return settings, nil
+}
+
+func (s *memoryStorage) SaveSetting(name string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[name] = value
+	return nil
+}
+
+func (s *memoryStorage) DeleteSetting(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.settings, name)
+	return nil
+}
+
+func (s *memoryStorage) RecordOccupancy(snapshots []OccupancySnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.occupancy = append(s.occupancy, snapshots...)
+	if len(s.occupancy) > memoryOccupancyLimit {
+		s.occupancy = append([]OccupancySnapshot(nil), s.occupancy[len(s.occupancy)-memoryOccupancyLimit:]...)
+	}
+	return nil
+}
+
+func (s *memoryStorage) OccupancyHistory(since time.Time) ([]OccupancySnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var history []OccupancySnapshot
+	for _, snapshot := range s.occupancy {
+		if !snapshot.TakenAt.Before(since) {
+			history = append(history, snapshot)
+		}
+	}
+	return history, nil
+}
+
+func (s *memoryStorage) DeleteOccupancy(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.occupancy[:0]
+	for _, snapshot := range s.occupancy {
+		if !snapshot.TakenAt.Before(before) {
+			kept = append(kept, snapshot)
+		}
+	}
+	s.occupancy = kept
+	return nil
+}
+
+func (s *memoryStorage) AddCounters(deltas map[string]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, delta := range deltas {
+		s.counters[name] += delta
+	}
+	return nil
+}
+
+func (s *memoryStorage) Counters() (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counters := make(map[string]int64, len(s.counters))
+	for name, value := range s.counters {
+		counters[name] = value
+	}
+	return counters, nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
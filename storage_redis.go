@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces all keys, so the bot can share a Redis database with other applications.
+const redisKeyPrefix = "ts3automove:"
+
+// redisHistoryLimit caps the number of moves kept per client.
+const redisHistoryLimit = 100
+
+// redisTimeout bounds every Redis command, so an unreachable server does not hold up a sweep for long.
+const redisTimeout = 5 * time.Second
+
+// redisStorage stores everything in Redis, so several bot instances, e.g. an HA pair or one per
+// virtual server, share exemptions, home channels and cooldowns.
+// Moves are kept in one list per client, newest first, overrides, home channels and settings in
+// one hash each, and cooldowns in one sorted set per kind, scored by their end.
+// Occupancy snapshots are kept in one sorted set, scored by the time they were taken, and counters in one hash.
+type redisStorage struct {
+	client *redis.Client
+}
+
+func openRedisStorage(dsn string) (*redisStorage, error) {
+	options, err := redis.ParseURL(dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := &redisStorage{client: redis.NewClient(options)}
+	ctx, cancel := s.context()
+	defer cancel()
+	if err = s.client.Ping(ctx).Err(); err != nil {
+		s.client.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *redisStorage) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), redisTimeout)
+}
+
+func (s *redisStorage) RecordMove(record MoveRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	key := redisKeyPrefix + "moves:" + record.UID
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, data)
+		pipe.LTrim(ctx, key, 0, redisHistoryLimit-1)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStorage) MoveHistory(uid string, limit int) ([]MoveRecord, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	values, err := s.client.LRange(ctx, redisKeyPrefix+"moves:"+uid, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	history := make([]MoveRecord, 0, len(values))
+	for _, value := range values {
+		var record MoveRecord
+		if err = json.Unmarshal([]byte(value), &record); err != nil {
+			return nil, err
+		}
+		history = append(history, record)
+	}
+	return history, nil
+}
+
+// Moves scans for the move lists of all clients. Each list is newest first.
+func (s *redisStorage) Moves() ([]MoveRecord, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	var moves []MoveRecord
+	iter := s.client.Scan(ctx, 0, redisKeyPrefix+"moves:*", 0).Iterator()
+	for iter.Next(ctx) {
+		values, err := s.client.LRange(ctx, iter.Val(), 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i := len(values) - 1; i >= 0; i-- {
+			var record MoveRecord
+			if err = json.Unmarshal([]byte(values[i]), &record); err != nil {
+				return nil, err
+			}
+			moves = append(moves, record)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sortMoves(moves)
+	return moves, nil
+}
+
+func (s *redisStorage) Overrides() (map[string]ClientOverride, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	values, err := s.client.HGetAll(ctx, redisKeyPrefix+"overrides").Result()
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]ClientOverride, len(values))
+	for uid, value := range values {
+		var override ClientOverride
+		if err = json.Unmarshal([]byte(value), &override); err != nil {
+			return nil, err
+		}
+		overrides[uid] = override
+	}
+	return overrides, nil
+}
+
+func (s *redisStorage) SaveOverride(uid string, override ClientOverride) error {
+	data, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.HSet(ctx, redisKeyPrefix+"overrides", uid, data).Err()
+}
+
+func (s *redisStorage) DeleteOverride(uid string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.HDel(ctx, redisKeyPrefix+"overrides", uid).Err()
+}
+
+func (s *redisStorage) SetHomeChannel(uid string, channelId int) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.HSet(ctx, redisKeyPrefix+"home_channels", uid, channelId).Err()
+}
+
+func (s *redisStorage) HomeChannel(uid string) (int, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	channelId, err := s.client.HGet(ctx, redisKeyPrefix+"home_channels", uid).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return channelId, err
+}
+
+func (s *redisStorage) HomeChannels() (map[string]int, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	values, err := s.client.HGetAll(ctx, redisKeyPrefix+"home_channels").Result()
+	if err != nil {
+		return nil, err
+	}
+	homeChannels := make(map[string]int, len(values))
+	for uid, value := range values {
+		if homeChannels[uid], err = strconv.Atoi(value); err != nil {
+			return nil, err
+		}
+	}
+	return homeChannels, nil
+}
+
+func (s *redisStorage) DeleteHomeChannel(uid string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.HDel(ctx, redisKeyPrefix+"home_channels", uid).Err()
+}
+
+func (s *redisStorage) SetCooldown(kind string, uid string, until time.Time) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.ZAdd(ctx, redisKeyPrefix+"cooldowns:"+kind, redis.Z{Score: float64(until.UnixMilli()), Member: uid}).Err()
+}
+
+func (s *redisStorage) Cooldowns(kind string) (map[string]time.Time, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	key := redisKeyPrefix + "cooldowns:" + kind
+	now := strconv.FormatInt(clock.Now().UnixMilli(), 10)
+	if err := s.client.ZRemRangeByScore(ctx, key, "-inf", now).Err(); err != nil {
+		return nil, err
+	}
+	members, err := s.client.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	cooldowns := make(map[string]time.Time, len(members))
+	for _, member := range members {
+		cooldowns[member.Member.(string)] = time.UnixMilli(int64(member.Score))
+	}
+	return cooldowns, nil
+}
+
+func (s *redisStorage) Settings() (map[string]string, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.HGetAll(ctx, redisKeyPrefix+"settings").Result()
+}
+
+func (s *redisStorage) SaveSetting(name string, value string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.HSet(ctx, redisKeyPrefix+"settings", name, value).Err()
+}
+
+func (s *redisStorage) DeleteSetting(name string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.HDel(ctx, redisKeyPrefix+"settings", name).Err()
+}
+
+func (s *redisStorage) RecordOccupancy(snapshots []OccupancySnapshot) error {
+	members := make([]redis.Z, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		members = append(members, redis.Z{Score: float64(snapshot.TakenAt.UnixMilli()), Member: data})
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.ZAdd(ctx, redisKeyPrefix+"occupancy", members...).Err()
+}
+
+func (s *redisStorage) OccupancyHistory(since time.Time) ([]OccupancySnapshot, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	min := strconv.FormatInt(since.UnixMilli(), 10)
+	values, err := s.client.ZRangeByScore(ctx, redisKeyPrefix+"occupancy", &redis.ZRangeBy{Min: min, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	history := make([]OccupancySnapshot, 0, len(values))
+	for _, value := range values {
+		var snapshot OccupancySnapshot
+		if err = json.Unmarshal([]byte(value), &snapshot); err != nil {
+			return nil, err
+		}
+		history = append(history, snapshot)
+	}
+	return history, nil
+}
+
+func (s *redisStorage) DeleteOccupancy(before time.Time) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.ZRemRangeByScore(ctx, redisKeyPrefix+"occupancy", "-inf", "("+strconv.FormatInt(before.UnixMilli(), 10)).Err()
+}
+
+func (s *redisStorage) AddCounters(deltas map[string]int64) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for name, delta := range deltas {
+			pipe.HIncrBy(ctx, redisKeyPrefix+"counters", name, delta)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStorage) Counters() (map[string]int64, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	values, err := s.client.HGetAll(ctx, redisKeyPrefix+"counters").Result()
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]int64, len(values))
+	for name, value := range values {
+		if counters[name], err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return counters, nil
+}
+
+func (s *redisStorage) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// sqlDialect captures the differences between the supported SQL databases.
+type sqlDialect struct {
+	driver string
+	// serialKey is the column definition of an auto-incrementing primary key.
+	serialKey string
+	// numbered placeholders ($1, $2, ...) instead of question marks.
+	numbered bool
+}
+
+var (
+	sqliteDialect   = sqlDialect{driver: "sqlite", serialKey: "INTEGER PRIMARY KEY AUTOINCREMENT"}
+	postgresDialect = sqlDialect{driver: "pgx", serialKey: "BIGSERIAL PRIMARY KEY", numbered: true}
+)
+
+// rebind rewrites the question mark placeholders of query for the dialect.
+func (d sqlDialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// sqlStorage stores everything in SQLite or Postgres.
+// Several bot instances may share one Postgres database.
+type sqlStorage struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func openSQLStorage(dialect sqlDialect, dsn string) (*sqlStorage, error) {
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == sqliteDialect {
+		// SQLite only supports a single writer.
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &sqlStorage{db: db, dialect: dialect}
+	if err = s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqlStorage) migrate() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS moves (
+			id ` + s.dialect.serialKey + `,
+			uid TEXT NOT NULL,
+			nickname TEXT NOT NULL,
+			from_channel INTEGER NOT NULL,
+			to_channel INTEGER NOT NULL,
+			idle_time_ms BIGINT NOT NULL,
+			moved_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS moves_uid_moved_at ON moves (uid, moved_at)`,
+		s.addColumn("moves", "max_idle_time_ms", "BIGINT NOT NULL DEFAULT 0"),
+		s.addColumn("moves", "reason", "TEXT NOT NULL DEFAULT ''"),
+		`CREATE TABLE IF NOT EXISTS overrides (
+			uid TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS home_channels (
+			uid TEXT PRIMARY KEY,
+			channel_id INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS settings (
+			name TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS cooldowns (
+			kind TEXT NOT NULL,
+			uid TEXT NOT NULL,
+			ends_at BIGINT NOT NULL,
+			PRIMARY KEY (kind, uid)
+		)`,
+		`CREATE TABLE IF NOT EXISTS occupancy (
+			server_id INTEGER NOT NULL,
+			channel TEXT NOT NULL,
+			afk BOOLEAN NOT NULL,
+			clients INTEGER NOT NULL,
+			taken_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS occupancy_taken_at ON occupancy (taken_at)`,
+		`CREATE TABLE IF NOT EXISTS counters (
+			name TEXT PRIMARY KEY,
+			value BIGINT NOT NULL
+		)`,
+	}
+	for _, statement := range statements {
+		if statement == "" {
+			continue
+		}
+		if _, err := s.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn returns the statement adding a column to a table created by an older version,
+// or an empty string if the table already has it. SQLite has no ADD COLUMN IF NOT EXISTS.
+func (s *sqlStorage) addColumn(table string, column string, definition string) string {
+	rows, err := s.db.Query(`SELECT ` + column + ` FROM ` + table + ` LIMIT 0`)
+	if err == nil {
+		rows.Close()
+		return ""
+	}
+	return `ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition
+}
+
+func (s *sqlStorage) exec(query string, args ...interface{}) error {
+	_, err := s.db.Exec(s.dialect.rebind(query), args...)
+	return err
+}
+
+func (s *sqlStorage) RecordMove(record MoveRecord) error {
+	return s.exec(`INSERT INTO moves (uid, nickname, from_channel, to_channel, idle_time_ms, max_idle_time_ms, reason, moved_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.UID, record.Nickname, record.FromChannel, record.ToChannel, record.IdleTimeMs, record.MaxIdleTimeMs, record.Reason, record.MovedAt.UnixMilli())
+}
+
+func (s *sqlStorage) MoveHistory(uid string, limit int) ([]MoveRecord, error) {
+	return s.queryMoves(`SELECT uid, nickname, from_channel, to_channel, idle_time_ms, max_idle_time_ms, reason, moved_at FROM moves WHERE uid = ? ORDER BY moved_at DESC, id DESC LIMIT ?`, uid, limit)
+}
+
+func (s *sqlStorage) Moves() ([]MoveRecord, error) {
+	return s.queryMoves(`SELECT uid, nickname, from_channel, to_channel, idle_time_ms, max_idle_time_ms, reason, moved_at FROM moves ORDER BY moved_at, id`)
+}
+
+func (s *sqlStorage) queryMoves(query string, args ...interface{}) ([]MoveRecord, error) {
+	rows, err := s.db.Query(s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []MoveRecord
+	for rows.Next() {
+		var record MoveRecord
+		var movedAt int64
+		if err = rows.Scan(&record.UID, &record.Nickname, &record.FromChannel, &record.ToChannel, &record.IdleTimeMs, &record.MaxIdleTimeMs, &record.Reason, &movedAt); err != nil {
+			return nil, err
+		}
+		record.MovedAt = time.UnixMilli(movedAt)
+		history = append(history, record)
+	}
+	return history, rows.Err()
+}
+
+func (s *sqlStorage) Overrides() (map[string]ClientOverride, error) {
+	rows, err := s.db.Query(`SELECT uid, data FROM overrides`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]ClientOverride)
+	for rows.Next() {
+		var uid, data string
+		if err = rows.Scan(&uid, &data); err != nil {
+			return nil, err
+		}
+		var override ClientOverride
+		if err = json.Unmarshal([]byte(data), &override); err != nil {
+			return nil, err
+		}
+		overrides[uid] = override
+	}
+	return overrides, rows.Err()
+}
+
+func (s *sqlStorage) SaveOverride(uid string, override ClientOverride) error {
+	data, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	return s.exec(`INSERT INTO overrides (uid, data) VALUES (?, ?) ON CONFLICT (uid) DO UPDATE SET data = excluded.data`, uid, string(data))
+}
+
+func (s *sqlStorage) DeleteOverride(uid string) error {
+	return s.exec(`DELETE FROM overrides WHERE uid = ?`, uid)
+}
+
+func (s *sqlStorage) SetHomeChannel(uid string, channelId int) error {
+	return s.exec(`INSERT INTO home_channels (uid, channel_id) VALUES (?, ?) ON CONFLICT (uid) DO UPDATE SET channel_id = excluded.channel_id`, uid, channelId)
+}
+
+func (s *sqlStorage) HomeChannel(uid string) (int, error) {
+	var channelId int
+	err := s.db.QueryRow(s.dialect.rebind(`SELECT channel_id FROM home_channels WHERE uid = ?`), uid).Scan(&channelId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return channelId, err
+}
+
+func (s *sqlStorage) HomeChannels() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT uid, channel_id FROM home_channels`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	homeChannels := make(map[string]int)
+	for rows.Next() {
+		var uid string
+		var channelId int
+		if err = rows.Scan(&uid, &channelId); err != nil {
+			return nil, err
+		}
+		homeChannels[uid] = channelId
+	}
+	return homeChannels, rows.Err()
+}
+
+func (s *sqlStorage) DeleteHomeChannel(uid string) error {
+	return s.exec(`DELETE FROM home_channels WHERE uid = ?`, uid)
+}
+
+func (s *sqlStorage) SetCooldown(kind string, uid string, until time.Time) error {
+	return s.exec(`INSERT INTO cooldowns (kind, uid, ends_at) VALUES (?, ?, ?) ON CONFLICT (kind, uid) DO UPDATE SET ends_at = excluded.ends_at`, kind, uid, until.UnixMilli())
+}
+
+func (s *sqlStorage) Cooldowns(kind string) (map[string]time.Time, error) {
+	now := clock.Now().UnixMilli()
+	if err := s.exec(`DELETE FROM cooldowns WHERE ends_at <= ?`, now); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(s.dialect.rebind(`SELECT uid, ends_at FROM cooldowns WHERE kind = ? AND ends_at > ?`), kind, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cooldowns := make(map[string]time.Time)
+	for rows.Next() {
+		var uid string
+		var until int64
+		if err = rows.Scan(&uid, &until); err != nil {
+			return nil, err
+		}
+		cooldowns[uid] = time.UnixMilli(until)
+	}
+	return cooldowns, rows.Err()
+}
+
+func (s *sqlStorage) Settings() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT name, value FROM settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err = rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		settings[name] = value
+	}
+	return settings, rows.Err()
+}
+
+func (s *sqlStorage) SaveSetting(name string, value string) error {
+	return s.exec(`INSERT INTO settings (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = excluded.value`, name, value)
+}
+
+func (s *sqlStorage) DeleteSetting(name string) error {
+	return s.exec(`DELETE FROM settings WHERE name = ?`, name)
+}
+
+func (s *sqlStorage) RecordOccupancy(snapshots []OccupancySnapshot) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	insert := s.dialect.rebind(`INSERT INTO occupancy (server_id, channel, afk, clients, taken_at) VALUES (?, ?, ?, ?, ?)`)
+	for _, snapshot := range snapshots {
+		if _, err = tx.Exec(insert, snapshot.ServerID, snapshot.Channel, snapshot.AFK, snapshot.Clients, snapshot.TakenAt.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStorage) OccupancyHistory(since time.Time) ([]OccupancySnapshot, error) {
+	rows, err := s.db.Query(s.dialect.rebind(`SELECT server_id, channel, afk, clients, taken_at FROM occupancy WHERE taken_at >= ? ORDER BY taken_at`), since.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []OccupancySnapshot
+	for rows.Next() {
+		var snapshot OccupancySnapshot
+		var takenAt int64
+		if err = rows.Scan(&snapshot.ServerID, &snapshot.Channel, &snapshot.AFK, &snapshot.Clients, &takenAt); err != nil {
+			return nil, err
+		}
+		snapshot.TakenAt = time.UnixMilli(takenAt)
+		history = append(history, snapshot)
+	}
+	return history, rows.Err()
+}
+
+func (s *sqlStorage) DeleteOccupancy(before time.Time) error {
+	return s.exec(`DELETE FROM occupancy WHERE taken_at < ?`, before.UnixMilli())
+}
+
+func (s *sqlStorage) AddCounters(deltas map[string]int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	upsert := s.dialect.rebind(`INSERT INTO counters (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = counters.value + excluded.value`)
+	for name, delta := range deltas {
+		if _, err = tx.Exec(upsert, name, delta); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStorage) Counters() (map[string]int64, error) {
+	rows, err := s.db.Query(`SELECT name, value FROM counters`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counters := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var value int64
+		if err = rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		counters[name] = value
+	}
+	return counters, rows.Err()
+}
+
+func (s *sqlStorage) Close() error {
+	return s.db.Close()
+}
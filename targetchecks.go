@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"github.com/multiplay/go-ts3"
+	"time"
+)
+
+// Error codes of a clientmove the server rejected because of the target channel.
+const (
+	queryErrChannelMaxClients = 777
+	queryErrChannelMaxFamily  = 778
+)
+
+// rejectedTargetTTL is how long a channel the server refused to move a client into is not tried again.
+// Channel permissions and limits rarely change, and a failed move every sweep only spams the log.
+const rejectedTargetTTL = time.Hour
+
+// rejectedTargets holds, per client unique identifier, the channels moves into were rejected and until when.
+var rejectedTargets = make(map[string]map[int]time.Time)
+
+// rejectsTarget reports whether err means the client cannot be moved into the target channel,
+// e.g. because it is full or the client lacks the join power.
+func rejectsTarget(err error) bool {
+	var tsErr *ts3.Error
+	if !errors.As(err, &tsErr) {
+		return false
+	}
+	switch tsErr.ID {
+	case queryErrChannelMaxClients, queryErrChannelMaxFamily, queryErrInsufficientPermission:
+		return true
+	}
+	return false
+}
+
+// rejectTarget remembers that moving the client into channel id failed.
+func rejectTarget(uid string, id int, now time.Time) {
+	if rejectedTargets[uid] == nil {
+		rejectedTargets[uid] = make(map[int]time.Time)
+	}
+	rejectedTargets[uid][id] = now.Add(rejectedTargetTTL)
+}
+
+// targetRejected reports whether a move of the client into channel id failed recently.
+func targetRejected(uid string, id int, now time.Time) bool {
+	until, ok := rejectedTargets[uid][id]
+	return ok && now.Before(until)
+}
+
+// pruneRejectedTargets forgets rejected moves that may be tried again.
+func pruneRejectedTargets(now time.Time) {
+	for uid, targets := range rejectedTargets {
+		for id, until := range targets {
+			if !now.Before(until) {
+				delete(targets, id)
+			}
+		}
+		if len(targets) == 0 {
+			delete(rejectedTargets, uid)
+		}
+	}
+}
+
+// canEnter reports whether c can be moved into the channel and talk there. Channels the server
+// recently refused to move c into, and channels that need more talk power than c has, are skipped.
+func (s *sweep) canEnter(c *clientInfo, details *clientDetails, id int) bool {
+	if targetRejected(c.UniqueIdentifier, id, s.now) {
+		return false
+	}
+	if channel, ok := s.tree.byID[id]; ok && channel.NeededTalkPower > details.TalkPower {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"sync/atomic"
+	"time"
+)
+
+// queryTrace enables logging of every raw ServerQuery command and response line.
+// It is toggled from the API and chat commands, so it is atomic.
+var queryTrace atomic.Bool
+
+// traceSeq numbers traced commands so that the lines of one exchange can be correlated.
+var traceSeq atomic.Uint64
+
+// setQueryTrace switches the query trace on or off.
+func setQueryTrace(enabled bool, source string) {
+	queryTrace.Store(enabled)
+	if enabled {
+		zap.S().Infof("Query trace enabled by %s", source)
+	} else {
+		zap.S().Infof("Query trace disabled by %s", source)
+	}
+}
+
+// traceCommand logs cmd before it is sent and returns the correlation ID of the exchange, or 0 if tracing is off.
+func traceCommand(cmd string) uint64 {
+	if !queryTrace.Load() {
+		return 0
+	}
+	id := traceSeq.Add(1)
+	zap.S().Infof("[q%d] > %s", id, credentialRegex.ReplaceAllString(cmd, "${1}<redacted>"))
+	return id
+}
+
+// traceResponse logs the response lines of the exchange id.
+func traceResponse(id uint64, lines []string, err error, took time.Duration) {
+	if id == 0 {
+		return
+	}
+	for _, line := range lines {
+		zap.S().Infof("[q%d] < %s", id, line)
+	}
+	if err != nil {
+		zap.S().Infof("[q%d] < error after %v: %v", id, took, err)
+	} else {
+		zap.S().Infof("[q%d] < ok after %v", id, took)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	tuiRefreshInterval = time.Second
+	tuiRecentActions   = 10
+	tuiNameWidth       = 32
+
+	ansiEnterAltScreen = "\x1b[?1049h\x1b[?25l"
+	ansiLeaveAltScreen = "\x1b[?25h\x1b[?1049l"
+	ansiClearScreen    = "\x1b[H\x1b[2J"
+	ansiBold           = "\x1b[1m"
+	ansiDim            = "\x1b[2m"
+	ansiRed            = "\x1b[31m"
+	ansiYellow         = "\x1b[33m"
+	ansiReset          = "\x1b[0m"
+)
+
+// runTUI renders a live view of the last sweep and recent actions to the terminal until the process exits.
+func runTUI() {
+	os.Stdout.WriteString(ansiEnterAltScreen)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		os.Stdout.WriteString(ansiLeaveAltScreen)
+		os.Exit(0)
+	}()
+
+	sub := events.subscribe("tui")
+	var recent []botEvent
+
+	ticker := time.NewTicker(tuiRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-sub.ch:
+			if event.Type == "decision" {
+				continue
+			}
+			recent = append(recent, event)
+			if len(recent) > tuiRecentActions {
+				recent = recent[len(recent)-tuiRecentActions:]
+			}
+		case <-ticker.C:
+			os.Stdout.WriteString(renderTUI(latestSweep(), recent, time.Now()))
+		}
+	}
+}
+
+func renderTUI(snapshot sweepSnapshot, recent []botEvent, now time.Time) string {
+	var b strings.Builder
+	b.WriteString(ansiClearScreen)
+
+	if snapshot.Time.IsZero() {
+		b.WriteString("Waiting for the first sweep...\n")
+		return b.String()
+	}
+
+	elapsed := now.Sub(snapshot.Time)
+	tree := newChannelTree(snapshot.Channels)
+	fmt.Fprintf(&b, "%sts3-afk-mover%s  last sweep %s ago, %d clients online  (Ctrl+C to quit)\n\n",
+		ansiBold, ansiReset, elapsed.Truncate(time.Second), len(snapshot.Clients))
+
+	fmt.Fprintf(&b, "%s%-*s %9s %9s %9s  %s%s\n", ansiBold, tuiNameWidth, "Channel / Client", "Idle", "Limit", "Move in", "Status", ansiReset)
+
+	byChannel := make(map[int][]clientStatus)
+	for _, c := range snapshot.Clients {
+		byChannel[c.ChannelID] = append(byChannel[c.ChannelID], c)
+	}
+
+	for _, channel := range snapshot.Channels {
+		clients := byChannel[channel.ID]
+		if len(clients) == 0 {
+			continue
+		}
+		depth := len(tree.path(channel.ID)) - 1
+		name := strings.Repeat("  ", depth) + channel.ChannelName
+		if channel.ID == snapshot.AfkChannelID {
+			name += " (AFK)"
+		}
+		fmt.Fprintf(&b, "%s%s%s\n", ansiBold, truncate(name, tuiNameWidth), ansiReset)
+
+		for _, c := range clients {
+			name := strings.Repeat("  ", depth+1) + c.Nickname
+			idle, limit, countdown := "?", formatDuration(time.Duration(c.MaxIdleTimeMs)*time.Millisecond), "-"
+			color := ""
+			if c.IdleTimeMs >= 0 {
+				idleTime := time.Duration(c.IdleTimeMs)*time.Millisecond + elapsed
+				idle = formatDuration(idleTime)
+				if c.Status == statusActive || c.Status == statusScheduled || c.Status == statusConfirming {
+					remaining := time.Duration(c.MaxIdleTimeMs)*time.Millisecond - idleTime
+					if remaining <= 0 {
+						countdown, color = "due", ansiRed
+					} else {
+						countdown = formatDuration(remaining)
+						if remaining < time.Minute {
+							color = ansiYellow
+						}
+					}
+				}
+			}
+			if c.Status == statusError {
+				color = ansiRed
+			}
+			fmt.Fprintf(&b, "%s%-*s %9s %9s %9s  %s%s\n", color, tuiNameWidth, truncate(name, tuiNameWidth), idle, limit, countdown, c.Status, ansiReset)
+		}
+	}
+
+	fmt.Fprintf(&b, "\n%sRecent actions%s\n", ansiBold, ansiReset)
+	if len(recent) == 0 {
+		fmt.Fprintf(&b, "%snone yet%s\n", ansiDim, ansiReset)
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		event := recent[i]
+		color := ""
+		if event.Type == "error" {
+			color = ansiRed
+		}
+		fmt.Fprintf(&b, "%s%s %-5s %-20s %s%s\n", color, event.Time.Format("15:04:05"), event.Type, truncate(event.Nickname, 20), event.Message, ansiReset)
+	}
+
+	return b.String()
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Truncate(time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// tuiFatalHook restores the terminal before a fatal log entry terminates the process,
+// otherwise the shell would be left on the alternate screen without a cursor.
+type tuiFatalHook struct{}
+
+func (tuiFatalHook) OnWrite(entry *zapcore.CheckedEntry, _ []zapcore.Field) {
+	os.Stdout.WriteString(ansiLeaveAltScreen)
+	fmt.Fprintln(os.Stderr, entry.Message)
+	os.Exit(1)
+}
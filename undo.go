@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"time"
+)
+
+// undoBufferSize bounds the number of moves !undo remembers, however many a sweep makes.
+const undoBufferSize = 500
+
+// undoableMove is a move the bot made that !undo can take back.
+type undoableMove struct {
+	uid      string
+	nickname string
+	from     int
+	to       int
+	movedAt  time.Time
+}
+
+// undoBuffer holds the moves of the last TS3_UNDO_WINDOW_SEC, oldest first.
+var undoBuffer []undoableMove
+
+// rememberUndo adds the move of c from the channel it is in to target to the undo buffer.
+func (s *sweep) rememberUndo(c *clientInfo, target int) {
+	if s.config.UndoWindow == 0 {
+		return
+	}
+	pruneUndoBuffer(s.now, s.config.UndoWindow)
+	if len(undoBuffer) == undoBufferSize {
+		undoBuffer = undoBuffer[1:]
+	}
+	undoBuffer = append(undoBuffer, undoableMove{uid: c.UniqueIdentifier, nickname: c.logName(), from: c.ChannelID, to: target, movedAt: s.now})
+}
+
+// pruneUndoBuffer forgets the moves older than window.
+func pruneUndoBuffer(now time.Time, window time.Duration) {
+	for len(undoBuffer) > 0 && now.Sub(undoBuffer[0].movedAt) > window {
+		undoBuffer = undoBuffer[1:]
+	}
+}
+
+// undoMoves moves clients back to the channels the bot took them from: those of the latest sweep that
+// moved anybody, or with since set, all moved after it. Clients that left or are no longer in the channel
+// the bot put them in are skipped. Clients moved back are held like clients moved by a moderator, so the
+// next sweep does not move them again right away. It returns the number of clients moved back and skipped.
+func undoMoves(client *ts3.Client, config Config, since time.Time, admin string) (int, int, error) {
+	now := clock.Now()
+	pruneUndoBuffer(now, config.UndoWindow)
+	if len(undoBuffer) == 0 {
+		return 0, 0, nil
+	}
+	if since.IsZero() {
+		since = undoBuffer[len(undoBuffer)-1].movedAt
+	}
+	clients, err := listClients(client)
+	if err != nil {
+		return 0, 0, err
+	}
+	online := make(map[string]*clientInfo, len(clients))
+	for _, c := range clients {
+		online[c.UniqueIdentifier] = c
+	}
+
+	undone, skipped := 0, 0
+	kept := undoBuffer[:0]
+	for _, move := range undoBuffer {
+		if move.movedAt.Before(since) {
+			kept = append(kept, move)
+			continue
+		}
+		c, ok := online[move.uid]
+		if !ok || c.ChannelID != move.to {
+			skipped++
+			continue
+		}
+		if err = moveClient(client, c.ID, move.from); err != nil {
+			zap.S().Errorf("Failed to move %s back to channel [%d]: %v", move.nickname, move.from, err)
+			skipped++
+			continue
+		}
+		undone++
+		zap.S().Infof("Moved %s back to channel [%d], undoing the move by %s", move.nickname, move.from, admin)
+		publishClientAction("move", c, reasonUndo, fmt.Sprintf("Moved back to channel %d by !undo of %s", move.from, admin))
+		metrics.count("returns", 1, "reason:"+reasonUndo)
+		manualMoves[c.ID] = now
+		delete(pendingReturns, move.uid)
+		if err = storage.DeleteHomeChannel(privacy.uid(move.uid)); err != nil {
+			zap.S().Errorf("Failed to delete home channel of %s: %v", move.nickname, err)
+		}
+	}
+	undoBuffer = kept
+	return undone, skipped, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/multiplay/go-ts3"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	releaseURL          = "https://api.github.com/repos/Scarjit/ts3automovebot/releases/latest"
+	updateCheckInterval = 24 * time.Hour
+)
+
+// updateNotices carries newer release tags to the main loop, which tells the admins on the server.
+var updateNotices = make(chan string, 1)
+
+type release struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+}
+
+// runUpdateCheck looks for a newer release once a day until the process exits.
+func runUpdateCheck(webhook string) {
+	current := currentBuild().Version
+	if _, ok := parseVersion(current); !ok {
+		zap.S().Infof("Not checking for updates, version %q is not a release", current)
+		return
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	notified := ""
+	for {
+		latest, err := fetchLatestRelease(client)
+		if err != nil {
+			zap.S().Warnf("Failed to check for updates: %v", err)
+		} else if latest.TagName != notified && newerVersion(latest.TagName, current) {
+			notified = latest.TagName
+			zap.S().Warnf("A newer version %s is available (running %s): %s", latest.TagName, current, latest.HTMLURL)
+			select {
+			case updateNotices <- latest.TagName:
+			default:
+			}
+			if webhook != "" {
+				if err = postUpdateWebhook(client, webhook, current, latest); err != nil {
+					zap.S().Errorf("Failed to send update webhook: %v", redactURLError(err))
+				}
+			}
+		}
+		time.Sleep(updateCheckInterval)
+	}
+}
+
+func fetchLatestRelease(client *http.Client) (release, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, releaseURL, nil)
+	if err != nil {
+		return release{}, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	res, err := client.Do(req)
+	if err != nil {
+		return release{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return release{}, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	var latest release
+	if err = json.NewDecoder(res.Body).Decode(&latest); err != nil {
+		return release{}, err
+	}
+	return latest, nil
+}
+
+func postUpdateWebhook(client *http.Client, webhook string, current string, latest release) error {
+	body, err := json.Marshal(map[string]string{
+		"text":            fmt.Sprintf("ts3-afk-mover %s is available (running %s): %s", latest.TagName, current, latest.HTMLURL),
+		"current_version": current,
+		"latest_version":  latest.TagName,
+		"url":             latest.HTMLURL,
+	})
+	if err != nil {
+		return err
+	}
+	res, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// notifyAdminsOfUpdate sends a private message about a newer release to all admins that are online.
+func notifyAdminsOfUpdate(client *ts3.Client, config Config, tag string) {
+	notifyAdmins(client, config, fmt.Sprintf("ts3-afk-mover %s is available, this server runs %s.", tag, currentBuild().Version))
+}
+
+// parseVersion parses tags like v1.2.3 into their numeric parts.
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	// Ignore pre-release and build suffixes.
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		numbers[i] = n
+	}
+	return numbers, true
+}
+
+// newerVersion reports whether tag is a later release than current.
+func newerVersion(tag string, current string) bool {
+	a, okA := parseVersion(tag)
+	b, okB := parseVersion(current)
+	if !okA || !okB {
+		return false
+	}
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// minPollInterval is the shortest time between sweeps that does not flood the server.
+const minPollInterval = 2 * time.Second
+
+// eventTypes are the types of events the bot publishes.
+var eventTypes = []string{"idle", "decision", "warning", "move", "sweep", "pause", "resume", "profile", "musicbot", "anomaly", "error"}
+
+// configProblem is a configuration value that is out of range or does not fit together with another one.
+type configProblem struct {
+	key     string
+	problem string
+	// fix suggests how to resolve the problem.
+	fix string
+}
+
+func (p configProblem) Error() string {
+	if p.fix == "" {
+		return p.key + ": " + p.problem
+	}
+	return fmt.Sprintf("%s: %s; %s", p.key, p.problem, p.fix)
+}
+
+// validationResult is the output of the validate command; --output=json keeps these field names stable.
+type validationResult struct {
+	Valid    bool                `json:"valid"`
+	Problems []validationProblem `json:"problems"`
+}
+
+type validationProblem struct {
+	// Key is the setting the problem is about, empty if it is not about a single one.
+	Key     string `json:"key,omitempty"`
+	Problem string `json:"problem"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+// validateCommand checks the configuration in the environment and the -config file without connecting
+// and prints every problem. It returns exitConfig if there are any.
+func validateCommand() int {
+	result := validationResult{Valid: true, Problems: []validationProblem{}}
+	if _, err := loadConfigFromEnv(); err != nil {
+		result.Valid = false
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, err := range errs {
+			var problem configProblem
+			if errors.As(err, &problem) {
+				result.Problems = append(result.Problems, validationProblem{Key: problem.key, Problem: problem.problem, Fix: problem.fix})
+			} else {
+				result.Problems = append(result.Problems, validationProblem{Problem: err.Error()})
+			}
+		}
+	}
+
+	if *outputFlag == outputJSON {
+		printJSON(result)
+	} else if result.Valid {
+		fmt.Println("The configuration is valid")
+	} else {
+		for _, problem := range result.Problems {
+			if problem.Key == "" {
+				fmt.Println(problem.Problem)
+			} else {
+				fmt.Println(configProblem{key: problem.Key, problem: problem.Problem, fix: problem.Fix}.Error())
+			}
+		}
+	}
+	if !result.Valid {
+		return exitConfig
+	}
+	return 0
+}
+
+// validateConfig checks the ranges of and the combinations between configuration values
+// and returns every problem found, not just the first one.
+func validateConfig(config Config) []error {
+	var problems []error
+	fail := func(key string, problem string, fix string) {
+		problems = append(problems, configProblem{key: key, problem: problem, fix: fix})
+	}
+
+	if _, err := parseAccounts(config.FallbackAccounts.value()); err != nil {
+		fail("TS3_FALLBACK_ACCOUNTS", err.Error(), "list user:password entries separated by commas")
+	}
+
+	switch config.Storage {
+	case "memory":
+	case "bbolt", "sqlite", "postgres", "redis":
+		if config.StorageDSN == "" {
+			fail("TS3_STORAGE_DSN", "must be set for storage backend "+config.Storage, "set it to a file path, or a connection string for postgres and redis")
+		}
+	default:
+		fail("TS3_STORAGE", fmt.Sprintf("unknown backend %q", config.Storage), "use memory, bbolt, sqlite, postgres or redis")
+	}
+	if config.StorageBuffer == 0 {
+		fail("TS3_STORAGE_BUFFER", "must be at least 1", "writes are always made in the background so a hanging backend cannot hold up sweeps; use 1 to hold back as few as possible")
+	}
+	if config.LogProfile != "development" && config.LogProfile != "production" {
+		fail("TS3_LOG_PROFILE", fmt.Sprintf("unknown profile %q", config.LogProfile), "use production or development")
+	}
+	if config.MutedAfkMode != "input" && config.MutedAfkMode != "both" {
+		fail("TS3_MUTED_AFK_MODE", fmt.Sprintf("unknown mode %q", config.MutedAfkMode), "use input or both")
+	}
+	switch config.PrivacyMode {
+	case privacyOff, privacyTruncate:
+	case privacyHash:
+		if config.PrivacySalt == "" {
+			fail("TS3_PRIVACY_SALT", "must be set with TS3_PRIVACY_MODE=hash", "set it to a long random string, or use TS3_PRIVACY_MODE=truncate")
+		}
+	default:
+		fail("TS3_PRIVACY_MODE", fmt.Sprintf("unknown mode %q", config.PrivacyMode), "use off, hash or truncate")
+	}
+	if config.AnomalyChannelDrop > 100 {
+		fail("TS3_ANOMALY_CHANNEL_DROP_PERCENT", fmt.Sprintf("%d%% can never happen, so a shrinking channel list is never noticed", config.AnomalyChannelDrop), "use a percentage up to 100")
+	}
+	if config.EventOverflow != overflowDropNewest && config.EventOverflow != overflowDropOldest {
+		fail("TS3_EVENT_OVERFLOW", fmt.Sprintf("unknown policy %q", config.EventOverflow), "use drop-newest or drop-oldest")
+	}
+	if config.LargeSweepAction != "confirm" && config.LargeSweepAction != "warn" {
+		fail("TS3_LARGE_SWEEP_ACTION", fmt.Sprintf("unknown action %q", config.LargeSweepAction), "use confirm or warn")
+	}
+	if config.Announce != announceOff && config.Announce != announceServer && config.Announce != announceChannel {
+		fail("TS3_ANNOUNCE", fmt.Sprintf("unknown target %q", config.Announce), "use off, server or channel")
+	}
+	if !isNotifyMethod(config.WarnMethod) {
+		fail("TS3_WARN_METHOD", fmt.Sprintf("unknown method %q", config.WarnMethod), "use poke, msg or none")
+	}
+	checkEventTypes := func(key string, types []string) {
+		for _, eventType := range types {
+			if !containsString(eventTypes, eventType) {
+				fail(key, fmt.Sprintf("unknown event type %q", eventType), "use any of "+strings.Join(eventTypes, ", "))
+			}
+		}
+	}
+	checkEventTypes("TS3_EVENT_WEBHOOK_TYPES", config.EventWebhookTypes)
+	checkEventTypes("TS3_DISCORD_EVENT_TYPES", config.DiscordEventTypes)
+	checkEventTypes("TS3_MATRIX_EVENT_TYPES", config.MatrixEventTypes)
+	checkEventTypes("TS3_SLACK_EVENT_TYPES", config.SlackEventTypes)
+	if config.MatrixHomeserver != "" {
+		if config.MatrixToken == "" {
+			fail("TS3_MATRIX_TOKEN", "must be set when TS3_MATRIX_HOMESERVER is set", "set it to the access token of a user in the room")
+		}
+		if !strings.HasPrefix(config.MatrixRoom, "!") {
+			fail("TS3_MATRIX_ROOM", fmt.Sprintf("%q is not a room ID", config.MatrixRoom), "use the internal room ID like !abcdef:example.org from the room's advanced settings")
+		}
+	}
+	if config.SlackWebhook != "" && config.SlackToken != "" {
+		fail("TS3_SLACK_TOKEN", "cannot be combined with TS3_SLACK_WEBHOOK", "use either an incoming webhook or a Slack app")
+	}
+	if config.SlackToken != "" && config.SlackChannel == "" {
+		fail("TS3_SLACK_CHANNEL", "must be set when TS3_SLACK_TOKEN is set", "set it to the ID or name of the channel the app posts to, e.g. #ops")
+	}
+	if config.SlackDigestAt >= 0 && config.SlackWebhook == "" && config.SlackToken == "" {
+		fail("TS3_SLACK_DIGEST_TIME", "has no effect without Slack", "set TS3_SLACK_WEBHOOK or TS3_SLACK_TOKEN, or unset it")
+	}
+
+	if !config.AllServers && config.ServerId == 0 && config.ServerPort == 0 {
+		fail("TS3_SERVER_ID", "neither TS3_SERVER_ID nor TS3_SERVER_PORT is set", "set one of them, or TS3_ALL_SERVERS=true")
+	}
+	if config.AllServers && (config.ServerId != 0 || config.ServerPort != 0) {
+		fail("TS3_ALL_SERVERS", "cannot be combined with TS3_SERVER_ID or TS3_SERVER_PORT", "unset TS3_SERVER_ID and TS3_SERVER_PORT")
+	}
+	if config.ServerId != 0 && config.ServerPort != 0 {
+		fail("TS3_SERVER_PORT", "only one of TS3_SERVER_ID and TS3_SERVER_PORT may be set", "unset TS3_SERVER_ID, the port survives snapshot restores")
+	}
+	if config.ServerPort > 65535 {
+		fail("TS3_SERVER_PORT", fmt.Sprintf("%d is not a port number", config.ServerPort), "use the voice port of the virtual server, e.g. 9987")
+	}
+	if config.ChaosPercent > 100 {
+		fail("TS3_CHAOS_PERCENT", fmt.Sprintf("%d is more than 100", config.ChaosPercent), "use a percentage between 0 and 100")
+	}
+	if config.MinIdleRatio >= 100 {
+		fail("TS3_MIN_IDLE_RATIO_PERCENT", fmt.Sprintf("%d%% can never be exceeded, so nobody would be moved", config.MinIdleRatio), "use a percentage below 100, or 0 to disable it")
+	}
+	if !isLanguage(config.Language) {
+		fail("TS3_LANGUAGE", fmt.Sprintf("%q is not a bundled language", config.Language), "use one of "+strings.Join(languages(), ", "))
+	}
+	var unreachable []string
+	for channel, percent := range config.ChannelMinIdleRatio {
+		if percent >= 100 {
+			unreachable = append(unreachable, channel)
+		}
+	}
+	sort.Strings(unreachable)
+	for _, channel := range unreachable {
+		fail("TS3_CHANNEL_MIN_IDLE_RATIO_PERCENT", fmt.Sprintf("%d%% for %s can never be exceeded, so nobody would be moved", config.ChannelMinIdleRatio[channel], channel),
+			"use a percentage below 100, or list the channel in TS3_IGNORED_CHANNELS")
+	}
+	for _, name := range unknownExemptionSets(config, config.ExemptSets) {
+		fail("TS3_EXEMPT_SETS", fmt.Sprintf("unknown set %q", name), "define it in TS3_EXEMPTION_SETS or use one of "+strings.Join(exemptionSetNames(config), ", "))
+	}
+	channels := make([]string, 0, len(config.ChannelExemptSets))
+	for channel := range config.ChannelExemptSets {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		for _, name := range unknownExemptionSets(config, config.ChannelExemptSets[channel]) {
+			fail("TS3_CHANNEL_EXEMPT_SETS", fmt.Sprintf("unknown set %q for %s", name, channel), "define it in TS3_EXEMPTION_SETS or use one of "+strings.Join(exemptionSetNames(config), ", "))
+		}
+	}
+
+	if config.AdaptivePolling && config.PollMin < minPollInterval {
+		fail("TS3_POLL_MIN_SEC", fmt.Sprintf("%v is too short, sweeps would flood the server", config.PollMin), fmt.Sprintf("use at least %d", int(minPollInterval.Seconds())))
+	}
+	if config.PollMin > config.PollMax {
+		fail("TS3_POLL_MIN_SEC", "must not be greater than TS3_POLL_MAX_SEC", fmt.Sprintf("lower it to at most %d or raise TS3_POLL_MAX_SEC", int(config.PollMax.Seconds())))
+	}
+	maxIdleTime := time.Duration(config.MaxIdleTimeMs) * time.Millisecond
+	if config.WarnBefore > 0 && config.WarnBefore >= maxIdleTime {
+		fail("TS3_WARN_BEFORE_SEC", fmt.Sprintf("must be less than the idle limit of %d seconds, or users are warned right away", int(maxIdleTime.Seconds())),
+			fmt.Sprintf("lower it to at most %d or raise TS3_MAX_IDLE_TIME_SEC", int(maxIdleTime.Seconds())-1))
+	}
+	if weekendIdleTime := time.Duration(config.WeekendMaxIdleTimeMs) * time.Millisecond; config.WarnBefore > 0 && weekendIdleTime > 0 && config.WarnBefore >= weekendIdleTime {
+		fail("TS3_WARN_BEFORE_SEC", fmt.Sprintf("must be less than the weekend idle limit of %d seconds, or users are warned right away", int(weekendIdleTime.Seconds())),
+			fmt.Sprintf("lower it to at most %d or raise TS3_WEEKEND_MAX_IDLE_TIME_SEC", int(weekendIdleTime.Seconds())-1))
+	}
+	if config.MusicBotDetection && config.MusicBotIdle >= maxIdleTime {
+		fail("TS3_MUSIC_BOT_IDLE_SEC", fmt.Sprintf("must be less than the idle limit of %d seconds, or music bots are moved before they are detected", int(maxIdleTime.Seconds())),
+			fmt.Sprintf("lower it to at most %d or raise TS3_MAX_IDLE_TIME_SEC", int(maxIdleTime.Seconds())-1))
+	}
+	if config.MutedAfkTime > 0 && config.MutedAfkTime >= maxIdleTime {
+		fail("TS3_MUTED_AFK_SEC", fmt.Sprintf("has no effect, it is not shorter than the idle limit of %d seconds", int(maxIdleTime.Seconds())),
+			fmt.Sprintf("lower it below %d or set it to 0", int(maxIdleTime.Seconds())))
+	}
+	if config.DefaultProfile != "" {
+		if _, ok := config.Profiles[config.DefaultProfile]; !ok {
+			fail("TS3_PROFILE", fmt.Sprintf("unknown profile %q", config.DefaultProfile), "define it in TS3_PROFILES or use one of "+strings.Join(profileNames(config), ", "))
+		}
+	}
+	for _, scheduled := range config.ProfileSchedule {
+		if _, ok := config.Profiles[scheduled.profile]; !ok {
+			fail("TS3_PROFILE_SCHEDULE", fmt.Sprintf("unknown profile %q", scheduled.profile), "define it in TS3_PROFILES or use one of "+strings.Join(profileNames(config), ", "))
+		}
+	}
+	for _, name := range profileNames(config) {
+		profile := withProfile(config, name)
+		// The profiles of the profile are not checked again.
+		profile.Profiles, profile.DefaultProfile, profile.ProfileSchedule = nil, "", nil
+		for _, problem := range validateConfig(profile) {
+			problems = append(problems, fmt.Errorf("with TS3_PROFILES profile %s: %w", name, problem))
+		}
+	}
+	if config.HTTPAddr != "" && config.AdminToken == "" {
+		fail("TS3_ADMIN_TOKEN", "must be set when TS3_HTTP_ADDR is set", "set it to a long random string, or unset TS3_HTTP_ADDR")
+	}
+	return problems
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// How a client is warned before it is moved, set globally with TS3_WARN_METHOD and per client with !notify.
+const (
+	notifyPoke = "poke"
+	notifyMsg  = "msg"
+	notifyNone = "none"
+)
+
+// pokeMaxLength is the longest message the server shows in a poke.
+const pokeMaxLength = 100
+
+// warnedClients holds the IDs of the clients warned about an upcoming move that have not been active since.
+var warnedClients = make(map[int]bool)
+
+func isNotifyMethod(method string) bool {
+	return method == notifyPoke || method == notifyMsg || method == notifyNone
+}
+
+// notifyMethod returns how the client with the given override wants to be warned.
+func notifyMethod(config Config, override ClientOverride) string {
+	if override.Notify != "" {
+		return override.Notify
+	}
+	return config.WarnMethod
+}
+
+// warnBeforeMove warns an active client once it is within TS3_WARN_BEFORE_SEC of its idle limit.
+// A client becoming active again is warned again the next time it gets close.
+func (s *sweep) warnBeforeMove(c *clientInfo, status clientStatus, override ClientOverride) {
+	remaining := time.Duration(status.MaxIdleTimeMs-status.IdleTimeMs) * time.Millisecond
+	if remaining > s.config.WarnBefore {
+		delete(warnedClients, c.ID)
+		return
+	}
+	if s.config.WarnBefore == 0 || s.forcedThresholdMs > 0 {
+		return
+	}
+	language := clientLanguage(s.config, override, c.Country)
+	s.warn(c, override, reasonThresholdNear,
+		translate(language, "warn.poke", shortDuration(remaining)),
+		translate(language, "warn.message", shortDuration(time.Duration(status.IdleTimeMs)*time.Millisecond), s.config.AfkChannelName, shortDuration(remaining)))
+}
+
+// warnOnly warns an idle client in a TS3_WARN_ONLY_CHANNELS channel, where it is never moved.
+func (s *sweep) warnOnly(c *clientInfo, status clientStatus, override ClientOverride) {
+	if s.forcedThresholdMs > 0 {
+		return
+	}
+	idle := shortDuration(time.Duration(status.IdleTimeMs) * time.Millisecond)
+	language := clientLanguage(s.config, override, c.Country)
+	s.warn(c, override, status.Reason,
+		translate(language, "warn_only.poke", idle),
+		translate(language, "warn_only.message", idle, s.config.AfkChannelName))
+}
+
+// warn pokes or messages c, depending on its notify method, once until it is active again.
+func (s *sweep) warn(c *clientInfo, override ClientOverride, reason string, poke string, msg string) {
+	if warnedClients[c.ID] || s.config.ObserveOnly {
+		return
+	}
+	warnedClients[c.ID] = true
+
+	var err error
+	switch notifyMethod(s.config, override) {
+	case notifyPoke:
+		if len(poke) > pokeMaxLength {
+			// Translations may cut a character in half.
+			poke = strings.ToValidUTF8(poke[:pokeMaxLength], "")
+		}
+		err = pokeClient(s.client, c.ID, poke)
+	case notifyMsg:
+		err = sendPrivateMessage(s.client, c.ID, msg)
+	default:
+		return
+	}
+	if err != nil {
+		handleQueryError(s.client, s.config, "warn", err)
+		zap.S().Warnf("Failed to warn user %s: %v", c.logName(), err)
+		return
+	}
+	logClientDeduped(c, "Warned user %s, reason %s: %s", c.logName(), reason, poke)
+	metrics.count("warnings", 1, "reason:"+reason)
+	publishClientAction("warning", c, reason, poke)
+}
+
+// setNotifyMethod stores how the client with the given UID wants to be warned.
+func setNotifyMethod(uid string, method string) error {
+	override, _ := clientOverrides.get(uid)
+	override.Notify = method
+	return clientOverrides.set(uid, override)
+}
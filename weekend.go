@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultWeekend are the days TS3_WEEKEND_MAX_IDLE_TIME_SEC applies on unless TS3_WEEKEND_DAYS says otherwise.
+var defaultWeekend = []time.Weekday{time.Saturday, time.Sunday}
+
+// parseWeekday reads a day name such as "sat" or "Saturday".
+func parseWeekday(name string) (time.Weekday, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a day of the week", name)
+}
+
+// isWeekend reports whether now falls on one of the TS3_WEEKEND_DAYS in TS3_TIMEZONE.
+func isWeekend(config Config, now time.Time) bool {
+	today := now.In(config.Location).Weekday()
+	for _, day := range config.WeekendDays {
+		if day == today {
+			return true
+		}
+	}
+	return false
+}
+
+// withWeekend returns config with the weekend idle limit applied if it is set and today is a weekend day.
+// Profiles and settings changed with !set are applied on top, so they take precedence.
+func withWeekend(config Config, now time.Time) Config {
+	if config.WeekendMaxIdleTimeMs > 0 && isWeekend(config, now) {
+		config.MaxIdleTimeMs = config.WeekendMaxIdleTimeMs
+	}
+	return config
+}
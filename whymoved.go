@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// How many moves !whymoved lists by default and at most.
+const (
+	whyMovedDefault = 3
+	whyMovedMax     = 10
+)
+
+// whyMovedText describes the moves in history, newest first, for !whymoved in the given language.
+func whyMovedText(config Config, history []MoveRecord, language string) string {
+	if len(history) == 0 {
+		return translate(language, "whymoved.none")
+	}
+	lines := []string{translate(language, "whymoved.last")}
+	if len(history) > 1 {
+		lines[0] = translate(language, "whymoved.last_n", len(history))
+	}
+	for _, record := range history {
+		line := translate(language, "whymoved.idle", record.MovedAt.In(config.Location).Format("2006-01-02 15:04"),
+			shortDuration(time.Duration(record.IdleTimeMs)*time.Millisecond))
+		if record.MaxIdleTimeMs > 0 {
+			line += translate(language, "whymoved.limit", shortDuration(time.Duration(record.MaxIdleTimeMs)*time.Millisecond))
+		}
+		// Moves recorded by older versions have no reason, and reasons of moves back are not explained.
+		if _, ok := translations[defaultLanguage]["reason."+record.Reason]; ok {
+			line += ", " + translate(language, "reason."+record.Reason)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}